@@ -0,0 +1,207 @@
+package bot
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
+)
+
+// Role defines what a telegram user is allowed to do,
+// each role includes permissions of the previous ones
+type Role int
+
+const (
+	RoleNone Role = iota
+	// Viewer may only run reports
+	RoleViewer
+	// Bookkeeper may add and confirm transactions
+	RoleBookkeeper
+	// Admin may manage config
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleBookkeeper:
+		return "bookkeeper"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+func ParseRole(s string) (Role, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "viewer":
+		return RoleViewer, nil
+	case "bookkeeper":
+		return RoleBookkeeper, nil
+	case "admin":
+		return RoleAdmin, nil
+	default:
+		return RoleNone, fmt.Errorf("unknown role '%s'", s)
+	}
+}
+
+// AccessList maps telegram user and chat ids to roles
+type AccessList struct {
+	Users map[int64]Role
+	Chats map[int64]Role
+}
+
+func NewAccessList() *AccessList {
+	return &AccessList{
+		Users: make(map[int64]Role),
+		Chats: make(map[int64]Role),
+	}
+}
+
+func parseAccessEntries(entries []string, dst map[int64]Role) error {
+	for _, e := range entries {
+		idS, roleS, ok := strings.Cut(e, ":")
+		if !ok {
+			return fmt.Errorf("invalid access entry '%s', expected id:role", e)
+		}
+		id, err := strconv.ParseInt(strings.TrimSpace(idS), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid id in access entry '%s': %v", e, err)
+		}
+		role, err := ParseRole(roleS)
+		if err != nil {
+			return fmt.Errorf("invalid access entry '%s': %v", e, err)
+		}
+		dst[id] = role
+	}
+	return nil
+}
+
+// ParseAccessList parses user and chat entries in the id:role format
+func ParseAccessList(users, chats []string) (*AccessList, error) {
+	al := NewAccessList()
+	if err := parseAccessEntries(users, al.Users); err != nil {
+		return nil, err
+	}
+	if err := parseAccessEntries(chats, al.Chats); err != nil {
+		return nil, err
+	}
+	return al, nil
+}
+
+// addConfig adds entries from the access section of teledger.yaml,
+// invalid roles are skipped
+func (al *AccessList) addConfig(users, chats map[int64]string) {
+	add := func(src map[int64]string, dst map[int64]Role) {
+		for id, roleS := range src {
+			role, err := ParseRole(roleS)
+			if err != nil {
+				slog.Warn("invalid role in config", "id", id, "error", err)
+				continue
+			}
+			if role > dst[id] {
+				dst[id] = role
+			}
+		}
+	}
+	add(users, al.Users)
+	add(chats, al.Chats)
+}
+
+func (al *AccessList) Empty() bool {
+	return len(al.Users) == 0 && len(al.Chats) == 0
+}
+
+// RoleOf returns the highest role granted to the user directly or
+// through the chat the user is writing from
+func (al *AccessList) RoleOf(userID, chatID int64) Role {
+	role := al.Users[userID]
+	if chatRole := al.Chats[chatID]; chatRole > role {
+		role = chatRole
+	}
+	return role
+}
+
+// accessList combines the access list from the options with
+// the one from the ledger config
+func (bot *Bot) accessList() *AccessList {
+	al := NewAccessList()
+	for id, r := range bot.access.Users {
+		al.Users[id] = r
+	}
+	for id, r := range bot.access.Chats {
+		al.Chats[id] = r
+	}
+	if cfg := bot.teledger.Ledger.CurrentConfig(); cfg != nil {
+		al.addConfig(cfg.Access.Users, cfg.Access.Chats)
+	}
+	return al
+}
+
+// roleOf returns the role of the sender of the update.
+// If no access list is configured, the bot is open for everyone.
+func (bot *Bot) roleOf(ctx *ext.Context) Role {
+	al := bot.accessList()
+	if al.Empty() {
+		return RoleAdmin
+	}
+
+	var userID, chatID int64
+	if ctx.EffectiveUser != nil {
+		userID = ctx.EffectiveUser.Id
+	}
+	if ctx.EffectiveChat != nil {
+		chatID = ctx.EffectiveChat.Id
+	}
+	return al.RoleOf(userID, chatID)
+}
+
+// restrict allows the handler only for the senders with at least the required role
+func (bot *Bot) restrict(required Role, name string, next handlers.Response) handlers.Response {
+	return func(b *gotgbot.Bot, ctx *ext.Context) error {
+		role := bot.roleOf(ctx)
+		if role >= required {
+			return next(b, ctx)
+		}
+
+		var userID, chatID int64
+		var username string
+		if ctx.EffectiveUser != nil {
+			userID = ctx.EffectiveUser.Id
+			username = ctx.EffectiveUser.Username
+		}
+		if ctx.EffectiveChat != nil {
+			chatID = ctx.EffectiveChat.Id
+		}
+		slog.Warn(
+			"access denied",
+			"handler", name,
+			"user_id", userID,
+			"from", username,
+			"chat_id", chatID,
+			"role", role,
+			"required", required,
+		)
+
+		// the ids are needed to grant access, as /whoami is restricted too
+		text := fmt.Sprintf("⛔ Access denied, %s role is required. Your user id is %d, chat id is %d", required, userID, chatID)
+		if cq := ctx.CallbackQuery; cq != nil {
+			_, err := b.AnswerCallbackQuery(cq.Id, &gotgbot.AnswerCallbackQueryOpts{
+				ShowAlert: true,
+				Text:      text,
+			})
+			return err
+		}
+		if ctx.EffectiveChat != nil {
+			_, err := b.SendMessage(ctx.EffectiveChat.Id, text, nil)
+			return err
+		}
+		return nil
+	}
+}
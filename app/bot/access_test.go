@@ -0,0 +1,131 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/mput/teledger/app/ledger"
+	"github.com/mput/teledger/app/repo"
+	"github.com/mput/teledger/app/teledger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAccessList(t *testing.T) {
+	al, err := ParseAccessList([]string{"100:admin", "200: viewer"}, []string{"-300:bookkeeper"})
+	require.NoError(t, err)
+	assert.Equal(t, map[int64]Role{100: RoleAdmin, 200: RoleViewer}, al.Users)
+	assert.Equal(t, map[int64]Role{-300: RoleBookkeeper}, al.Chats)
+
+	_, err = ParseAccessList([]string{"100"}, nil)
+	assert.ErrorContains(t, err, "expected id:role")
+
+	_, err = ParseAccessList([]string{"john:admin"}, nil)
+	assert.ErrorContains(t, err, "invalid id")
+
+	_, err = ParseAccessList(nil, []string{"100:owner"})
+	assert.ErrorContains(t, err, "unknown role 'owner'")
+
+	al, err = ParseAccessList(nil, nil)
+	require.NoError(t, err)
+	assert.True(t, al.Empty())
+}
+
+func TestAccessList_RoleOf(t *testing.T) {
+	al, err := ParseAccessList([]string{"100:admin", "200:viewer"}, []string{"-300:bookkeeper"})
+	require.NoError(t, err)
+
+	al.addConfig(
+		map[int64]string{200: "bookkeeper", 100: "viewer", 400: "unknown"},
+		map[int64]string{-500: "viewer"},
+	)
+
+	cases := []struct {
+		name   string
+		user   int64
+		chat   int64
+		expect Role
+	}{
+		{name: "admin in private chat", user: 100, chat: 100, expect: RoleAdmin},
+		{name: "config can't lower the role", user: 100, chat: -500, expect: RoleAdmin},
+		{name: "role raised by config", user: 200, chat: 200, expect: RoleBookkeeper},
+		{name: "unknown user in allowed chat", user: 999, chat: -300, expect: RoleBookkeeper},
+		{name: "unknown user in viewer chat", user: 999, chat: -500, expect: RoleViewer},
+		{name: "invalid role in config", user: 400, chat: 400, expect: RoleNone},
+		{name: "unknown user", user: 999, chat: 999, expect: RoleNone},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expect, al.RoleOf(c.user, c.chat))
+		})
+	}
+}
+
+// recordingClient records the requests to the bot API instead of sending them
+type recordingClient struct {
+	gotgbot.BaseBotClient
+	methods []string
+	texts   []string
+}
+
+func (c *recordingClient) RequestWithContext(_ context.Context, _, method string, params map[string]string, _ map[string]gotgbot.NamedReader, _ *gotgbot.RequestOpts) (json.RawMessage, error) {
+	c.methods = append(c.methods, method)
+	c.texts = append(c.texts, params["text"])
+	if method == "sendMessage" {
+		return json.RawMessage(`{"message_id":1,"date":0,"chat":{"id":999,"type":"private"}}`), nil
+	}
+	return json.RawMessage(`true`), nil
+}
+
+func TestBot_HandlersRestricted(t *testing.T) {
+	access, err := ParseAccessList([]string{"100:admin"}, nil)
+	require.NoError(t, err)
+	bot := &Bot{
+		opts:     &Opts{},
+		teledger: teledger.NewTeledger(ledger.NewLedger(&repo.Mock{Files: map[string]string{"main.ledger": ""}}, nil)),
+		access:   access,
+		edits:    newEditSessions(),
+	}
+	dispatcher := ext.NewDispatcher(nil)
+	bot.addHandlers(dispatcher)
+
+	unknown := gotgbot.User{Id: 999, Username: "stranger"}
+	chat := gotgbot.Chat{Id: 999, Type: "private"}
+	message := func(text string) *gotgbot.Update {
+		msg := &gotgbot.Message{MessageId: 1, From: &unknown, Chat: chat, Text: text}
+		if text[0] == '/' {
+			msg.Entities = []gotgbot.MessageEntity{{Type: "bot_command", Offset: 0, Length: int64(len(text))}}
+		}
+		return &gotgbot.Update{UpdateId: 1, Message: msg}
+	}
+
+	commands := []string{"/start", "/version", "/whoami", "/reports", "/balance", "/register", "/undo", "/queue", "/pr", "/reload", "/comment", "Tacos 10 EUR"}
+	for _, cmd := range commands {
+		t.Run(cmd, func(t *testing.T) {
+			client := &recordingClient{}
+			b := &gotgbot.Bot{Token: "test", User: gotgbot.User{Username: "teledger_bot"}, BotClient: client}
+			require.NoError(t, dispatcher.ProcessUpdate(b, message(cmd), nil))
+			require.Equal(t, []string{"sendMessage"}, client.methods)
+			assert.Contains(t, client.texts[0], "⛔ Access denied")
+			assert.Contains(t, client.texts[0], "Your user id is 999, chat id is 999")
+		})
+	}
+
+	t.Run("expired callback", func(t *testing.T) {
+		client := &recordingClient{}
+		b := &gotgbot.Bot{Token: "test", User: gotgbot.User{Username: "teledger_bot"}, BotClient: client}
+		u := &gotgbot.Update{UpdateId: 1, CallbackQuery: &gotgbot.CallbackQuery{
+			Id:      "1",
+			From:    unknown,
+			Message: &gotgbot.Message{MessageId: 1, Chat: chat},
+			Data:    expiredData,
+		}}
+		require.NoError(t, dispatcher.ProcessUpdate(b, u, nil))
+		require.Equal(t, []string{"answerCallbackQuery"}, client.methods)
+		assert.Contains(t, client.texts[0], "⛔ Access denied, bookkeeper role is required")
+	})
+}
@@ -2,12 +2,16 @@ package bot
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"log/slog"
+	"os"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext"
@@ -22,15 +26,72 @@ type Opts struct {
 		Token string `long:"token" env:"TOKEN" required:"true" description:"telegram bot token"`
 	} `group:"telegram" namespace:"telegram" env-namespace:"TELEGRAM"`
 
-	Github struct {
-		URL   string `long:"url" env:"URL" required:"true" description:"github repo url"`
-		Token string `long:"token" env:"TOKEN" required:"true" description:"fine-grained personal access tokens for repo with RW Contents scope"`
-	} `group:"github" namespace:"github" env-namespace:"GITHUB"`
+	Git struct {
+		URL              string   `long:"url" env:"URL" description:"git repo url, https or ssh, required unless --local.dir is set"`
+		Username         string   `long:"username" env:"USERNAME" description:"https username"`
+		Password         string   `long:"password" env:"PASSWORD" description:"https password or access token with RW Contents scope"`
+		SSHKey           string   `long:"ssh-key" env:"SSH_KEY" description:"path to the ssh private key, e.g. a deploy key, ssh agent is used if empty"`
+		SSHKeyPassphrase string   `long:"ssh-key-passphrase" env:"SSH_KEY_PASSPHRASE" description:"passphrase of the ssh private key"`
+		Branch           string   `long:"branch" env:"BRANCH" description:"branch the transactions are committed to, the remote default HEAD if empty"`
+		KnownHosts       []string `long:"known-hosts" env:"KNOWN_HOSTS" env-delim:"," description:"known_hosts file to verify the ssh server, ~/.ssh/known_hosts if empty"`
+		Clone            string   `long:"clone" env:"CLONE" default:"memory" choice:"memory" choice:"disk" choice:"fresh" description:"keep the clone in memory or on disk and fetch changes only, or clone the repo for every operation"`
+		CloneDir         string   `long:"clone-dir" env:"CLONE_DIR" default:"teledger-repo" description:"directory of the clone for the disk mode"`
+	} `group:"git" namespace:"git" env-namespace:"GIT"`
+
+	Local struct {
+		Dir      string `long:"dir" env:"DIR" description:"local directory with the ledger files, used instead of the git repo"`
+		NoCommit bool   `long:"no-commit" env:"NO_COMMIT" description:"don't commit the changes if the directory is a git repo"`
+	} `group:"local" namespace:"local" env-namespace:"LOCAL"`
+
+	PR struct {
+		Mode   string `long:"mode" env:"MODE" default:"off" choice:"off" choice:"day" choice:"user" description:"commit the changes to a branch per day or per user and open a pull request to review them"`
+		Base   string `long:"base" env:"BASE" description:"branch the pull requests are merged to, --git.branch or the remote default HEAD if empty"`
+		Prefix string `long:"prefix" env:"PREFIX" default:"teledger/" description:"prefix of the branches with the changes"`
+		Forge  string `long:"forge" env:"FORGE" default:"github" choice:"github" description:"api used to open pull requests"`
+		APIURL string `long:"api-url" env:"API_URL" description:"forge api url, e.g. of github enterprise server"`
+		Token  string `long:"token" env:"TOKEN" description:"forge api token with RW pull requests scope, --git.password if empty"`
+	} `group:"pr" namespace:"pr" env-namespace:"PR"`
+
+	LLM struct {
+		Provider    string  `long:"provider" env:"PROVIDER" default:"openai" choice:"openai" choice:"openai-compatible" choice:"anthropic" choice:"rules" description:"llm provider used to generate transactions"`
+		Model       string  `long:"model" env:"MODEL" description:"model name, provider default if empty"`
+		BaseURL     string  `long:"base-url" env:"BASE_URL" description:"api base url, e.g. http://localhost:11434/v1 for ollama"`
+		Temperature float32 `long:"temperature" env:"TEMPERATURE" description:"sampling temperature, provider default if empty"`
+	} `group:"llm" namespace:"llm" env-namespace:"LLM"`
 
 	OpenAI struct {
-		Token string `long:"token" env:"TOKEN" required:"true" description:"openai api token"`
+		Token string `long:"token" env:"TOKEN" description:"openai api token, required for openai provider"`
 	} `group:"openai" namespace:"openai" env-namespace:"OPENAI"`
 
+	Anthropic struct {
+		Token string `long:"token" env:"TOKEN" description:"anthropic api key, required for anthropic provider"`
+	} `group:"anthropic" namespace:"anthropic" env-namespace:"ANTHROPIC"`
+
+	Sign struct {
+		Format         string `long:"format" env:"FORMAT" default:"off" choice:"off" choice:"openpgp" choice:"ssh" description:"sign the commits with an openpgp or an ssh key"`
+		Key            string `long:"key" env:"KEY" description:"path to the armored openpgp private key or the ssh private key"`
+		Passphrase     string `long:"passphrase" env:"PASSPHRASE" description:"passphrase of the signing key"`
+		PassphraseFile string `long:"passphrase-file" env:"PASSPHRASE_FILE" description:"file with the passphrase of the signing key"`
+	} `group:"sign" namespace:"sign" env-namespace:"SIGN"`
+
+	Pending struct {
+		Store  string        `long:"store" env:"STORE" default:"memory" choice:"memory" choice:"file" choice:"git" description:"where transactions waiting for confirmation are kept"`
+		Path   string        `long:"path" env:"PATH" default:"pending.json" description:"json file for the file store"`
+		Branch string        `long:"branch" env:"BRANCH" default:"teledger-pending" description:"branch of the ledger repo for the git store"`
+		TTL    time.Duration `long:"ttl" env:"TTL" default:"24h" description:"how long a transaction waits for confirmation"`
+	} `group:"pending" namespace:"pending" env-namespace:"PENDING"`
+
+	Outbox struct {
+		Path       string        `long:"path" env:"PATH" default:"outbox.json" description:"json file with the changes queued while the git remote is unavailable, the errors are shown instead if empty"`
+		MinBackoff time.Duration `long:"min-backoff" env:"MIN_BACKOFF" default:"30s" description:"delay before the first retry of the queued changes, it doubles after every failed attempt"`
+		MaxBackoff time.Duration `long:"max-backoff" env:"MAX_BACKOFF" default:"30m" description:"longest delay between the retries of the queued changes"`
+	} `group:"outbox" namespace:"outbox" env-namespace:"OUTBOX"`
+
+	Access struct {
+		Users []string `long:"user" env:"USERS" env-delim:"," description:"telegram user allowed to use the bot, as id:role (viewer, bookkeeper or admin)"`
+		Chats []string `long:"chat" env:"CHATS" env-delim:"," description:"telegram chat allowed to use the bot, as id:role (viewer, bookkeeper or admin)"`
+	} `group:"access" namespace:"access" env-namespace:"ACCESS"`
+
 	// URL string `long:"url" env:"URL" required:"true" description:"bot url"`
 	Version string
 }
@@ -39,36 +100,218 @@ type Bot struct {
 	opts     *Opts
 	teledger *teledger.Teledger
 	bot      *gotgbot.Bot
+	access   *AccessList
+	edits    *editSessions
+	// nil if the pull request mode is off
+	prs *repo.PullRequestRepo
 }
 
 func NewBot(opts *Opts) (*Bot, error) {
+	access, err := ParseAccessList(opts.Access.Users, opts.Access.Chats)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse access list: %v", err)
+	}
+	err = validateRepoOpts(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	b, err := gotgbot.NewBot(opts.Telegram.Token, nil)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create bot: %v", err)
 	}
 
-	rs := repo.NewInMemoryRepo(opts.Github.URL, opts.Github.Token)
-	llmGenerator := ledger.NewOpenAITransactionGenerator(opts.OpenAI.Token)
+	signer, err := newSigner(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs repo.Service
+	if opts.Local.Dir != "" {
+		rs = repo.NewLocalRepo(opts.Local.Dir, !opts.Local.NoCommit).WithSigner(signer)
+	} else {
+		rs = newRepo(opts, opts.Git.Branch, opts.Git.CloneDir, signer)
+	}
+	var prs *repo.PullRequestRepo
+	if opts.PR.Mode != "off" {
+		prs, err = newPullRequestRepo(opts, signer)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create pull request repo: %v", err)
+		}
+		rs = prs
+	}
+	llmOpts := ledger.LLMOpts{
+		Token:       opts.OpenAI.Token,
+		Model:       opts.LLM.Model,
+		BaseURL:     opts.LLM.BaseURL,
+		Temperature: opts.LLM.Temperature,
+	}
+	if opts.LLM.Provider == ledger.ProviderAnthropic {
+		llmOpts.Token = opts.Anthropic.Token
+	}
+	llmGenerator, err := ledger.NewTransactionGenerator(opts.LLM.Provider, llmOpts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create transaction generator: %v", err)
+	}
 
 	ldgr := ledger.NewLedger(rs, llmGenerator)
 	tel := teledger.NewTeledger(ldgr)
+	tel.PendingTTL = opts.Pending.TTL
+	switch opts.Pending.Store {
+	case "file":
+		tel.Pending = teledger.NewFilePendingStore(opts.Pending.Path)
+	case "git":
+		tel.Pending = teledger.NewGitPendingStore(
+			newRepo(opts, opts.Pending.Branch, opts.Git.CloneDir+"-"+opts.Pending.Branch, signer),
+		)
+	}
+
+	// the local directory is always available
+	if opts.Outbox.Path != "" && opts.Local.Dir == "" {
+		outbox, err := teledger.NewOutbox(opts.Outbox.Path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open outbox: %v", err)
+		}
+		tel.Outbox = outbox.WithBackoff(opts.Outbox.MinBackoff, opts.Outbox.MaxBackoff)
+	}
 
 	err = tel.Init()
 	if err != nil {
 		return nil, fmt.Errorf("unable to init teledger: %v", err)
 	}
 
-	return &Bot{
+	bot := &Bot{
 		opts:     opts,
 		teledger: tel,
 		bot:      b,
-	}, nil
+		access:   access,
+		edits:    newEditSessions(),
+		prs:      prs,
+	}
+
+	if bot.accessList().Empty() {
+		slog.Warn("access list is empty, the bot is available to everyone")
+	}
+
+	return bot, nil
+}
+
+// validateRepoOpts checks the options of the ledger repo
+func validateRepoOpts(opts *Opts) error {
+	if opts.Local.Dir == "" {
+		if opts.Git.URL == "" {
+			return fmt.Errorf("either --git.url or --local.dir is required")
+		}
+		return nil
+	}
+	if opts.Git.URL != "" {
+		return fmt.Errorf("--git.url and --local.dir can't be used together")
+	}
+	if opts.PR.Mode != "off" {
+		return fmt.Errorf("pull request mode requires --git.url")
+	}
+	if opts.Pending.Store == "git" {
+		return fmt.Errorf("git pending store requires --git.url")
+	}
+	return nil
+}
+
+func gitAuth(opts *Opts) repo.Auth {
+	return repo.Auth{
+		Username:         opts.Git.Username,
+		Password:         opts.Git.Password,
+		SSHKey:           opts.Git.SSHKey,
+		SSHKeyPassphrase: opts.Git.SSHKeyPassphrase,
+		KnownHosts:       opts.Git.KnownHosts,
+	}
+}
+
+// signingPassphrase returns the passphrase of the signing key,
+// the trailing newline of the passphrase file is ignored
+func signingPassphrase(opts *Opts) ([]byte, error) {
+	if opts.Sign.PassphraseFile == "" {
+		return []byte(opts.Sign.Passphrase), nil
+	}
+	if opts.Sign.Passphrase != "" {
+		return nil, fmt.Errorf("--sign.passphrase and --sign.passphrase-file can't be used together")
+	}
+	data, err := os.ReadFile(opts.Sign.PassphraseFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read signing key passphrase: %v", err)
+	}
+	return bytes.TrimRight(data, "\r\n"), nil
+}
+
+// newSigner creates the signer of the commits, nil if signing is off
+func newSigner(opts *Opts) (repo.Signer, error) {
+	if opts.Sign.Format == "" || opts.Sign.Format == "off" {
+		return nil, nil
+	}
+	if opts.Sign.Key == "" {
+		return nil, fmt.Errorf("--sign.key is required to sign the commits")
+	}
+	passphrase, err := signingPassphrase(opts)
+	if err != nil {
+		return nil, err
+	}
+	switch opts.Sign.Format {
+	case "openpgp":
+		return repo.NewPGPSigner(opts.Sign.Key, passphrase)
+	case "ssh":
+		return repo.NewSSHSigner(opts.Sign.Key, passphrase)
+	}
+	return nil, fmt.Errorf("unknown signing format %q", opts.Sign.Format)
+}
+
+// newRepo creates the repo service for the branch according to the clone mode,
+// dir is used for the clone on disk
+func newRepo(opts *Opts, branch, dir string, signer repo.Signer) repo.Service {
+	auth := gitAuth(opts)
+	switch opts.Git.Clone {
+	case "fresh":
+		return repo.NewInMemoryRepo(opts.Git.URL, auth).WithBranch(branch).WithSigner(signer)
+	case "disk":
+		return repo.NewPersistentRepo(opts.Git.URL, auth, dir).WithBranch(branch).WithSigner(signer)
+	default:
+		return repo.NewPersistentRepo(opts.Git.URL, auth, "").WithBranch(branch).WithSigner(signer)
+	}
+}
+
+// newPullRequestRepo creates the repo committing the changes to the pull requests
+func newPullRequestRepo(opts *Opts, signer repo.Signer) (*repo.PullRequestRepo, error) {
+	token := opts.PR.Token
+	if token == "" {
+		token = opts.Git.Password
+	}
+	gh, err := repo.NewGitHubForge(opts.Git.URL, token)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PR.APIURL != "" {
+		gh.WithAPIURL(opts.PR.APIURL)
+	}
+	base := opts.PR.Base
+	if base == "" {
+		base = opts.Git.Branch
+	}
+	return repo.NewPullRequestRepo(opts.Git.URL, gitAuth(opts), gh, repo.PullRequestMode(opts.PR.Mode)).
+		WithBase(base).
+		WithPrefix(opts.PR.Prefix).
+		WithSigner(signer), nil
 }
 
 func (bot *Bot) Start() error {
 	defaultCommands := []gotgbot.BotCommand{
 		{Command: "reports", Description: "Show available reports"},
+		{Command: "balance", Description: "Show the balances of the accounts, e.g. /balance expenses"},
+		{Command: "register", Description: "Show the postings of the accounts, e.g. /register food"},
 		{Command: "version", Description: "Show version"},
+		{Command: "whoami", Description: "Show your telegram user and chat ids"},
+		{Command: "undo", Description: "Undo one of the recent changes"},
+		{Command: "queue", Description: "Show the changes waiting for the repository"},
+	}
+	if bot.prs != nil {
+		defaultCommands = append(defaultCommands, gotgbot.BotCommand{Command: "pr", Description: "Show the pull request with your changes"})
 	}
 	smcRes, err := bot.bot.SetMyCommands(defaultCommands, nil)
 	if err != nil {
@@ -87,17 +330,10 @@ func (bot *Bot) Start() error {
 
 	updater := ext.NewUpdater(dispatcher, nil)
 
-	dispatcher.AddHandler(handlers.NewCommand("reports", wrapUserResponse(bot.showAvailableReports, "reports")))
-	dispatcher.AddHandler(handlers.NewCallback(isReportCallback, wrapUserResponse(bot.showReport, "show-report")))
+	bot.teledger.StartSweeper(context.Background(), sweepInterval, bot.markExpired)
+	bot.teledger.StartOutbox(context.Background(), outboxInterval, bot.notifyQueued)
 
-	dispatcher.AddHandler(handlers.NewCommand("start", wrapUserResponse(start, "start")))
-	dispatcher.AddHandler(handlers.NewCommand("version", wrapUserResponse(bot.vesrion, "version")))
-
-	// these handlers should be at the end, as they are less specific
-	dispatcher.AddHandler(handlers.NewCommand("/", wrapUserResponse(bot.comment, "comment")))
-	dispatcher.AddHandler(handlers.NewMessage(nil, wrapUserResponse(bot.proposeTransaction, "propose-transaction")))
-	dispatcher.AddHandler(handlers.NewCallback(isConfirmCallback, bot.confirmTransaction))
-	dispatcher.AddHandler(handlers.NewCallback(isDeleteCallback, bot.deleteTransaction))
+	bot.addHandlers(dispatcher)
 
 	// Start receiving updates.
 	err = updater.StartPolling(bot.bot, &ext.PollingOpts{
@@ -118,9 +354,44 @@ func (bot *Bot) Start() error {
 	return nil
 }
 
+// addHandlers registers the handlers of the commands, messages and callbacks,
+// every one of them is restricted to the senders with the required role
+func (bot *Bot) addHandlers(dispatcher *ext.Dispatcher) {
+	dispatcher.AddHandler(handlers.NewCommand("reports", bot.restrict(RoleViewer, "reports", wrapUserResponse(bot.showAvailableReports, "reports"))))
+	dispatcher.AddHandler(handlers.NewCommand("balance", bot.restrict(RoleViewer, "balance", wrapUserResponse(bot.showBalance, "balance"))))
+	dispatcher.AddHandler(handlers.NewCommand("register", bot.restrict(RoleViewer, "register", wrapUserResponse(bot.showRegister, "register"))))
+	dispatcher.AddHandler(handlers.NewCallback(isReportCallback, bot.restrict(RoleViewer, "show-report", wrapUserResponse(bot.showReport, "show-report"))))
+
+	dispatcher.AddHandler(handlers.NewCommand("start", bot.restrict(RoleViewer, "start", wrapUserResponse(start, "start"))))
+	dispatcher.AddHandler(handlers.NewCommand("version", bot.restrict(RoleViewer, "version", wrapUserResponse(bot.vesrion, "version"))))
+	dispatcher.AddHandler(handlers.NewCommand("whoami", bot.restrict(RoleViewer, "whoami", wrapUserResponse(bot.whoami, "whoami"))))
+	dispatcher.AddHandler(handlers.NewCommand("pr", bot.restrict(RoleBookkeeper, "pr", wrapUserResponse(bot.pullRequest, "pr"))))
+	dispatcher.AddHandler(handlers.NewCommand("queue", bot.restrict(RoleBookkeeper, "queue", wrapUserResponse(bot.showQueue, "queue"))))
+	dispatcher.AddHandler(handlers.NewCommand("undo", bot.restrict(RoleBookkeeper, "undo", wrapUserResponse(bot.undo, "undo"))))
+	dispatcher.AddHandler(handlers.NewCallback(isUndoCallback, bot.restrict(RoleBookkeeper, "undo-change", bot.undoChange)))
+	dispatcher.AddHandler(handlers.NewCommand("reload", bot.restrict(RoleAdmin, "reload", wrapUserResponse(bot.reload, "reload"))))
+
+	// these handlers should be at the end, as they are less specific
+	dispatcher.AddHandler(handlers.NewCommand("/", bot.restrict(RoleBookkeeper, "comment", wrapUserResponse(bot.comment, "comment"))))
+	dispatcher.AddHandler(handlers.NewMessage(bot.isEditReply, bot.restrict(RoleBookkeeper, "edit-transaction", wrapUserResponse(bot.applyEdit, "edit-transaction"))))
+	dispatcher.AddHandler(handlers.NewMessage(isAmendReply, bot.restrict(RoleBookkeeper, "amend-proposal", wrapUserResponse(bot.amendProposal, "amend-proposal"))))
+	dispatcher.AddHandler(handlers.NewMessage(nil, bot.restrict(RoleBookkeeper, "propose-transaction", wrapUserResponseWith(bot.proposeTransaction, "propose-transaction", bot.attachProposal))))
+	dispatcher.AddHandler(handlers.NewCallback(isConfirmCallback, bot.restrict(RoleBookkeeper, "confirm-transaction", bot.confirmTransaction)))
+	dispatcher.AddHandler(handlers.NewCallback(isDeleteCallback, bot.restrict(RoleBookkeeper, "delete-transaction", bot.deleteTransaction)))
+	dispatcher.AddHandler(handlers.NewCallback(isEditCallback, bot.restrict(RoleBookkeeper, "edit-transaction", bot.editTransaction)))
+	dispatcher.AddHandler(handlers.NewCallback(isExpiredCallback, bot.restrict(RoleBookkeeper, "expired-transaction", bot.expiredTransaction)))
+}
+
 type response func(ctx *ext.Context) (msg string, opts *gotgbot.SendMessageOpts, err error)
 
+// sentHook is called after the response has been sent
+type sentHook func(ctx *ext.Context, sent *gotgbot.Message)
+
 func wrapUserResponse(next response, name string) handlers.Response {
+	return wrapUserResponseWith(next, name, nil)
+}
+
+func wrapUserResponseWith(next response, name string, onSent sentHook) handlers.Response {
 	return func(b *gotgbot.Bot, ctx *ext.Context) error {
 		start := time.Now()
 		msg := ctx.EffectiveMessage
@@ -142,7 +413,7 @@ func wrapUserResponse(next response, name string) handlers.Response {
 			)
 		}
 		if resp != "" {
-			_, ierr := b.SendMessage(msg.Chat.Id, resp, opts)
+			sent, ierr := b.SendMessage(msg.Chat.Id, resp, opts)
 			if ierr != nil {
 				slog.Error(
 					"unable to send response",
@@ -151,12 +422,28 @@ func wrapUserResponse(next response, name string) handlers.Response {
 					"from", msg.From.Username,
 					"handler", name,
 				)
+			} else if onSent != nil {
+				onSent(ctx, sent)
 			}
 		}
 		return err
 	}
 }
 
+// ledgerUser returns the author of the change, the name is
+// the telegram username if it's set, the first name otherwise,
+// the changes are made from the chat
+func ledgerUser(u *gotgbot.User, chatID int64) ledger.User {
+	if u == nil {
+		return ledger.User{ChatID: chatID}
+	}
+	name := u.Username
+	if name == "" {
+		name = u.FirstName
+	}
+	return ledger.User{ID: u.Id, Name: name, ChatID: chatID}
+}
+
 func start(_ *ext.Context) (string, *gotgbot.SendMessageOpts, error) {
 	return "Welcome to teledger bot!", nil, nil
 }
@@ -169,6 +456,31 @@ func (bot *Bot) vesrion(_ *ext.Context) (string, *gotgbot.SendMessageOpts, error
 		nil
 }
 
+func (bot *Bot) whoami(ctx *ext.Context) (string, *gotgbot.SendMessageOpts, error) {
+	msg := ctx.EffectiveMessage
+	return fmt.Sprintf(
+			"user id: %d\nchat id: %d\nrole: %s",
+			msg.From.Id,
+			msg.Chat.Id,
+			bot.roleOf(ctx),
+		),
+		&gotgbot.SendMessageOpts{
+			DisableNotification: true,
+		},
+		nil
+}
+
+// reload re-reads the ledger config from the repository
+func (bot *Bot) reload(_ *ext.Context) (string, *gotgbot.SendMessageOpts, error) {
+	err := bot.teledger.Init()
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err), nil, nil
+	}
+	return "Config reloaded", &gotgbot.SendMessageOpts{
+		DisableNotification: true,
+	}, nil
+}
+
 func (bot *Bot) comment(ctx *ext.Context) (string, *gotgbot.SendMessageOpts, error) {
 	msg := ctx.EffectiveMessage
 	text := strings.TrimPrefix(msg.Text, "//")
@@ -179,7 +491,10 @@ func (bot *Bot) comment(ctx *ext.Context) (string, *gotgbot.SendMessageOpts, err
 		return "Empty comment!", nil, nil
 	}
 
-	comment, err := bot.teledger.AddComment(text)
+	comment, err := bot.teledger.AddComment(text, ledgerUser(msg.From, msg.Chat.Id))
+	if errors.Is(err, teledger.ErrQueued) {
+		return queuedNote, nil, nil
+	}
 	if err != nil {
 		return fmt.Sprintf("Error: %v", err), nil, nil
 	}
@@ -197,17 +512,37 @@ var proposeTemplate = template.Must(template.New("letter").Parse(proposeTemplate
 func (bot *Bot) proposeTransaction(ctx *ext.Context) (string, *gotgbot.SendMessageOpts, error) {
 	msg := ctx.EffectiveMessage
 
-	pendTr := bot.teledger.ProposeTransaction(msg.Text)
+	pendTr := bot.teledger.ProposeTransaction(msg.Text, ledgerUser(msg.From, msg.Chat.Id))
 
 	var buf bytes.Buffer
 	err := proposeTemplate.Execute(&buf, pendTr)
 	if err != nil {
 		return "", nil, fmt.Errorf("unable to execute template: %v", err)
 	}
-
-	inlineKeyboard := [][]gotgbot.InlineKeyboardButton{}
+	if pendTr.Committed {
+		buf.WriteString(bot.pullRequestNote(ledgerUser(msg.From, msg.Chat.Id)))
+	}
 
 	if key := pendTr.PendingKey; key != "" {
+		if ctx.Data == nil {
+			ctx.Data = make(map[string]interface{})
+		}
+		ctx.Data[pendingKeyData] = key
+	}
+
+	return buf.String(), &gotgbot.SendMessageOpts{
+		ParseMode: "HTML",
+		// ReplyParameters: &gotgbot.ReplyParameters{MessageId: msg.MessageId},
+		DisableNotification: true,
+		ReplyMarkup:         proposalKeyboard(pendTr.PendingKey),
+	}, nil
+}
+
+// proposalKeyboard is shown under a proposed transaction,
+// it's empty if there is nothing to confirm
+func proposalKeyboard(key string) gotgbot.InlineKeyboardMarkup {
+	inlineKeyboard := [][]gotgbot.InlineKeyboardButton{}
+	if key != "" {
 		inlineKeyboard = append(inlineKeyboard, []gotgbot.InlineKeyboardButton{
 			{
 				Text:         "✅ Confirm",
@@ -215,19 +550,125 @@ func (bot *Bot) proposeTransaction(ctx *ext.Context) (string, *gotgbot.SendMessa
 			},
 		})
 	}
+	return gotgbot.InlineKeyboardMarkup{
+		InlineKeyboard: inlineKeyboard,
+	}
+}
 
-	return buf.String(), &gotgbot.SendMessageOpts{
-		ParseMode: "HTML",
-		// ReplyParameters: &gotgbot.ReplyParameters{MessageId: msg.MessageId},
-		DisableNotification: true,
-		ReplyMarkup: gotgbot.InlineKeyboardMarkup{
-			InlineKeyboard: inlineKeyboard,
+// proposalKey returns the pending key of the proposal the message replies to
+func proposalKey(msg *gotgbot.Message) (string, bool) {
+	if msg == nil || msg.ReplyToMessage == nil || msg.ReplyToMessage.ReplyMarkup == nil {
+		return "", false
+	}
+	for _, row := range msg.ReplyToMessage.ReplyMarkup.InlineKeyboard {
+		for _, button := range row {
+			if strings.HasPrefix(button.CallbackData, confirmPrefix) {
+				return strings.TrimPrefix(button.CallbackData, confirmPrefix), true
+			}
+		}
+	}
+	return "", false
+}
+
+// isAmendReply reports whether the message is a reply to a proposal
+func isAmendReply(msg *gotgbot.Message) bool {
+	_, ok := proposalKey(msg)
+	return ok
+}
+
+// amendProposal corrects the proposal the message replies to
+// and updates the message with the proposal
+func (bot *Bot) amendProposal(ctx *ext.Context) (string, *gotgbot.SendMessageOpts, error) {
+	msg := ctx.EffectiveMessage
+	key, _ := proposalKey(msg)
+	replyOpts := &gotgbot.SendMessageOpts{
+		ReplyParameters: &gotgbot.ReplyParameters{MessageId: msg.MessageId},
+	}
+
+	pendTr, err := bot.teledger.AmendProposal(key, msg.Text, ledgerUser(msg.From, msg.Chat.Id))
+	if err != nil {
+		return fmt.Sprintf("🛑 Error:\n%v", err), replyOpts, nil
+	}
+
+	var buf bytes.Buffer
+	err = proposeTemplate.Execute(&buf, pendTr)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to execute template: %v", err)
+	}
+
+	_, _, err = bot.bot.EditMessageText(
+		buf.String(),
+		&gotgbot.EditMessageTextOpts{
+			ChatId:      msg.Chat.Id,
+			MessageId:   msg.ReplyToMessage.MessageId,
+			ParseMode:   "HTML",
+			ReplyMarkup: proposalKeyboard(key),
 		},
-	}, nil
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to edit message: %v", err)
+	}
+	return "", nil, nil
+}
+
+const pendingKeyData = "pending-key"
+
+// attachProposal remembers the message with the proposal,
+// so it's possible to mark it as expired later
+func (bot *Bot) attachProposal(ctx *ext.Context, sent *gotgbot.Message) {
+	key, ok := ctx.Data[pendingKeyData].(string)
+	if !ok {
+		return
+	}
+	err := bot.teledger.AttachMessage(key, sent.Chat.Id, sent.MessageId)
+	if err != nil {
+		slog.Error("unable to attach message to pending transaction", "key", key, "error", err)
+	}
+}
+
+const sweepInterval = time.Minute
+
+var expiredKeyboard = gotgbot.InlineKeyboardMarkup{
+	InlineKeyboard: [][]gotgbot.InlineKeyboardButton{
+		{
+			{
+				Text:         "⌛ Expired",
+				CallbackData: expiredData,
+			},
+		},
+	},
+}
+
+// markExpired replaces the confirm button of the expired proposal
+func (bot *Bot) markExpired(pt *teledger.PendingTransaction) {
+	if pt.ChatID == 0 || pt.MessageID == 0 {
+		return
+	}
+	_, _, err := bot.bot.EditMessageReplyMarkup(&gotgbot.EditMessageReplyMarkupOpts{
+		ChatId:      pt.ChatID,
+		MessageId:   pt.MessageID,
+		ReplyMarkup: expiredKeyboard,
+	})
+	if err != nil {
+		slog.Error("unable to mark proposal as expired", "key", pt.PendingKey, "error", err)
+	}
+}
+
+func (bot *Bot) expiredTransaction(_ *gotgbot.Bot, ctx *ext.Context) error {
+	_, err := bot.bot.AnswerCallbackQuery(ctx.CallbackQuery.Id, &gotgbot.AnswerCallbackQueryOpts{
+		Text: "⌛ The transaction has expired, send it again",
+	})
+	if err != nil {
+		slog.Error("unable to answer callback query", "error", err)
+	}
+	return nil
 }
 
 func (bot *Bot) showAvailableReports(_ *ext.Context) (string, *gotgbot.SendMessageOpts, error) {
-	reports := bot.teledger.Ledger.Config.Reports
+	var reports []ledger.Report
+	if cfg := bot.teledger.Ledger.CurrentConfig(); cfg != nil {
+		reports = cfg.Reports
+	}
 
 	inlineKeyboard := [][]gotgbot.InlineKeyboardButton{}
 
@@ -258,8 +699,13 @@ func isReportCallback(cb *gotgbot.CallbackQuery) bool {
 const (
 	confirmPrefix = "cf:"
 	deletePrefix  = "rm:"
+	expiredData   = "expired"
 )
 
+func isExpiredCallback(cb *gotgbot.CallbackQuery) bool {
+	return cb.Data == expiredData
+}
+
 func isConfirmCallback(cb *gotgbot.CallbackQuery) bool {
 	return strings.HasPrefix(cb.Data, confirmPrefix)
 }
@@ -284,7 +730,7 @@ func (bot *Bot) confirmTransaction(_ *gotgbot.Bot, ctx *ext.Context) error {
 	}
 
 	key := strings.TrimPrefix(cq.Data, confirmPrefix)
-	pendTr, err := bot.teledger.ConfirmTransaction(key)
+	pendTr, err := bot.teledger.ConfirmTransaction(key, ledgerUser(&cq.From, cq.Message.GetChat().Id))
 
 	var newMessageContent bytes.Buffer
 	if err == nil {
@@ -292,7 +738,9 @@ func (bot *Bot) confirmTransaction(_ *gotgbot.Bot, ctx *ext.Context) error {
 		if err2 != nil {
 			err = err2
 		}
-
+		if !pendTr.Queued {
+			newMessageContent.WriteString(bot.pullRequestNote(ledgerUser(&cq.From, cq.Message.GetChat().Id)))
+		}
 	}
 
 	if err != nil {
@@ -301,18 +749,28 @@ func (bot *Bot) confirmTransaction(_ *gotgbot.Bot, ctx *ext.Context) error {
 			Text:      fmt.Sprintf("🛑️ Error!\n%s", err),
 		})
 
+		markup := gotgbot.InlineKeyboardMarkup{}
+		if errors.Is(err, teledger.ErrPendingExpired) || errors.Is(err, teledger.ErrPendingNotFound) {
+			markup = expiredKeyboard
+		}
 		_, _, _ = bot.bot.EditMessageReplyMarkup(
 			&gotgbot.EditMessageReplyMarkupOpts{
 				MessageId:       cq.Message.GetMessageId(),
 				ChatId:          cq.Message.GetChat().Id,
 				InlineMessageId: cq.InlineMessageId,
-				ReplyMarkup:     gotgbot.InlineKeyboardMarkup{},
+				ReplyMarkup:     markup,
 			},
 		)
 
 		return nil
 	}
 
+	// the queued transaction isn't in the journal yet, the buttons
+	// are added when it's committed
+	markup := committedKeyboard(key)
+	if pendTr.Queued {
+		markup = gotgbot.InlineKeyboardMarkup{}
+	}
 	_, _, err = bot.bot.EditMessageText(
 		newMessageContent.String(),
 		&gotgbot.EditMessageTextOpts{
@@ -320,24 +778,19 @@ func (bot *Bot) confirmTransaction(_ *gotgbot.Bot, ctx *ext.Context) error {
 			ChatId:          cq.Message.GetChat().Id,
 			InlineMessageId: cq.InlineMessageId,
 			ParseMode:       "HTML",
-			ReplyMarkup: gotgbot.InlineKeyboardMarkup{
-				InlineKeyboard: [][]gotgbot.InlineKeyboardButton{
-					{
-						{
-							Text:         "🛑 Delete",
-							CallbackData: fmt.Sprint(deletePrefix, key),
-						},
-					},
-				},
-			},
+			ReplyMarkup:     markup,
 		},
 	)
 	if err != nil {
 		slog.Error("unable to edit message", "error", err)
 	}
 
+	answer := "✔️ confirmed"
+	if pendTr.Queued {
+		answer = "📮 queued"
+	}
 	_, err = bot.bot.AnswerCallbackQuery(cq.Id, &gotgbot.AnswerCallbackQueryOpts{
-		Text: "✔️ confirmed",
+		Text: answer,
 	})
 	if err != nil {
 		slog.Error("unable to answer callback query", "error", err)
@@ -349,7 +802,14 @@ func (bot *Bot) deleteTransaction(_ *gotgbot.Bot, ctx *ext.Context) error {
 	cq := ctx.CallbackQuery
 
 	key := strings.TrimPrefix(cq.Data, deletePrefix)
-	err := bot.teledger.DeleteTransaction(key)
+	err := bot.teledger.DeleteTransaction(key, ledgerUser(&cq.From, cq.Message.GetChat().Id))
+	if errors.Is(err, teledger.ErrQueued) {
+		_, _ = bot.bot.AnswerCallbackQuery(cq.Id, &gotgbot.AnswerCallbackQueryOpts{
+			ShowAlert: true,
+			Text:      queuedNote,
+		})
+		return nil
+	}
 	if err != nil {
 		_, _ = bot.bot.AnswerCallbackQuery(cq.Id, &gotgbot.AnswerCallbackQueryOpts{
 			ShowAlert: true,
@@ -378,6 +838,56 @@ func (bot *Bot) deleteTransaction(_ *gotgbot.Bot, ctx *ext.Context) error {
 	return nil
 }
 
+// showBalance shows the balances of the accounts matching the query after the command
+func (bot *Bot) showBalance(ctx *ext.Context) (string, *gotgbot.SendMessageOpts, error) {
+	query, err := ledger.ParseQuery(commandArgs(ctx.EffectiveMessage.Text))
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err), nil, nil
+	}
+	rows, err := bot.teledger.Balance(query...)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err), nil, nil
+	}
+	if len(rows) == 0 {
+		return "No accounts match the query", nil, nil
+	}
+	return codeBlock(ledger.FormatBalance(rows))
+}
+
+// showRegister shows the postings matching the query after the command
+func (bot *Bot) showRegister(ctx *ext.Context) (string, *gotgbot.SendMessageOpts, error) {
+	query, err := ledger.ParseQuery(commandArgs(ctx.EffectiveMessage.Text))
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err), nil, nil
+	}
+	rows, err := bot.teledger.Register(query...)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err), nil, nil
+	}
+	if len(rows) == 0 {
+		return "No postings match the query", nil, nil
+	}
+	return codeBlock(ledger.FormatRegister(rows))
+}
+
+// commandArgs returns the text after the command
+func commandArgs(text string) string {
+	text = strings.TrimSpace(text)
+	i := strings.IndexFunc(text, unicode.IsSpace)
+	if i < 0 {
+		return ""
+	}
+	return text[i:]
+}
+
+// codeBlock sends the report as is
+func codeBlock(report string) (string, *gotgbot.SendMessageOpts, error) {
+	return fmt.Sprintf("```\n%s\n```", report), &gotgbot.SendMessageOpts{
+		ParseMode:           "MarkdownV2",
+		DisableNotification: true,
+	}, nil
+}
+
 func (bot *Bot) showReport(ctx *ext.Context) (string, *gotgbot.SendMessageOpts, error) {
 	cq := ctx.CallbackQuery
 	_, err := bot.bot.AnswerCallbackQuery(cq.Id, &gotgbot.AnswerCallbackQueryOpts{
@@ -393,8 +903,5 @@ func (bot *Bot) showReport(ctx *ext.Context) (string, *gotgbot.SendMessageOpts,
 		return fmt.Sprintf("Error: %v", err), nil, nil
 	}
 
-	return fmt.Sprintf("```\n%s\n```", report), &gotgbot.SendMessageOpts{
-		ParseMode:           "MarkdownV2",
-		DisableNotification: true,
-	}, nil
+	return codeBlock(report)
 }
@@ -0,0 +1,118 @@
+package bot
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/mput/teledger/app/ledger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLedgerUser(t *testing.T) {
+	assert.Equal(t, ledger.User{ID: 42, Name: "john_doe", ChatID: -100}, ledgerUser(&gotgbot.User{Id: 42, Username: "john_doe", FirstName: "John"}, -100))
+	assert.Equal(t, ledger.User{ID: 42, Name: "John", ChatID: 42}, ledgerUser(&gotgbot.User{Id: 42, FirstName: "John"}, 42))
+	assert.Equal(t, ledger.User{}, ledgerUser(nil, 0))
+}
+
+func TestProposalKey(t *testing.T) {
+	kb := proposalKeyboard("0123456789abcdef")
+	msg := &gotgbot.Message{
+		Text:           "no, it was 12.50",
+		ReplyToMessage: &gotgbot.Message{MessageId: 10, ReplyMarkup: &kb},
+	}
+	key, ok := proposalKey(msg)
+	assert.True(t, ok)
+	assert.Equal(t, "0123456789abcdef", key)
+	assert.True(t, isAmendReply(msg))
+
+	// committed transactions are edited, not amended
+	kb = committedKeyboard("0123456789abcdef")
+	msg.ReplyToMessage.ReplyMarkup = &kb
+	assert.False(t, isAmendReply(msg))
+
+	msg.ReplyToMessage.ReplyMarkup = nil
+	assert.False(t, isAmendReply(msg))
+	assert.False(t, isAmendReply(&gotgbot.Message{Text: "20 Taco Bell"}))
+
+	assert.Empty(t, proposalKeyboard("").InlineKeyboard)
+}
+
+func TestCommandArgs(t *testing.T) {
+	assert.Equal(t, "", commandArgs("/balance"))
+	assert.Equal(t, "", commandArgs("/balance  "))
+	query, err := ledger.ParseQuery(commandArgs("/register@teledger_bot food\n-p \"this month\""))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"food", "-p", "this month"}, query)
+}
+
+func TestValidateRepoOpts(t *testing.T) {
+	newOpts := func(url, dir string) *Opts {
+		opts := &Opts{}
+		opts.Git.URL = url
+		opts.Local.Dir = dir
+		opts.PR.Mode = "off"
+		opts.Pending.Store = "memory"
+		return opts
+	}
+
+	assert.NoError(t, validateRepoOpts(newOpts("https://github.com/mput/ledger.git", "")))
+	assert.NoError(t, validateRepoOpts(newOpts("", "/ledger")))
+	assert.ErrorContains(t, validateRepoOpts(newOpts("", "")), "either --git.url or --local.dir is required")
+	assert.ErrorContains(t, validateRepoOpts(newOpts("https://github.com/mput/ledger.git", "/ledger")), "can't be used together")
+
+	opts := newOpts("", "/ledger")
+	opts.PR.Mode = "day"
+	assert.ErrorContains(t, validateRepoOpts(opts), "pull request mode requires --git.url")
+
+	opts = newOpts("", "/ledger")
+	opts.Pending.Store = "git"
+	assert.ErrorContains(t, validateRepoOpts(opts), "git pending store requires --git.url")
+}
+
+func TestNewSigner(t *testing.T) {
+	dir := t.TempDir()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "teledger", []byte("secret"))
+	require.NoError(t, err)
+	key := filepath.Join(dir, "signing_key")
+	require.NoError(t, os.WriteFile(key, pem.EncodeToMemory(block), 0o600))
+	passphraseFile := filepath.Join(dir, "passphrase")
+	require.NoError(t, os.WriteFile(passphraseFile, []byte("secret\n"), 0o600))
+
+	opts := &Opts{}
+	opts.Sign.Format = "off"
+	signer, err := newSigner(opts)
+	assert.NoError(t, err)
+	assert.Nil(t, signer)
+
+	opts.Sign.Format = "ssh"
+	_, err = newSigner(opts)
+	assert.ErrorContains(t, err, "--sign.key is required")
+
+	opts.Sign.Key = key
+	_, err = newSigner(opts)
+	assert.ErrorContains(t, err, "passphrase is required")
+
+	opts.Sign.Passphrase = "secret"
+	signer, err = newSigner(opts)
+	assert.NoError(t, err)
+	assert.NotNil(t, signer)
+
+	opts.Sign.PassphraseFile = passphraseFile
+	_, err = newSigner(opts)
+	assert.ErrorContains(t, err, "can't be used together")
+
+	// the trailing newline of the file is ignored
+	opts.Sign.Passphrase = ""
+	signer, err = newSigner(opts)
+	assert.NoError(t, err)
+	assert.NotNil(t, signer)
+}
@@ -0,0 +1,209 @@
+package bot
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/mput/teledger/app/teledger"
+)
+
+//go:embed templates/edited_transaction.html
+var editedTemplateS string
+var editedTemplate = template.Must(template.New("edited").Parse(editedTemplateS))
+
+const (
+	editPrefix = "ed:"
+	editPrompt = "✏️ Reply to this message with the correction, in natural language or in the ledger format"
+)
+
+func isEditCallback(cb *gotgbot.CallbackQuery) bool {
+	return strings.HasPrefix(cb.Data, editPrefix)
+}
+
+// committedKeyboard is shown under a committed transaction
+func committedKeyboard(key string) gotgbot.InlineKeyboardMarkup {
+	return gotgbot.InlineKeyboardMarkup{
+		InlineKeyboard: [][]gotgbot.InlineKeyboardButton{
+			{
+				{
+					Text:         "✏️ Edit",
+					CallbackData: fmt.Sprint(editPrefix, key),
+				},
+				{
+					Text:         "🛑 Delete",
+					CallbackData: fmt.Sprint(deletePrefix, key),
+				},
+			},
+		},
+	}
+}
+
+type editKey struct {
+	chatID    int64
+	messageID int64
+}
+
+// editSession is a request for the correction of a committed transaction
+type editSession struct {
+	// id of the transaction in the ledger
+	id string
+	// message with the committed transaction
+	messageID int64
+}
+
+// editSessions maps the messages asking for a correction to the transactions,
+// the sessions are lost on restart
+type editSessions struct {
+	mu       sync.Mutex
+	sessions map[editKey]editSession
+}
+
+func newEditSessions() *editSessions {
+	return &editSessions{sessions: make(map[editKey]editSession)}
+}
+
+func (es *editSessions) add(k editKey, s editSession) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.sessions[k] = s
+}
+
+func (es *editSessions) get(k editKey) (editSession, bool) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	s, ok := es.sessions[k]
+	return s, ok
+}
+
+func (es *editSessions) remove(k editKey) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	delete(es.sessions, k)
+}
+
+// replyKey returns the key of the message the msg replies to
+func replyKey(msg *gotgbot.Message) (editKey, bool) {
+	if msg == nil || msg.ReplyToMessage == nil {
+		return editKey{}, false
+	}
+	return editKey{chatID: msg.Chat.Id, messageID: msg.ReplyToMessage.MessageId}, true
+}
+
+// isEditReply reports whether the message is a reply with a correction,
+// replies to the prompts sent before restart are caught as well
+func (bot *Bot) isEditReply(msg *gotgbot.Message) bool {
+	k, ok := replyKey(msg)
+	if !ok {
+		return false
+	}
+	if _, ok = bot.edits.get(k); ok {
+		return true
+	}
+	return msg.ReplyToMessage.Text == editPrompt
+}
+
+// editTransaction asks the user for a correction of the committed transaction
+func (bot *Bot) editTransaction(_ *gotgbot.Bot, ctx *ext.Context) error {
+	cq := ctx.CallbackQuery
+	key := strings.TrimPrefix(cq.Data, editPrefix)
+	chatID := cq.Message.GetChat().Id
+
+	sent, err := bot.bot.SendMessage(
+		chatID,
+		editPrompt,
+		&gotgbot.SendMessageOpts{
+			ReplyParameters: &gotgbot.ReplyParameters{MessageId: cq.Message.GetMessageId()},
+			ReplyMarkup: gotgbot.ForceReply{
+				ForceReply:            true,
+				InputFieldPlaceholder: "no, it was 12.50 and paid by card",
+			},
+		},
+	)
+	if err != nil {
+		_, _ = bot.bot.AnswerCallbackQuery(cq.Id, &gotgbot.AnswerCallbackQueryOpts{
+			ShowAlert: true,
+			Text:      fmt.Sprintf("🛑️ Error!\n%s", err),
+		})
+		return nil
+	}
+
+	bot.edits.add(
+		editKey{chatID: chatID, messageID: sent.MessageId},
+		editSession{id: key, messageID: cq.Message.GetMessageId()},
+	)
+
+	_, err = bot.bot.AnswerCallbackQuery(cq.Id, &gotgbot.AnswerCallbackQueryOpts{
+		Text: "✏️",
+	})
+	if err != nil {
+		slog.Error("unable to answer callback query", "error", err)
+	}
+	return nil
+}
+
+// applyEdit replaces the transaction with the correction from the reply
+// and updates the message with the transaction
+func (bot *Bot) applyEdit(ctx *ext.Context) (string, *gotgbot.SendMessageOpts, error) {
+	msg := ctx.EffectiveMessage
+	k, _ := replyKey(msg)
+	session, ok := bot.edits.get(k)
+	if !ok {
+		return "⌛ The edit has expired, press ✏️ Edit again", nil, nil
+	}
+
+	newTr, err := bot.teledger.EditTransaction(session.id, msg.Text, ledgerUser(msg.From, msg.Chat.Id))
+	if errors.Is(err, teledger.ErrQueued) {
+		bot.edits.remove(k)
+		return queuedNote, &gotgbot.SendMessageOpts{
+			ReplyParameters: &gotgbot.ReplyParameters{MessageId: msg.MessageId},
+		}, nil
+	}
+	if err != nil {
+		// the session is kept, so the user is able to reply again
+		return fmt.Sprintf("🛑 Error:\n%v", err), &gotgbot.SendMessageOpts{
+			ReplyParameters: &gotgbot.ReplyParameters{MessageId: msg.MessageId},
+		}, nil
+	}
+	bot.edits.remove(k)
+
+	var buf bytes.Buffer
+	err = editedTemplate.Execute(&buf, newTr)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to execute template: %v", err)
+	}
+	buf.WriteString(bot.pullRequestNote(ledgerUser(msg.From, msg.Chat.Id)))
+
+	_, _, err = bot.bot.EditMessageText(
+		buf.String(),
+		&gotgbot.EditMessageTextOpts{
+			ChatId:      msg.Chat.Id,
+			MessageId:   session.messageID,
+			ParseMode:   "HTML",
+			ReplyMarkup: committedKeyboard(session.id),
+		},
+	)
+	if err != nil {
+		slog.Error("unable to edit message", "error", err)
+		// the original message is gone, send the result as a new one
+		return buf.String(), &gotgbot.SendMessageOpts{
+			ParseMode:           "HTML",
+			DisableNotification: true,
+			ReplyMarkup:         committedKeyboard(session.id),
+		}, nil
+	}
+
+	// the prompt and the correction are not needed anymore
+	_, err = bot.bot.DeleteMessages(msg.Chat.Id, []int64{k.messageID, msg.MessageId}, nil)
+	if err != nil {
+		slog.Warn("unable to delete edit messages", "error", err)
+	}
+	return "", nil, nil
+}
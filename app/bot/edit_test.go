@@ -0,0 +1,37 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBot_IsEditReply(t *testing.T) {
+	bot := &Bot{edits: newEditSessions()}
+	bot.edits.add(editKey{chatID: 1, messageID: 10}, editSession{id: "0123456789abcdef", messageID: 9})
+
+	reply := func(chatID, messageID int64, text string) *gotgbot.Message {
+		return &gotgbot.Message{
+			Chat:           gotgbot.Chat{Id: chatID},
+			Text:           "it was 12.50",
+			ReplyToMessage: &gotgbot.Message{MessageId: messageID, Text: text},
+		}
+	}
+
+	assert.True(t, bot.isEditReply(reply(1, 10, editPrompt)))
+	assert.False(t, bot.isEditReply(reply(2, 10, "")))
+	assert.False(t, bot.isEditReply(&gotgbot.Message{Chat: gotgbot.Chat{Id: 1}, Text: "20 Taco Bell"}))
+	// prompts sent before restart
+	assert.True(t, bot.isEditReply(reply(1, 11, editPrompt)))
+
+	bot.edits.remove(editKey{chatID: 1, messageID: 10})
+	_, ok := bot.edits.get(editKey{chatID: 1, messageID: 10})
+	assert.False(t, ok)
+}
+
+func TestCommittedKeyboard(t *testing.T) {
+	kb := committedKeyboard("0123456789abcdef")
+	assert.Equal(t, "ed:0123456789abcdef", kb.InlineKeyboard[0][0].CallbackData)
+	assert.Equal(t, "rm:0123456789abcdef", kb.InlineKeyboard[0][1].CallbackData)
+}
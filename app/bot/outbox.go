@@ -0,0 +1,97 @@
+package bot
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/dustin/go-humanize"
+	"github.com/mput/teledger/app/teledger"
+)
+
+// how often the outbox is checked for the changes to retry
+const outboxInterval = 10 * time.Second
+
+const queuedNote = "📮 The change is queued and will be committed once the repository is available, see /queue"
+
+// notifyQueued tells the chat the queued change has landed or has failed
+func (bot *Bot) notifyQueued(e teledger.OutboxEntry, err error) {
+	if e.User.ChatID == 0 {
+		return
+	}
+	committed := committedTransaction(e, err)
+	var text string
+	if err != nil {
+		text = fmt.Sprintf("🛑 The queued change has failed and is dropped: %s\n<code>%s</code>",
+			html.EscapeString(e.Summary()), html.EscapeString(err.Error()))
+	} else {
+		text = fmt.Sprintf("✅ The queued change has been committed: %s", html.EscapeString(e.Summary())) +
+			bot.pullRequestNote(e.User)
+	}
+	opts := &gotgbot.SendMessageOpts{ParseMode: "HTML"}
+	if e.MessageID != 0 {
+		opts.ReplyParameters = &gotgbot.ReplyParameters{
+			MessageId:                e.MessageID,
+			AllowSendingWithoutReply: true,
+		}
+	}
+	_, err = bot.bot.SendMessage(e.User.ChatID, text, opts)
+	if err != nil {
+		slog.Error("unable to notify about queued change", "id", e.ID, "chat", e.User.ChatID, "error", err)
+	}
+
+	if committed {
+		// the confirmed transaction is in the journal now, so it can be edited or deleted
+		_, _, err = bot.bot.EditMessageReplyMarkup(&gotgbot.EditMessageReplyMarkupOpts{
+			ChatId:      e.User.ChatID,
+			MessageId:   e.MessageID,
+			ReplyMarkup: committedKeyboard(e.TransactionID),
+		})
+		if err != nil {
+			slog.Error("unable to add buttons to committed transaction", "id", e.ID, "error", err)
+		}
+	}
+}
+
+// committedTransaction reports whether the queued change has added
+// the confirmed transaction, the message about it gets the buttons then
+func committedTransaction(e teledger.OutboxEntry, err error) bool {
+	return err == nil && e.Kind == teledger.OutboxAdd && e.TransactionID != "" && e.MessageID != 0
+}
+
+// formatQueue lists the queued changes, the oldest first
+func formatQueue(entries []teledger.OutboxEntry, now time.Time) string {
+	if len(entries) == 0 {
+		return "📭 The queue is empty, all the changes are committed"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "📮 %d change(s) waiting for the repository:\n", len(entries))
+	for _, e := range entries {
+		fmt.Fprintf(&b, "\n• %s\n  by %s, %s, attempt %d, next %s",
+			html.EscapeString(e.Summary()),
+			html.EscapeString(e.User.Name),
+			humanize.RelTime(e.CreatedAt, now, "ago", "from now"),
+			e.Attempts,
+			humanize.RelTime(e.NextAttempt, now, "ago", "from now"),
+		)
+		if e.LastError != "" {
+			fmt.Fprintf(&b, "\n  <code>%s</code>", html.EscapeString(e.LastError))
+		}
+	}
+	return b.String()
+}
+
+// showQueue shows the changes waiting for the repository
+func (bot *Bot) showQueue(_ *ext.Context) (string, *gotgbot.SendMessageOpts, error) {
+	if bot.teledger.Outbox == nil {
+		return "The outbox is off, failed changes are not queued", nil, nil
+	}
+	return formatQueue(bot.teledger.Outbox.Entries(), time.Now()), &gotgbot.SendMessageOpts{
+		ParseMode:           "HTML",
+		DisableNotification: true,
+	}, nil
+}
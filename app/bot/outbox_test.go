@@ -0,0 +1,51 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mput/teledger/app/ledger"
+	"github.com/mput/teledger/app/teledger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatQueue(t *testing.T) {
+	now := time.Date(2024, 2, 14, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, "📭 The queue is empty, all the changes are committed", formatQueue(nil, now))
+
+	entries := []teledger.OutboxEntry{
+		{
+			Kind:        teledger.OutboxAdd,
+			Text:        "2024-02-14 * Tacos & Co\n    Food  10 EUR\n    Assets:Cash",
+			User:        ledger.User{Name: "john"},
+			CreatedAt:   now.Add(-5 * time.Minute),
+			Attempts:    3,
+			NextAttempt: now.Add(2 * time.Minute),
+			LastError:   "unable to fetch: the remote is unavailable: <timeout>",
+		},
+		{
+			Kind:        teledger.OutboxComment,
+			User:        ledger.User{Name: "jane"},
+			CreatedAt:   now.Add(-time.Minute),
+			Attempts:    1,
+			NextAttempt: now.Add(-time.Second),
+		},
+	}
+	assert.Equal(t, `📮 2 change(s) waiting for the repository:
+
+• Add transaction: 2024-02-14 * Tacos &amp; Co
+  by john, 5 minutes ago, attempt 3, next 2 minutes from now
+  <code>unable to fetch: the remote is unavailable: &lt;timeout&gt;</code>
+• Add comment
+  by jane, 1 minute ago, attempt 1, next 1 second ago`, formatQueue(entries, now))
+}
+
+func TestCommittedTransaction(t *testing.T) {
+	e := teledger.OutboxEntry{Kind: teledger.OutboxAdd, TransactionID: "0123456789abcdef", MessageID: 10}
+	assert.True(t, committedTransaction(e, nil))
+	assert.False(t, committedTransaction(e, assert.AnError), "the failed transaction isn't in the journal")
+
+	raw := teledger.OutboxEntry{Kind: teledger.OutboxAdd, Text: "2024-02-14 * Tacos"}
+	assert.False(t, committedTransaction(raw, nil), "raw transactions have no buttons")
+	assert.False(t, committedTransaction(teledger.OutboxEntry{Kind: teledger.OutboxDelete, TransactionID: "0123456789abcdef", MessageID: 10}, nil))
+}
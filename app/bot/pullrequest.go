@@ -0,0 +1,65 @@
+package bot
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/mput/teledger/app/ledger"
+	"github.com/mput/teledger/app/repo"
+)
+
+var pullRequestStates = map[repo.PullRequestState]string{
+	repo.PullRequestOpen:   "⏳ waiting for review",
+	repo.PullRequestMerged: "✅ merged",
+	repo.PullRequestClosed: "🛑 closed",
+}
+
+// formatPullRequest returns the link to the pull request with its state
+func formatPullRequest(pr *repo.PullRequest) string {
+	state, ok := pullRequestStates[pr.State]
+	if !ok {
+		state = string(pr.State)
+	}
+	return fmt.Sprintf(`📬 <a href="%s">Pull request #%d</a>: %s`, html.EscapeString(pr.URL), pr.Number, state)
+}
+
+// pullRequestNote returns the line with the pull request of the user's changes,
+// it's added to the messages about committed changes.
+// Empty if the pull request mode is off.
+func (bot *Bot) pullRequestNote(u ledger.User) string {
+	if bot.prs == nil {
+		return ""
+	}
+	pr, err := bot.prs.PullRequest(u.ID)
+	if err != nil {
+		slog.Error("unable to find pull request", "user", u.ID, "error", err)
+		return "\n⚠️ Unable to find the pull request with the changes"
+	}
+	if pr == nil {
+		return "\n⚠️ The pull request with the changes is not open yet"
+	}
+	return "\n" + formatPullRequest(pr)
+}
+
+// pullRequest shows the pull request with the user's changes and its state
+func (bot *Bot) pullRequest(ctx *ext.Context) (string, *gotgbot.SendMessageOpts, error) {
+	if bot.prs == nil {
+		return "Pull request mode is off, changes are committed directly", nil, nil
+	}
+	u := ledgerUser(ctx.EffectiveMessage.From, ctx.EffectiveChat.Id)
+	pr, err := bot.prs.PullRequest(u.ID)
+	if err != nil {
+		return fmt.Sprintf("🛑 Error:\n%v", err), nil, nil
+	}
+	if pr == nil {
+		return fmt.Sprintf("No pull request for <code>%s</code> yet", html.EscapeString(bot.prs.BranchFor(u.ID))), &gotgbot.SendMessageOpts{
+			ParseMode: "HTML",
+		}, nil
+	}
+	return formatPullRequest(pr), &gotgbot.SendMessageOpts{
+		ParseMode: "HTML",
+	}, nil
+}
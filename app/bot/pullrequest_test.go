@@ -0,0 +1,25 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/mput/teledger/app/ledger"
+	"github.com/mput/teledger/app/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPullRequestNote(t *testing.T) {
+	assert.Empty(t, (&Bot{}).pullRequestNote(ledger.User{ID: 42, Name: "john"}))
+
+	forge := &repo.FakeForge{}
+	bot := &Bot{prs: repo.NewPullRequestRepo("", repo.Auth{}, forge, repo.PullRequestPerUser)}
+	assert.Equal(t, "\n⚠️ The pull request with the changes is not open yet", bot.pullRequestNote(ledger.User{ID: 42, Name: "john"}))
+
+	_, err := forge.OpenPullRequest("teledger/42", "main", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "\n📬 <a href=\"https://forge.example.com/pulls/1\">Pull request #1</a>: ⏳ waiting for review", bot.pullRequestNote(ledger.User{ID: 42, Name: "john"}))
+
+	require.NoError(t, forge.SetState(1, repo.PullRequestMerged))
+	assert.Equal(t, "\n📬 <a href=\"https://forge.example.com/pulls/1\">Pull request #1</a>: ✅ merged", bot.pullRequestNote(ledger.User{ID: 42, Name: "john"}))
+}
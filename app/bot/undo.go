@@ -0,0 +1,108 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"strings"
+
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/mput/teledger/app/repo"
+	"github.com/mput/teledger/app/teledger"
+)
+
+const (
+	undoPrefix = "un:"
+	// how many recent changes are offered to undo
+	undoChoices = 5
+)
+
+func isUndoCallback(cb *gotgbot.CallbackQuery) bool {
+	return strings.HasPrefix(cb.Data, undoPrefix)
+}
+
+// undoButtonText returns the text of the button reverting the change
+func undoButtonText(c repo.Change) string {
+	return fmt.Sprintf("↩️ %s — %s, %s", c.Subject(), c.Author, c.When.Format("Jan 2 15:04"))
+}
+
+// undoKeyboard lists the changes to choose the one to undo
+func undoKeyboard(changes []repo.Change) gotgbot.InlineKeyboardMarkup {
+	var keyboard [][]gotgbot.InlineKeyboardButton
+	for _, c := range changes {
+		keyboard = append(keyboard, []gotgbot.InlineKeyboardButton{
+			{
+				Text:         undoButtonText(c),
+				CallbackData: fmt.Sprint(undoPrefix, c.Hash),
+			},
+		})
+	}
+	return gotgbot.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+}
+
+// undo lists the recent changes made with teledger, the chosen one is reverted
+func (bot *Bot) undo(_ *ext.Context) (string, *gotgbot.SendMessageOpts, error) {
+	changes, err := bot.teledger.RecentChanges(undoChoices)
+	if err != nil {
+		return fmt.Sprintf("🛑 Error:\n%v", err), nil, nil
+	}
+	if len(changes) == 0 {
+		return "No recent changes made with teledger", nil, nil
+	}
+	return "Choose the change to undo:", &gotgbot.SendMessageOpts{
+		DisableNotification: true,
+		ReplyMarkup:         undoKeyboard(changes),
+	}, nil
+}
+
+// undoChange reverts the chosen change with a new commit
+func (bot *Bot) undoChange(_ *gotgbot.Bot, ctx *ext.Context) error {
+	cq := ctx.CallbackQuery
+	hash := strings.TrimPrefix(cq.Data, undoPrefix)
+	user := ledgerUser(&cq.From, cq.Message.GetChat().Id)
+
+	reverted, err := bot.teledger.Undo(hash, user)
+	if errors.Is(err, teledger.ErrQueued) {
+		_, _, err = bot.bot.EditMessageText(queuedNote, &gotgbot.EditMessageTextOpts{
+			ChatId:      cq.Message.GetChat().Id,
+			MessageId:   cq.Message.GetMessageId(),
+			ReplyMarkup: gotgbot.InlineKeyboardMarkup{},
+		})
+		if err != nil {
+			slog.Error("unable to edit message", "error", err)
+		}
+		_, _ = bot.bot.AnswerCallbackQuery(cq.Id, &gotgbot.AnswerCallbackQueryOpts{Text: "📮 queued"})
+		return nil
+	}
+	if err != nil {
+		_, _ = bot.bot.AnswerCallbackQuery(cq.Id, &gotgbot.AnswerCallbackQueryOpts{
+			ShowAlert: true,
+			Text:      fmt.Sprintf("🛑️ Error!\n%s", err),
+		})
+		return nil
+	}
+
+	text := fmt.Sprintf("↩️ Reverted: %s", html.EscapeString(reverted.Subject())) + bot.pullRequestNote(user)
+	_, _, err = bot.bot.EditMessageText(
+		text,
+		&gotgbot.EditMessageTextOpts{
+			ChatId:      cq.Message.GetChat().Id,
+			MessageId:   cq.Message.GetMessageId(),
+			ParseMode:   "HTML",
+			ReplyMarkup: gotgbot.InlineKeyboardMarkup{},
+		},
+	)
+	if err != nil {
+		slog.Error("unable to edit message", "error", err)
+	}
+
+	_, err = bot.bot.AnswerCallbackQuery(cq.Id, &gotgbot.AnswerCallbackQueryOpts{
+		Text: "↩️ reverted",
+	})
+	if err != nil {
+		slog.Error("unable to answer callback query", "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mput/teledger/app/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndoKeyboard(t *testing.T) {
+	changes := []repo.Change{
+		{
+			Hash:    "0123456789abcdef0123456789abcdef01234567",
+			Message: "Add transaction: Tacos, 10.00 EUR",
+			Author:  "John Doe",
+			When:    time.Date(2024, 2, 14, 10, 5, 0, 0, time.UTC),
+		},
+		{
+			Hash:    "89abcdef0123456789abcdef0123456789abcdef",
+			Message: "Revert \"Add comment\"\n\nThis reverts commit 0123.",
+			Author:  "jane",
+			When:    time.Date(2024, 3, 1, 18, 30, 0, 0, time.UTC),
+		},
+	}
+	kb := undoKeyboard(changes)
+	require.Len(t, kb.InlineKeyboard, 2)
+	assert.Equal(t, "↩️ Add transaction: Tacos, 10.00 EUR — John Doe, Feb 14 10:05", kb.InlineKeyboard[0][0].Text)
+	assert.Equal(t, "↩️ Revert \"Add comment\" — jane, Mar 1 18:30", kb.InlineKeyboard[1][0].Text)
+	for i, row := range kb.InlineKeyboard {
+		data := row[0].CallbackData
+		assert.Equal(t, undoPrefix+changes[i].Hash, data)
+		// the limit of the telegram callback data
+		assert.LessOrEqual(t, len(data), 64)
+	}
+}
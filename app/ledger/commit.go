@@ -0,0 +1,136 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/mput/teledger/app/repo"
+)
+
+// default author of the commits made on behalf of the users
+// missing in the authors of the config
+const (
+	defaultAuthorName  = "teledger"
+	defaultAuthorEmail = "teledger@example.com"
+)
+
+// User is the telegram user who makes the change
+type User struct {
+	ID int64
+	// username, or the first name if the username is not set
+	Name string
+	// chat the change is made from, it's notified about the queued changes
+	ChatID int64
+}
+
+// Author is the git author of the commits made by a telegram user
+type Author struct {
+	Name  string `yaml:"name"`
+	Email string `yaml:"email"`
+}
+
+// author returns the commit author of the user according to the config
+func (cfg *Config) author(u User) Author {
+	a, ok := cfg.Authors[u.ID]
+	if !ok {
+		return Author{Name: defaultAuthorName, Email: defaultAuthorEmail}
+	}
+	if a.Name == "" {
+		a.Name = u.Name
+	}
+	if a.Email == "" {
+		a.Email = defaultAuthorEmail
+	}
+	return a
+}
+
+// postingAmount matches the amount of a posting: `    Assets:Cash  -10.00 EUR`
+var postingAmount = regexp.MustCompile(`^\s+[^;\s].*?(?:\t|\s{2,})-?(.+?)\s*(?:;.*)?$`)
+
+// headerPrefix matches the date, the state and the code before the description
+var headerPrefix = regexp.MustCompile(`^\d{4}[-/]\d{2}[-/]\d{2}(?:=\S+)?\s*[*!]?\s*(?:\([^)]*\))?\s*`)
+
+// addMessage returns the commit message for the new transaction:
+// the description and the amount of the first posting
+func addMessage(transaction string) string {
+	h := header(transaction)
+	desc := strings.TrimSpace(headerPrefix.ReplaceAllString(h, ""))
+	if desc == "" {
+		desc = h
+	}
+	var amount string
+	for _, line := range strings.Split(transaction, "\n") {
+		if m := postingAmount.FindStringSubmatch(line); m != nil {
+			amount = m[1]
+			break
+		}
+	}
+	if amount == "" {
+		return fmt.Sprintf("Add transaction: %s", desc)
+	}
+	return fmt.Sprintf("Add transaction: %s, %s", desc, amount)
+}
+
+// maxPushAttempts limits how many times a change is applied
+// on top of the remote head when the push is rejected
+const maxPushAttempts = 3
+
+// change modifies the files of the freshly initialized repo, validates them
+// and returns the commit message marked with repo.TransactionMessage.
+// It must be safe to call it again on top of the new remote head.
+type change func() (msg string, err error)
+
+// commitChange applies the change to the repo and pushes it.
+// If the push is rejected because someone else has pushed meanwhile,
+// the repo is updated and the change is replayed on top of the new head,
+// so it's validated again against the current ledger file.
+// The commit is authored by the user.
+func (l *Ledger) commitChange(user User, ch change) error {
+	var err error
+	for attempt := 1; attempt <= maxPushAttempts; attempt++ {
+		if attempt > 1 {
+			slog.Warn("push rejected, replaying the change on top of the remote", "attempt", attempt, "error", err)
+		}
+		err = l.commitChangeOnce(user, ch)
+		if !errors.Is(err, repo.ErrPushRejected) {
+			if err != nil && attempt > 1 {
+				return fmt.Errorf("the ledger has been changed remotely and the change conflicts with it: %w", err)
+			}
+			return err
+		}
+	}
+	return fmt.Errorf("unable to push after %d attempts: %w", maxPushAttempts, err)
+}
+
+func (l *Ledger) commitChangeOnce(user User, ch change) error {
+	var err error
+	if us, ok := l.repo.(repo.UserService); ok {
+		// e.g. the pull request of the user
+		err = us.InitFor(user.ID)
+	} else {
+		err = l.repo.Init()
+	}
+	defer l.repo.Free()
+	if err != nil {
+		return fmt.Errorf("unable to init repo: %w", err)
+	}
+	err = l.setConfig()
+	if err != nil {
+		return fmt.Errorf("unable to set config: %v", err)
+	}
+
+	msg, err := ch()
+	if err != nil {
+		return err
+	}
+
+	author := l.Config.author(user)
+	err = l.repo.CommitPush(msg, author.Name, author.Email)
+	if err != nil {
+		return fmt.Errorf("unable to commit: %w", err)
+	}
+	return nil
+}
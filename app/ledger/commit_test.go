@@ -0,0 +1,162 @@
+package ledger
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mput/teledger/app/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLedger_CommitChange(t *testing.T) {
+	const testFile = `
+account Food
+account Assets:Cash
+commodity EUR
+
+2014-12-01 * Tacos
+    ; tid: 0123456789abcdef
+    Assets:Cash  -10.00 EUR
+    Food  10.00 EUR
+`
+	const remoteChange = "\n2014-12-02 * Remote\n    Assets:Cash  -1.00 EUR\n    Food  1.00 EUR\n"
+
+	// rejectOnce simulates a push to the remote between Init and CommitPush
+	rejectOnce := func(r *repo.Mock, remote func(string) string) {
+		rejected := false
+		r.Push = func(_ map[string]string) error {
+			if rejected {
+				return nil
+			}
+			rejected = true
+			r.Files["main.ledger"] = remote(r.Files["main.ledger"])
+			return repo.ErrPushRejected
+		}
+	}
+
+	t.Run("change is replayed on top of the remote", func(t *testing.T) {
+		r := &repo.Mock{Files: map[string]string{"main.ledger": testFile}}
+		rejectOnce(r, func(s string) string { return s + remoteChange })
+		l := NewLedger(r, nil)
+
+		err := l.AddTransaction("2014-12-03 * Burrito\n    Assets:Cash  -5.00 EUR\n    Food", User{})
+		require.NoError(t, err)
+		assert.Equal(t, testFile+remoteChange+"\n2014-12-03 * Burrito\n    Assets:Cash  -5.00 EUR\n    Food", r.Files["main.ledger"])
+	})
+
+	t.Run("attempts are bounded", func(t *testing.T) {
+		r := &repo.Mock{Files: map[string]string{"main.ledger": testFile}}
+		pushes := 0
+		r.Push = func(_ map[string]string) error {
+			pushes++
+			return repo.ErrPushRejected
+		}
+		l := NewLedger(r, nil)
+
+		_, err := l.AddComment("hello", User{})
+		assert.ErrorIs(t, err, repo.ErrPushRejected)
+		assert.ErrorContains(t, err, fmt.Sprintf("unable to push after %d attempts", maxPushAttempts))
+		assert.Equal(t, maxPushAttempts, pushes)
+		assert.Equal(t, testFile, r.Files["main.ledger"])
+	})
+
+	t.Run("conflict with the remote is reported", func(t *testing.T) {
+		r := &repo.Mock{Files: map[string]string{"main.ledger": testFile}}
+		// the transaction is deleted remotely
+		rejectOnce(r, func(string) string { return "\naccount Food\n" })
+		l := NewLedger(r, nil)
+
+		err := l.DeleteTransactionWithID("0123456789abcdef", User{})
+		assert.ErrorContains(t, err, "the ledger has been changed remotely and the change conflicts with it: no transaction with id '0123456789abcdef' was found")
+		assert.Equal(t, "\naccount Food\n", r.Files["main.ledger"])
+	})
+
+	t.Run("edit is replayed without generation", func(t *testing.T) {
+		r := &repo.Mock{Files: map[string]string{"main.ledger": testFile}}
+		rejectOnce(r, func(s string) string { return remoteChange + s })
+		gen := &TransactionGeneratorMock{
+			GenerateTransactionFunc: func(_ PromptCtx) (Transaction, error) {
+				return Transaction{
+					Date:        "2014-12-01",
+					Description: "Tacos",
+					Postings: []Posting{
+						{Account: "Assets:Cash", Amount: NewDecimal(-125, 1), Currency: "EUR"},
+						{Account: "Food", Amount: NewDecimal(125, 1), Currency: "EUR"},
+					},
+				}, nil
+			},
+		}
+		l := NewLedger(r, gen)
+
+		newTr, err := l.EditTransactionWithID("0123456789abcdef", "it was 12.50", User{}, 1)
+		require.NoError(t, err)
+		assert.Len(t, gen.calls.GenerateTransaction, 1)
+		assert.Contains(t, r.Files["main.ledger"], remoteChange)
+		assert.Contains(t, r.Files["main.ledger"], "    ; tid: 0123456789abcdef\n    Assets:Cash  -12.50 EUR\n")
+		assert.Contains(t, newTr, "Assets:Cash  -12.50 EUR")
+	})
+}
+
+func TestAddMessage(t *testing.T) {
+	for tr, msg := range map[string]string{
+		"2014-12-01 * Tacos\n    Assets:Cash  -10.00 EUR\n    Food":                                "Add transaction: Tacos, 10.00 EUR",
+		";; 10 tacos\n2014/12/01 ! (42) Tacos\n    ; tid: 0123\n    Food  10 EUR\n    Assets:Cash": "Add transaction: Tacos, 10 EUR",
+		"2014-12-01=2014-12-03 Tacos\n    Food\t$10 ; paid\n    Assets:Cash":                       "Add transaction: Tacos, $10",
+		"2014-12-01 * Tacos\n    Food\n    Assets:Cash":                                            "Add transaction: Tacos",
+		"2014-12-01": "Add transaction: 2014-12-01",
+	} {
+		assert.Equal(t, msg, addMessage(tr), tr)
+	}
+}
+
+func TestLedger_CommitAuthor(t *testing.T) {
+	r := &repo.Mock{Files: map[string]string{
+		"main.ledger": "\naccount Food\naccount Assets:Cash\ncommodity EUR\n",
+		"teledger.yaml": `
+authors:
+  42:
+    name: John Doe
+    email: john@example.com
+  43:
+    email: jane@example.com
+`,
+	}}
+	l := NewLedger(r, nil)
+
+	require.NoError(t, l.AddTransactionWithID("2014-12-01 * Tacos\n    Assets:Cash  -10.00 EUR\n    Food", "0123456789abcdef", User{ID: 42, Name: "john"}))
+	_, err := l.AddComment("hello", User{ID: 43, Name: "jane"})
+	require.NoError(t, err)
+	require.NoError(t, l.DeleteTransactionWithID("0123456789abcdef", User{ID: 44, Name: "bob"}))
+
+	assert.Equal(t, []repo.Commit{
+		{Msg: "Add transaction: Tacos, 10.00 EUR\n\nTeledger-Transaction: 0123456789abcdef", Name: "John Doe", Email: "john@example.com"},
+		{Msg: "Add comment\n\nTeledger-Transaction: none", Name: "jane", Email: "jane@example.com"},
+		{Msg: "Delete transaction 0123456789abcdef\n\nTeledger-Transaction: 0123456789abcdef", Name: defaultAuthorName, Email: defaultAuthorEmail},
+	}, r.Commits)
+}
+
+// userRepo records the users the changes are made for
+type userRepo struct {
+	*repo.Mock
+	users []int64
+}
+
+func (r *userRepo) InitFor(userID int64) error {
+	err := r.Init()
+	r.users = append(r.users, userID)
+	return err
+}
+
+func TestLedger_CommitForUser(t *testing.T) {
+	r := &userRepo{Mock: &repo.Mock{Files: map[string]string{"main.ledger": "\naccount Food\naccount Assets:Cash\n"}}}
+	l := NewLedger(r, nil)
+
+	_, err := l.AddComment("hello", User{ID: 42, Name: "john"})
+	require.NoError(t, err)
+	_, err = l.Execute("bal")
+	require.NoError(t, err)
+
+	assert.Equal(t, []int64{42}, r.users)
+	assert.Len(t, r.Commits, 1)
+}
@@ -0,0 +1,227 @@
+package ledger
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mput/teledger/app/ledger/journal"
+)
+
+// Decimal is an exact decimal number, unlike float64 it's never rounded.
+// The value is coef * 10^-scale, the scale is the number of the digits
+// after the point as they're written, so `20.50` keeps both digits.
+// It's a number in JSON.
+type Decimal struct {
+	// coef is never changed after it's created, nil is zero
+	coef  *big.Int
+	scale int
+}
+
+// maxDecimalScale limits the exponents of the parsed numbers
+const maxDecimalScale = 100
+
+var decimalRe = regexp.MustCompile(`^([+-]?)(\d*)(?:\.(\d*))?(?:[eE]([+-]?\d+))?$`)
+
+// NewDecimal returns coef * 10^-scale, e.g. NewDecimal(-2050, 2) is -20.50
+func NewDecimal(coef int64, scale int) Decimal {
+	if scale < 0 {
+		return Decimal{coef: new(big.Int).Mul(big.NewInt(coef), pow10(-scale))}
+	}
+	return Decimal{coef: big.NewInt(coef), scale: scale}
+}
+
+// ParseDecimal parses a number like `-1234.50` or `1.5e3`
+func ParseDecimal(s string) (Decimal, error) {
+	m := decimalRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil || m[2]+m[3] == "" {
+		return Decimal{}, fmt.Errorf("invalid decimal '%s'", s)
+	}
+	scale := len(m[3])
+	if m[4] != "" {
+		exp, err := strconv.Atoi(m[4])
+		if err != nil || exp > maxDecimalScale || exp < -maxDecimalScale {
+			return Decimal{}, fmt.Errorf("invalid decimal exponent in '%s'", s)
+		}
+		scale -= exp
+	}
+	coef, ok := new(big.Int).SetString(m[2]+m[3], 10)
+	if !ok {
+		return Decimal{}, fmt.Errorf("invalid decimal '%s'", s)
+	}
+	if m[1] == "-" {
+		coef.Neg(coef)
+	}
+	if scale < 0 {
+		coef.Mul(coef, pow10(-scale))
+		scale = 0
+	}
+	if scale > maxDecimalScale {
+		return Decimal{}, fmt.Errorf("too many decimal places in '%s'", s)
+	}
+	return Decimal{coef: coef, scale: scale}, nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+func (d Decimal) int() *big.Int {
+	if d.coef == nil {
+		return new(big.Int)
+	}
+	return d.coef
+}
+
+// Scale returns the number of the digits after the point
+func (d Decimal) Scale() int {
+	return d.scale
+}
+
+func (d Decimal) Sign() int {
+	return d.int().Sign()
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{coef: new(big.Int).Neg(d.int()), scale: d.scale}
+}
+
+// WithScale returns the same number with at least the scale digits after the point,
+// the digits are only added, the number is never rounded
+func (d Decimal) WithScale(scale int) Decimal {
+	if scale <= d.scale {
+		return d
+	}
+	return Decimal{coef: new(big.Int).Mul(d.int(), pow10(scale-d.scale)), scale: scale}
+}
+
+// Cmp compares the numbers regardless of their scale
+func (d Decimal) Cmp(o Decimal) int {
+	scale := max(d.scale, o.scale)
+	return d.WithScale(scale).int().Cmp(o.WithScale(scale).int())
+}
+
+// digits returns the sign, the integer and the fractional digits
+func (d Decimal) digits() (sign, integer, fraction string) {
+	s := new(big.Int).Abs(d.int()).String()
+	if d.scale > 0 {
+		if len(s) <= d.scale {
+			s = strings.Repeat("0", d.scale-len(s)+1) + s
+		}
+		integer, fraction = s[:len(s)-d.scale], s[len(s)-d.scale:]
+	} else {
+		integer = s
+	}
+	if d.Sign() < 0 {
+		sign = "-"
+	}
+	return sign, integer, fraction
+}
+
+// String returns the number with all its digits: `-1234.50`
+func (d Decimal) String() string {
+	sign, integer, fraction := d.digits()
+	if fraction == "" {
+		return sign + integer
+	}
+	return sign + integer + "." + fraction
+}
+
+// Format returns the number with the thousands separated: `-1,234.50`
+func (d Decimal) Format() string {
+	sign, integer, fraction := d.digits()
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, c := range integer {
+		if i > 0 && (len(integer)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	if fraction != "" {
+		b.WriteString("." + fraction)
+	}
+	return b.String()
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON reads the number without rounding it,
+// the numbers in strings are accepted too
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if string(data) == "null" {
+		return nil
+	}
+	s := string(data)
+	if len(data) > 1 && data[0] == '"' && data[len(data)-1] == '"' {
+		var err error
+		s, err = strconv.Unquote(s)
+		if err != nil {
+			return fmt.Errorf("invalid decimal %s: %v", data, err)
+		}
+	}
+	v, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// quantityPrecision returns the number of the digits after the point of the quantity
+func quantityPrecision(quantity string) int {
+	i := strings.LastIndexByte(quantity, '.')
+	if i < 0 {
+		return 0
+	}
+	return len(quantity) - i - 1
+}
+
+// commodityPrecisions returns the number of the digits after the point
+// of the commodities. The `format` of the commodity directive is used if it's
+// set, otherwise the precision is the largest one used in the journal.
+func commodityPrecisions(j *journal.Journal) map[string]int {
+	res := make(map[string]int)
+	observe := func(a *journal.Amount) {
+		if a == nil || a.Expr != "" {
+			return
+		}
+		if p := quantityPrecision(a.Quantity); p > res[a.Commodity] {
+			res[a.Commodity] = p
+		} else if _, ok := res[a.Commodity]; !ok {
+			res[a.Commodity] = p
+		}
+	}
+	for _, t := range j.Transactions() {
+		for _, p := range t.Postings {
+			observe(p.Amount)
+			observe(p.Assertion)
+			if p.Cost != nil {
+				observe(&p.Cost.Amount)
+			}
+			if p.Lot != nil {
+				observe(p.Lot.Price)
+			}
+		}
+	}
+	delete(res, "")
+
+	for _, d := range j.Directives("commodity") {
+		format, ok := d.SubArg("format")
+		if !ok {
+			continue
+		}
+		a, err := journal.ParseAmount(format)
+		if err != nil || a.Expr != "" {
+			continue
+		}
+		res[d.Arg] = quantityPrecision(a.Quantity)
+	}
+	return res
+}
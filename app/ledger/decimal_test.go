@@ -0,0 +1,126 @@
+package ledger
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mput/teledger/app/ledger/journal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDecimal(t *testing.T) {
+	tbl := []struct {
+		in, str, formatted string
+		err                string
+	}{
+		{in: "0", str: "0", formatted: "0"},
+		{in: "-20", str: "-20", formatted: "-20"},
+		{in: "12.50", str: "12.50", formatted: "12.50"},
+		{in: "+0.00000001", str: "0.00000001", formatted: "0.00000001"},
+		{in: "-.5", str: "-0.5", formatted: "-0.5"},
+		{in: "1234567.891", str: "1234567.891", formatted: "1,234,567.891"},
+		{in: "-123456", str: "-123456", formatted: "-123,456"},
+		{in: "1.5e3", str: "1500", formatted: "1,500"},
+		{in: "15E-4", str: "0.0015", formatted: "0.0015"},
+		{in: "123456789012345678901234.123456789012345678", str: "123456789012345678901234.123456789012345678",
+			formatted: "123,456,789,012,345,678,901,234.123456789012345678"},
+		{in: "", err: "invalid decimal"},
+		{in: "1,000", err: "invalid decimal"},
+		{in: "1e1000", err: "invalid decimal exponent"},
+		{in: "NaN", err: "invalid decimal"},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.in, func(t *testing.T) {
+			d, err := ParseDecimal(tt.in)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.str, d.String())
+			assert.Equal(t, tt.formatted, d.Format())
+		})
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	assert.Equal(t, "0", Decimal{}.String())
+	assert.Equal(t, "-20.50", NewDecimal(-2050, 2).String())
+	assert.Equal(t, "1200", NewDecimal(12, -2).String())
+	assert.Equal(t, "20.50", NewDecimal(-2050, 2).Neg().String())
+	assert.Equal(t, 0, Decimal{}.Sign())
+
+	d := NewDecimal(125, 1)
+	assert.Equal(t, "12.500", d.WithScale(3).String())
+	assert.Equal(t, "12.5", d.WithScale(0).String(), "never rounded")
+	assert.Equal(t, "12.5", d.String(), "not changed")
+	assert.Equal(t, 0, d.Cmp(NewDecimal(12500, 3)))
+	assert.Equal(t, -1, d.Neg().Cmp(d))
+	assert.Equal(t, 1, d.Cmp(Decimal{}))
+}
+
+func TestDecimal_JSON(t *testing.T) {
+	var p Posting
+	require.NoError(t, json.Unmarshal([]byte(`{"account": "Assets:Crypto", "amount": 0.12345678, "currency": "BTC"}`), &p))
+	assert.Equal(t, "0.12345678", p.Amount.String())
+
+	require.NoError(t, json.Unmarshal([]byte(`{"amount": "-1500"}`), &p))
+	assert.Equal(t, "-1500", p.Amount.String())
+
+	assert.Error(t, json.Unmarshal([]byte(`{"amount": "ten"}`), &p))
+	assert.Error(t, json.Unmarshal([]byte(`{"amount": true}`), &p))
+
+	res, err := json.Marshal(Posting{Account: "Assets:Crypto", Amount: NewDecimal(-12345678, 8), Currency: "BTC"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"account": "Assets:Crypto", "amount": -0.12345678, "currency": "BTC"}`, string(res))
+}
+
+func TestCommodityPrecisions(t *testing.T) {
+	j, err := journal.Parse(strings.NewReader(`
+commodity EUR
+    format 1,000.00 EUR
+commodity JPY
+    format ¥1,000
+commodity BTC
+
+2024-02-13 * Exchange
+    Assets:Crypto  0.00120000 BTC @ 40,000.5 EUR
+    Assets:Cash  -48.00 EUR
+    Assets:Yen  100.5 JPY
+    Assets:Shares  10 AAPL {$150.125}
+    Assets:Cash  = $0.5
+    Equity  (1 EUR * 2)
+`), "main.ledger")
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]int{
+		"EUR":  2,
+		"JPY":  0,
+		"BTC":  8,
+		"AAPL": 0,
+		"$":    3,
+	}, commodityPrecisions(j))
+}
+
+func TestTransaction_WithPrecisions(t *testing.T) {
+	tr := Transaction{
+		RealDateTime: time.Date(2024, 2, 13, 0, 0, 0, 0, time.UTC),
+		Description:  "Exchange",
+		Postings: []Posting{
+			{Account: "Assets:Crypto", Amount: NewDecimal(12, 4), Currency: "BTC"},
+			{Account: "Assets:Cash", Amount: NewDecimal(-48, 0), Currency: "EUR"},
+			{Account: "Assets:Yen", Amount: NewDecimal(-15005, 1), Currency: "JPY"},
+			{Account: "Assets:Shares", Amount: NewDecimal(10, 0), Currency: "AAPL"},
+		},
+	}
+	tr.withPrecisions(map[string]int{"BTC": 8, "EUR": 2, "JPY": 0})
+	assert.Equal(t, `2024-02-13 * Exchange
+    Assets:Crypto  0.00120000 BTC
+    Assets:Cash  -48.00 EUR
+    Assets:Yen  -1,500.5 JPY
+    Assets:Shares  10 AAPL
+`, tr.String())
+}
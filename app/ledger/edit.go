@@ -0,0 +1,308 @@
+package ledger
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/mput/teledger/app/repo"
+)
+
+// transactionHeader matches the first line of a transaction
+var transactionHeader = regexp.MustCompile(`^\d{4}[-/]\d{2}[-/]\d{2}`)
+
+// IsRawTransaction reports whether the text is written in the ledger format
+// rather than in natural language
+func IsRawTransaction(text string) bool {
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		return transactionHeader.MatchString(line)
+	}
+	return false
+}
+
+// transactionBlock is a transaction found in the ledger file by id
+type transactionBlock struct {
+	// lines of the file, the transaction is lines[start:end+1]
+	lines      []string
+	start, end int
+	// comment lines above the header, without the legacy id marker
+	comment []string
+	// header and postings, without the id metadata
+	body []string
+}
+
+// userInput returns the text the transaction was generated from
+func (b *transactionBlock) userInput() string {
+	if len(b.comment) == 0 {
+		return strings.Join(b.body, "\n")
+	}
+	var res []string
+	for _, line := range b.comment {
+		res = append(res, strings.TrimSpace(strings.TrimLeft(line, ";")))
+	}
+	return strings.Join(res, "\n")
+}
+
+// replace returns the content of the file with the transaction replaced
+func (b *transactionBlock) replace(transaction string) string {
+	var res []string
+	res = append(res, b.lines[:b.start]...)
+	res = append(res, strings.Split(strings.TrimRight(transaction, "\n"), "\n")...)
+	res = append(res, b.lines[b.end+1:]...)
+	return strings.Join(res, "\n") + "\n"
+}
+
+func (b *transactionBlock) original() string {
+	return strings.Join(b.lines, "\n") + "\n"
+}
+
+// header returns the first line of the transaction
+func header(transaction string) string {
+	for _, line := range strings.Split(transaction, "\n") {
+		if transactionHeader.MatchString(line) {
+			return strings.TrimSpace(line)
+		}
+	}
+	return ""
+}
+
+func (l *Ledger) findTransactionBlock(id string) (*transactionBlock, error) {
+	f, err := l.repo.Open(l.Config.MainFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open main ledger file: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read main ledger file: %v", err)
+	}
+
+	start, end, found := findTransactionWithID(lines, id)
+	if !found {
+		return nil, fmt.Errorf("no transaction with id '%s' was found", id)
+	}
+
+	b := &transactionBlock{lines: lines, start: start, end: end}
+	inComment := true
+	for _, line := range lines[start : end+1] {
+		if line == legacyTransactionIDPrefix+id || transactionIDLine.MatchString(line) {
+			continue
+		}
+		if inComment && strings.HasPrefix(line, ";") {
+			b.comment = append(b.comment, line)
+			continue
+		}
+		inComment = false
+		b.body = append(b.body, line)
+	}
+	return b, nil
+}
+
+// writeMainFile replaces the content of the main ledger file
+func (l *Ledger) writeMainFile(content string) error {
+	f, err := l.repo.OpenFile(l.Config.MainFile, os.O_TRUNC|os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open main ledger file: %v", err)
+	}
+	_, err = f.Write([]byte(content))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write main ledger file: %v", err)
+	}
+	return nil
+}
+
+// replaceTransaction writes the transaction in place of the block
+// and validates the whole file
+func (l *Ledger) replaceTransaction(b *transactionBlock, id, transaction string) error {
+	tr, err := withTransactionID(transaction, id)
+	if err != nil {
+		return err
+	}
+	err = l.writeMainFile(b.replace(tr))
+	if err != nil {
+		return err
+	}
+	err = l.validate()
+	if err != nil {
+		return fmt.Errorf("invalid transaction: %v", err)
+	}
+	return nil
+}
+
+// validateReplacement validates the file with the transaction put
+// in place of the block, the file isn't written, so it works with the snapshot
+func (l *Ledger) validateReplacement(b *transactionBlock, id, transaction string) error {
+	tr, err := withTransactionID(transaction, id)
+	if err != nil {
+		return err
+	}
+	replaced := &Ledger{
+		repo:   &mainFileOverlay{Service: l.repo, file: l.Config.MainFile, content: b.replace(tr)},
+		Config: l.Config,
+	}
+	err = replaced.validate()
+	if err != nil {
+		return fmt.Errorf("invalid transaction: %v", err)
+	}
+	return nil
+}
+
+// mainFileOverlay is the repo with the content of the main file replaced,
+// the other files are read from the repo
+type mainFileOverlay struct {
+	repo.Service
+	file    string
+	content string
+}
+
+func (o *mainFileOverlay) Open(file string) (billy.File, error) {
+	if file != o.file {
+		return o.Service.Open(file)
+	}
+	fs := memfs.New()
+	err := util.WriteFile(fs, file, []byte(o.content), 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Open(file)
+}
+
+// EditTransactionWithID replaces the transaction with the id in place.
+// The correction is either the new transaction in the ledger format,
+// or a description of the changes in natural language, in that case
+// the generator is asked to correct the transaction.
+// Returns the new transaction.
+func (l *Ledger) EditTransactionWithID(id, correction string, user User, attempts int) (string, error) {
+	var newTr string
+	if !IsRawTransaction(correction) {
+		// the transaction is amended on the snapshot, so the repo
+		// isn't locked while the generator is working
+		ro, err := l.readOnly()
+		if err != nil {
+			return "", err
+		}
+		b, err := ro.findTransactionBlock(id)
+		if err != nil {
+			return "", err
+		}
+		newTr, err = ro.amendTransaction(b, id, correction, user.Name, attempts)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err := l.commitChange(user, func() (string, error) {
+		b, err := l.findTransactionBlock(id)
+		if err != nil {
+			return "", err
+		}
+
+		if newTr != "" {
+			// the amended transaction, or the one the push has been rejected with,
+			// is put in place of the one from the current head
+			err = l.replaceTransaction(b, id, newTr)
+		} else {
+			tr := strings.TrimSpace(correction)
+			if len(b.comment) > 0 && !strings.HasPrefix(tr, ";") {
+				tr = strings.Join(b.comment, "\n") + "\n" + tr
+			}
+			err = l.replaceTransaction(b, id, tr)
+			if err == nil {
+				newTr = tr
+			}
+		}
+		if err != nil {
+			// don't leave the rejected transaction in the file
+			if werr := l.writeMainFile(b.original()); werr != nil {
+				slog.Error("unable to restore main ledger file", "error", werr)
+			}
+			return "", err
+		}
+		return repo.TransactionMessage(fmt.Sprintf("Edit transaction %s: %s", id, header(newTr)), id), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return newTr, nil
+}
+
+// amendTransaction asks the generator to correct the transaction, the result
+// is validated in place of the block without writing the file
+func (l *Ledger) amendTransaction(b *transactionBlock, id, correction, userName string, attempts int) (string, error) {
+	if attempts <= 0 {
+		panic("times should be greater than 0")
+	}
+
+	tmpl, err := l.promptTemplate()
+	if err != nil {
+		return "", err
+	}
+	promptCtx, err := l.newPromptCtx(b.userInput(), userName, tmpl)
+	if err != nil {
+		return "", err
+	}
+	promptCtx.Amendment = &Amendment{
+		Transaction: strings.Join(b.body, "\n"),
+		Correction:  correction,
+	}
+
+	// the original date is kept unless the correction changes it
+	var origDate time.Time
+	if len(b.body) > 0 {
+		d := strings.ReplaceAll(transactionHeader.FindString(b.body[0]), "/", "-")
+		origDate, _ = time.Parse("2006-01-02", d)
+	}
+
+	var errs []string
+	for i := 1; i <= attempts; i++ {
+		if i > 1 {
+			slog.Warn("retrying transaction amendment", "attempt", i, "error", errs[len(errs)-1])
+		}
+		tr, err := l.generator.GenerateTransaction(promptCtx)
+		if err == nil {
+			tr.withPrecisions(promptCtx.Precisions)
+			err = validateTransaction(&tr, promptCtx.Accounts, promptCtx.Commodities)
+			if err != nil {
+				err = fmt.Errorf("transaction doesn't match the schema: %v", err)
+			}
+		}
+		if err == nil {
+			err = tr.withAmendedDate(origDate)
+		}
+		if err == nil {
+			tr.Comment = b.userInput() + "\n" + correction
+			newTr := tr.Format(true)
+			err = l.validateReplacement(b, id, newTr)
+			if err == nil {
+				return newTr, nil
+			}
+		}
+
+		errs = append(errs, err.Error())
+		attempt := Attempt{Number: i, Error: err}
+		if tr.Postings != nil {
+			attempt.Transaction = &tr
+		}
+		promptCtx.History = append(promptCtx.History, attempt)
+	}
+	return "", fmt.Errorf("unable to amend transaction: %s", strings.Join(errs, "; "))
+}
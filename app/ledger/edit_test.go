@@ -0,0 +1,290 @@
+package ledger
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mput/teledger/app/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRawTransaction(t *testing.T) {
+	assert.True(t, IsRawTransaction("2024-02-14 * Taxi\n    Assets:Cash  -10 EUR\n    Expenses:Taxi"))
+	assert.True(t, IsRawTransaction(";; comment\n2024/02/14 Taxi\n    Assets:Cash  -10 EUR\n    Expenses:Taxi"))
+	assert.False(t, IsRawTransaction("no, it was 12.50 and paid by card"))
+	assert.False(t, IsRawTransaction("12.50 EUR"))
+}
+
+func TestLedger_EditTransactionWithID(t *testing.T) {
+	const testFile = `
+account Food
+account Assets:Cash
+account Assets:Card
+account Equity
+commodity EUR
+
+;; tid:2014-11-30 11:45:26.111 Sun
+;; legacy
+2014-11-30 * Legacy
+    Assets:Cash  -10.00 EUR
+    Food  10.00 EUR
+
+;; 10 tacos
+2014-12-01 * Tacos
+    ; tid: 0123456789abcdef
+    Assets:Cash  -10.00 EUR
+    Food  10.00 EUR
+`
+
+	newLedger := func(gen TransactionGenerator) (*Ledger, *repo.Mock) {
+		r := &repo.Mock{Files: map[string]string{
+			"main.ledger":   testFile,
+			"teledger.yaml": "strict: true\n",
+		}}
+		return NewLedger(r, gen), r
+	}
+
+	t.Run("raw ledger syntax", func(t *testing.T) {
+		l, r := newLedger(nil)
+
+		res, err := l.EditTransactionWithID("0123456789abcdef", `
+2014-12-01 * Tacos
+    Assets:Card  -12.50 EUR
+    Food  12.50 EUR
+`, User{}, 1)
+		require.NoError(t, err)
+		assert.Equal(t, ";; 10 tacos\n2014-12-01 * Tacos\n    Assets:Card  -12.50 EUR\n    Food  12.50 EUR", res)
+
+		assert.Equal(t, `
+account Food
+account Assets:Cash
+account Assets:Card
+account Equity
+commodity EUR
+
+;; tid:2014-11-30 11:45:26.111 Sun
+;; legacy
+2014-11-30 * Legacy
+    Assets:Cash  -10.00 EUR
+    Food  10.00 EUR
+
+;; 10 tacos
+2014-12-01 * Tacos
+    ; tid: 0123456789abcdef
+    Assets:Card  -12.50 EUR
+    Food  12.50 EUR
+`, r.Files["main.ledger"])
+	})
+
+	t.Run("natural language", func(t *testing.T) {
+		gen := &TransactionGeneratorMock{
+			GenerateTransactionFunc: func(_ PromptCtx) (Transaction, error) {
+				return Transaction{
+					Description: "Legacy",
+					Postings: []Posting{
+						{Account: "Assets:Card", Amount: NewDecimal(-125, 1), Currency: "EUR"},
+						{Account: "Food", Amount: NewDecimal(125, 1), Currency: "EUR"},
+					},
+				}, nil
+			},
+		}
+		l, r := newLedger(gen)
+
+		res, err := l.EditTransactionWithID("2014-11-30 11:45:26.111 Sun", "no, it was 12.50 and paid by card", User{Name: "john"}, 1)
+		require.NoError(t, err)
+		assert.Equal(t, ";; legacy\n;; no, it was 12.50 and paid by card\n2014-11-30 * Legacy\n    Assets:Card  -12.50 EUR\n    Food  12.50 EUR\n", res)
+
+		require.Len(t, gen.calls.GenerateTransaction, 1)
+		promptCtx := gen.calls.GenerateTransaction[0].PromptCtx
+		assert.Equal(t, "legacy", promptCtx.UserInput)
+		assert.Equal(t, "john", promptCtx.UserName)
+		assert.Equal(t, &Amendment{
+			Transaction: "2014-11-30 * Legacy\n    Assets:Cash  -10.00 EUR\n    Food  10.00 EUR",
+			Correction:  "no, it was 12.50 and paid by card",
+		}, promptCtx.Amendment)
+
+		// the legacy marker is replaced with the metadata
+		assert.Contains(t, r.Files["main.ledger"], `
+;; legacy
+;; no, it was 12.50 and paid by card
+2014-11-30 * Legacy
+    ; tid: 2014-11-30 11:45:26.111 Sun
+    Assets:Card  -12.50 EUR
+    Food  12.50 EUR
+
+;; 10 tacos
+`)
+		assert.NotContains(t, r.Files["main.ledger"], ";; tid:")
+
+		// and the transaction is still found by the legacy id
+		_, err = l.EditTransactionWithID("2014-11-30 11:45:26.111 Sun", "2014-11-30 * Legacy\n    Assets:Cash  -1.00 EUR\n    Food", User{}, 1)
+		assert.NoError(t, err)
+	})
+
+	t.Run("date is corrected", func(t *testing.T) {
+		gen := &TransactionGeneratorMock{
+			GenerateTransactionFunc: func(_ PromptCtx) (Transaction, error) {
+				return Transaction{
+					Date:        "2014-11-30",
+					Description: "Tacos",
+					Postings: []Posting{
+						{Account: "Assets:Cash", Amount: NewDecimal(-10, 0), Currency: "EUR"},
+						{Account: "Food", Amount: NewDecimal(10, 0), Currency: "EUR"},
+					},
+				}, nil
+			},
+		}
+		l, r := newLedger(gen)
+
+		res, err := l.EditTransactionWithID("0123456789abcdef", "move it to yesterday", User{}, 1)
+		require.NoError(t, err)
+		assert.Contains(t, res, "\n2014-11-30 * Tacos\n")
+		assert.Contains(t, r.Files["main.ledger"], "2014-11-30 * Tacos\n    ; tid: 0123456789abcdef\n")
+		assert.NotContains(t, r.Files["main.ledger"], "2014-12-01")
+	})
+
+	t.Run("repo is not locked while generating", func(t *testing.T) {
+		var r *repo.Mock
+		gen := &TransactionGeneratorMock{
+			GenerateTransactionFunc: func(_ PromptCtx) (Transaction, error) {
+				inited := make(chan struct{})
+				go func() {
+					if r.Init() == nil {
+						r.Free()
+					}
+					close(inited)
+				}()
+				select {
+				case <-inited:
+				case <-time.After(time.Second):
+					t.Error("the repo is locked while the generator is working")
+				}
+				return Transaction{
+					Description: "Tacos",
+					Postings: []Posting{
+						{Account: "Assets:Card", Amount: NewDecimal(-125, 1), Currency: "EUR"},
+						{Account: "Food", Amount: NewDecimal(125, 1), Currency: "EUR"},
+					},
+				}, nil
+			},
+		}
+		var l *Ledger
+		l, r = newLedger(gen)
+
+		res, err := l.EditTransactionWithID("0123456789abcdef", "paid by card", User{}, 1)
+		require.NoError(t, err)
+		assert.Contains(t, res, "2014-12-01 * Tacos\n    Assets:Card  -12.50 EUR\n")
+		assert.Contains(t, r.Files["main.ledger"], "    ; tid: 0123456789abcdef\n    Assets:Card  -12.50 EUR\n")
+	})
+
+	t.Run("invalid correction", func(t *testing.T) {
+		gen := &TransactionGeneratorMock{
+			GenerateTransactionFunc: func(_ PromptCtx) (Transaction, error) {
+				return Transaction{}, fmt.Errorf("no idea")
+			},
+		}
+		l, r := newLedger(gen)
+
+		_, err := l.EditTransactionWithID("0123456789abcdef", "make it right", User{}, 2)
+		assert.ErrorContains(t, err, "unable to amend transaction: no idea; no idea")
+		assert.Len(t, gen.calls.GenerateTransaction, 2)
+		assert.Equal(t, testFile, r.Files["main.ledger"])
+	})
+
+	t.Run("unknown id", func(t *testing.T) {
+		l, _ := newLedger(nil)
+		_, err := l.EditTransactionWithID("unknown", "2014-12-01 * Tacos", User{}, 1)
+		assert.ErrorContains(t, err, "no transaction with id 'unknown' was found")
+	})
+}
+
+func TestLedger_AmendTransaction(t *testing.T) {
+	const testFile = `
+account Food
+account Assets:Cash
+account Assets:Card
+commodity EUR
+`
+	gen := &TransactionGeneratorMock{
+		GenerateTransactionFunc: func(promptCtx PromptCtx) (Transaction, error) {
+			return Transaction{
+				Description:  "Tacos",
+				Comment:      promptCtx.UserInput,
+				RealDateTime: promptCtx.Datetime,
+				Postings: []Posting{
+					{Account: "Assets:Card", Amount: NewDecimal(-125, 1), Currency: "EUR"},
+					{Account: "Food", Amount: NewDecimal(125, 1), Currency: "EUR"},
+				},
+			}, nil
+		},
+	}
+	l := NewLedger(&repo.Mock{Files: map[string]string{"main.ledger": testFile}}, gen)
+
+	dt, _ := time.Parse(time.RFC3339, "2014-11-30T11:45:26Z")
+	tr := Transaction{
+		Description:  "Tacos",
+		Comment:      "10 tacos",
+		RealDateTime: dt,
+		Postings: []Posting{
+			{Account: "Assets:Cash", Amount: NewDecimal(-10, 0), Currency: "EUR"},
+			{Account: "Food", Amount: NewDecimal(10, 0), Currency: "EUR"},
+		},
+	}
+
+	resp := l.AmendTransaction(tr, "no, it was 12.50 and paid by card", User{Name: "john"}, 1)
+	require.NoError(t, resp.Error)
+	assert.False(t, resp.Committed)
+	assert.Equal(t, ";; 10 tacos\n;; no, it was 12.50 and paid by card\n2014-11-30 * Tacos\n    Assets:Card  -12.5 EUR\n    Food  12.5 EUR\n", resp.GeneratedTransaction.Format(true))
+
+	require.Len(t, gen.calls.GenerateTransaction, 1)
+	promptCtx := gen.calls.GenerateTransaction[0].PromptCtx
+	assert.Equal(t, "10 tacos", promptCtx.UserInput)
+	assert.Equal(t, dt, promptCtx.Datetime)
+	require.NotNil(t, promptCtx.Amendment)
+	assert.Equal(t, "no, it was 12.50 and paid by card", promptCtx.Amendment.Correction)
+	assert.JSONEq(t,
+		`{"date":"2014-11-30","description":"Tacos","postings":[{"account":"Assets:Cash","amount":-10,"currency":"EUR"},{"account":"Food","amount":10,"currency":"EUR"}]}`,
+		promptCtx.Amendment.Transaction,
+	)
+
+	t.Run("date is corrected", func(t *testing.T) {
+		gen := &TransactionGeneratorMock{
+			GenerateTransactionFunc: func(promptCtx PromptCtx) (Transaction, error) {
+				return Transaction{
+					Date:         "2014-11-29",
+					Description:  "Tacos",
+					RealDateTime: promptCtx.Datetime,
+					Postings: []Posting{
+						{Account: "Assets:Cash", Amount: NewDecimal(-10, 0), Currency: "EUR"},
+						{Account: "Food", Amount: NewDecimal(10, 0), Currency: "EUR"},
+					},
+				}, nil
+			},
+		}
+		l := NewLedger(&repo.Mock{Files: map[string]string{"main.ledger": testFile}}, gen)
+
+		resp := l.AmendTransaction(tr, "it was yesterday", User{}, 1)
+		require.NoError(t, resp.Error)
+		assert.Equal(t, "2014-11-29 * Tacos\n    Assets:Cash  -10 EUR\n    Food  10 EUR\n", resp.GeneratedTransaction.Format(false))
+	})
+}
+
+func TestPromptCtx_ConversationWithAmendment(t *testing.T) {
+	promptCtx := testPromptCtx()
+	promptCtx.Amendment = &Amendment{
+		Transaction: "2024-02-14 * Taco Bell\n    Assets:Cash  -20 EUR\n    Expenses:Food  20 EUR",
+		Correction:  "it was 25",
+	}
+	promptCtx.History = []Attempt{{Number: 1, Error: fmt.Errorf("unable to unmarshal response")}}
+
+	msgs := promptCtx.conversation()
+	require.Len(t, msgs, 3)
+	assert.Equal(t, chatMessage{Role: chatRoleUser, Content: "20 Taco Bell"}, msgs[0])
+	assert.Equal(t, chatRoleAssistant, msgs[1].Role)
+	assert.Equal(t, promptCtx.Amendment.Transaction, msgs[1].Content)
+	assert.Equal(t, chatRoleUser, msgs[2].Role)
+	assert.Contains(t, msgs[2].Content, "it was 25")
+	assert.Contains(t, msgs[2].Content, "unable to unmarshal response")
+}
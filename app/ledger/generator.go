@@ -0,0 +1,195 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// TransactionGenerator is an interface for generating transactions from user input
+// using LLM.
+//
+//go:generate moq -out  transaction_generator_mock.go -with-resets . TransactionGenerator
+type TransactionGenerator interface {
+	GenerateTransaction(promptCtx PromptCtx) (Transaction, error)
+}
+
+// Supported LLM providers
+const (
+	ProviderOpenAI           = "openai"
+	ProviderOpenAICompatible = "openai-compatible"
+	ProviderAnthropic        = "anthropic"
+	ProviderRules            = "rules"
+)
+
+// LLMOpts are the service level settings of a transaction generator
+type LLMOpts struct {
+	Token       string
+	Model       string  // provider default if empty
+	BaseURL     string  // provider default if empty
+	Temperature float32 // provider default if 0
+}
+
+// LLMConfig is the llm section of teledger.yaml,
+// it overrides the service level settings for a particular ledger
+type LLMConfig struct {
+	Model       string  `yaml:"model"`
+	Temperature float32 `yaml:"temperature"`
+}
+
+// withOverrides returns opts updated with non empty values from the ledger config
+func (o LLMOpts) withOverrides(c LLMConfig) LLMOpts {
+	if c.Model != "" {
+		o.Model = c.Model
+	}
+	if c.Temperature != 0 {
+		o.Temperature = c.Temperature
+	}
+	return o
+}
+
+// NewTransactionGenerator creates a generator for the provider
+func NewTransactionGenerator(provider string, opts LLMOpts) (TransactionGenerator, error) {
+	switch provider {
+	case ProviderOpenAI:
+		if opts.Token == "" {
+			return nil, fmt.Errorf("token is required for %s provider", provider)
+		}
+		return NewOpenAITransactionGenerator(opts), nil
+	case ProviderOpenAICompatible:
+		if opts.BaseURL == "" {
+			return nil, fmt.Errorf("base url is required for %s provider", provider)
+		}
+		if opts.Model == "" {
+			return nil, fmt.Errorf("model is required for %s provider", provider)
+		}
+		return NewOpenAITransactionGenerator(opts), nil
+	case ProviderAnthropic:
+		if opts.Token == "" {
+			return nil, fmt.Errorf("token is required for %s provider", provider)
+		}
+		return NewAnthropicTransactionGenerator(opts), nil
+	case ProviderRules:
+		return NewRuleBasedTransactionGenerator(), nil
+	default:
+		return nil, fmt.Errorf("unknown llm provider: %s", provider)
+	}
+}
+
+const openaiDefaultModel = "gpt-5-mini"
+
+// OpenAITransactionGenerator works with OpenAI API and
+// any OpenAI compatible server (ollama, llama.cpp server, etc.)
+type OpenAITransactionGenerator struct {
+	openai *openai.Client
+	opts   LLMOpts
+}
+
+func NewOpenAITransactionGenerator(opts LLMOpts) *OpenAITransactionGenerator {
+	cfg := openai.DefaultConfig(opts.Token)
+	if opts.BaseURL != "" {
+		cfg.BaseURL = opts.BaseURL
+	}
+	if opts.Model == "" {
+		opts.Model = openaiDefaultModel
+	}
+	return &OpenAITransactionGenerator{
+		openai: openai.NewClientWithConfig(cfg),
+		opts:   opts,
+	}
+}
+
+//nolint:gocritic
+func (b OpenAITransactionGenerator) GenerateTransaction(promptCtx PromptCtx) (Transaction, error) {
+	prompt, err := promptCtx.RenderPrompt()
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	opts := b.opts.withOverrides(promptCtx.Config.LLM)
+
+	messages := []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: prompt,
+		},
+	}
+	for _, m := range promptCtx.conversation() {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    m.Role,
+			Content: m.Content,
+		})
+	}
+
+	resp, err := b.openai.CreateChatCompletion(
+		context.Background(),
+		openai.ChatCompletionRequest{
+			Model:       opts.Model,
+			Temperature: opts.Temperature,
+			Messages:    messages,
+			// the transaction is requested as arguments of the forced function call,
+			// so the response is generated according to the schema
+			Tools: []openai.Tool{
+				{
+					Type: openai.ToolTypeFunction,
+					Function: &openai.FunctionDefinition{
+						Name:        proposeTransactionTool,
+						Description: proposeTransactionToolDescription,
+						Parameters:  promptCtx.schema(),
+					},
+				},
+			},
+			ToolChoice: openai.ToolChoice{
+				Type:     openai.ToolTypeFunction,
+				Function: openai.ToolFunction{Name: proposeTransactionTool},
+			},
+		},
+	)
+	if err != nil {
+		return Transaction{}, fmt.Errorf("chatCompletion error: %v", err)
+	}
+
+	if len(resp.Choices) == 0 {
+		return Transaction{}, fmt.Errorf("chatCompletion error: empty response")
+	}
+
+	msg := resp.Choices[0].Message
+	content := msg.Content
+	// some OpenAI compatible servers ignore tools, so the content is used as a fallback
+	for _, tc := range msg.ToolCalls {
+		if tc.Function.Name == proposeTransactionTool {
+			content = tc.Function.Arguments
+			break
+		}
+	}
+
+	return parseGeneratedTransaction(content, &promptCtx)
+}
+
+// parseGeneratedTransaction unmarshals the transaction from the model response.
+// Local models tend to wrap JSON into markdown code block even if asked not to,
+// so the block is stripped.
+func parseGeneratedTransaction(content string, promptCtx *PromptCtx) (Transaction, error) {
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(content, "```json")
+		content = strings.TrimPrefix(content, "```")
+		content = strings.TrimSuffix(content, "```")
+	}
+
+	res := Transaction{}
+	dec := json.NewDecoder(strings.NewReader(content))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&res)
+	if err != nil {
+		return Transaction{}, fmt.Errorf("unable to unmarshal response: %v", err)
+	}
+
+	res.Comment = promptCtx.UserInput
+	res.RealDateTime = promptCtx.Datetime
+
+	return res, nil
+}
@@ -0,0 +1,169 @@
+package ledger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	anthropicDefaultBaseURL = "https://api.anthropic.com"
+	anthropicDefaultModel   = "claude-3-5-haiku-latest"
+	anthropicAPIVersion     = "2023-06-01"
+	anthropicMaxTokens      = 1024
+)
+
+// AnthropicTransactionGenerator works with Anthropic Messages API
+type AnthropicTransactionGenerator struct {
+	client *http.Client
+	opts   LLMOpts
+}
+
+func NewAnthropicTransactionGenerator(opts LLMOpts) *AnthropicTransactionGenerator {
+	if opts.BaseURL == "" {
+		opts.BaseURL = anthropicDefaultBaseURL
+	}
+	if opts.Model == "" {
+		opts.Model = anthropicDefaultModel
+	}
+	return &AnthropicTransactionGenerator{
+		client: &http.Client{Timeout: time.Minute},
+		opts:   opts,
+	}
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+type anthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type anthropicRequest struct {
+	Model       string               `json:"model"`
+	MaxTokens   int                  `json:"max_tokens"`
+	System      string               `json:"system,omitempty"`
+	Messages    []anthropicMessage   `json:"messages"`
+	Temperature float32              `json:"temperature,omitempty"`
+	Tools       []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice  *anthropicToolChoice `json:"tool_choice,omitempty"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type  string          `json:"type"`
+		Text  string          `json:"text"`
+		Name  string          `json:"name"`
+		Input json.RawMessage `json:"input"`
+	} `json:"content"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (b *AnthropicTransactionGenerator) createMessage(req *anthropicRequest) (*anthropicResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal request: %v", err)
+	}
+
+	url := strings.TrimSuffix(b.opts.BaseURL, "/") + "/v1/messages"
+	httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %v", err)
+	}
+	httpReq.Header.Set("content-type", "application/json")
+	httpReq.Header.Set("x-api-key", b.opts.Token)
+	httpReq.Header.Set("anthropic-version", anthropicAPIVersion)
+
+	httpResp, err := b.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("messages request error: %v", err)
+	}
+	defer httpResp.Body.Close()
+
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read response: %v", err)
+	}
+
+	resp := &anthropicResponse{}
+	err = json.Unmarshal(respBody, resp)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal response (status %d): %v", httpResp.StatusCode, err)
+	}
+
+	if resp.Error != nil {
+		return nil, fmt.Errorf("messages error (status %d): %s: %s", httpResp.StatusCode, resp.Error.Type, resp.Error.Message)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("messages error: unexpected status %d", httpResp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+//nolint:gocritic
+func (b *AnthropicTransactionGenerator) GenerateTransaction(promptCtx PromptCtx) (Transaction, error) {
+	prompt, err := promptCtx.RenderPrompt()
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	opts := b.opts.withOverrides(promptCtx.Config.LLM)
+
+	var messages []anthropicMessage
+	for _, m := range promptCtx.conversation() {
+		messages = append(messages, anthropicMessage{
+			Role:    m.Role,
+			Content: m.Content,
+		})
+	}
+
+	resp, err := b.createMessage(&anthropicRequest{
+		Model:       opts.Model,
+		MaxTokens:   anthropicMaxTokens,
+		System:      prompt,
+		Temperature: opts.Temperature,
+		Messages:    messages,
+		// the transaction is requested as an input of the forced tool call,
+		// so the response is generated according to the schema
+		Tools: []anthropicTool{
+			{
+				Name:        proposeTransactionTool,
+				Description: proposeTransactionToolDescription,
+				InputSchema: promptCtx.schema(),
+			},
+		},
+		ToolChoice: &anthropicToolChoice{Type: "tool", Name: proposeTransactionTool},
+	})
+	if err != nil {
+		return Transaction{}, err
+	}
+
+	var content strings.Builder
+	for _, c := range resp.Content {
+		if c.Type == "tool_use" && c.Name == proposeTransactionTool {
+			return parseGeneratedTransaction(string(c.Input), &promptCtx)
+		}
+		if c.Type == "text" {
+			content.WriteString(c.Text)
+		}
+	}
+
+	return parseGeneratedTransaction(content.String(), &promptCtx)
+}
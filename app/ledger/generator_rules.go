@@ -0,0 +1,151 @@
+package ledger
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RuleBasedTransactionGenerator proposes transactions without any LLM.
+// It expects an input like "20 taxi card": the first number is the amount,
+// words matching a commodity select the currency, words matching the last
+// segment of an account select the accounts, the rest is the description.
+// Defaults are the same as in the default prompt: the first account
+// is the assets account and the first commodity is the currency.
+type RuleBasedTransactionGenerator struct{}
+
+func NewRuleBasedTransactionGenerator() *RuleBasedTransactionGenerator {
+	return &RuleBasedTransactionGenerator{}
+}
+
+var currencySymbols = map[string]string{
+	"€": "EUR",
+	"$": "USD",
+	"£": "GBP",
+	"¥": "JPY",
+	"₽": "RUB",
+}
+
+// isSourceAccount reports whether money is usually taken from the account
+func isSourceAccount(account string) bool {
+	return strings.HasPrefix(account, "Assets") || strings.HasPrefix(account, "Liabilities")
+}
+
+func accountLeaf(account string) string {
+	parts := strings.Split(account, ":")
+	return strings.ToLower(parts[len(parts)-1])
+}
+
+func parseRuleAmount(word string) (amount Decimal, currency string, ok bool) {
+	for sym, cur := range currencySymbols {
+		if strings.HasPrefix(word, sym) || strings.HasSuffix(word, sym) {
+			word = strings.TrimSuffix(strings.TrimPrefix(word, sym), sym)
+			currency = cur
+			break
+		}
+	}
+	v, err := ParseDecimal(strings.ReplaceAll(word, ",", "."))
+	if err != nil {
+		return Decimal{}, "", false
+	}
+	return v, currency, true
+}
+
+//nolint:gocritic
+func (b *RuleBasedTransactionGenerator) GenerateTransaction(promptCtx PromptCtx) (Transaction, error) {
+	if len(promptCtx.Accounts) < 2 {
+		return Transaction{}, fmt.Errorf("at least two accounts are required")
+	}
+	if len(promptCtx.Commodities) == 0 {
+		return Transaction{}, fmt.Errorf("at least one commodity is required")
+	}
+
+	var amount Decimal
+	var amountFound bool
+	var currency, source, target string
+	var description []string
+
+	for _, word := range strings.Fields(promptCtx.UserInput) {
+		if !amountFound {
+			if v, cur, ok := parseRuleAmount(word); ok {
+				amount, amountFound = v, true
+				if cur != "" {
+					currency = cur
+				}
+				continue
+			}
+		}
+
+		matched := false
+		for _, c := range promptCtx.Commodities {
+			if strings.EqualFold(c, word) {
+				currency, matched = c, true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+
+		lword := strings.ToLower(word)
+		for _, a := range promptCtx.Accounts {
+			if accountLeaf(a) != lword {
+				continue
+			}
+			if isSourceAccount(a) && source == "" {
+				source, matched = a, true
+			} else if !isSourceAccount(a) && target == "" {
+				target = a
+			}
+			break
+		}
+		if !matched {
+			description = append(description, word)
+		}
+	}
+
+	if !amountFound {
+		return Transaction{}, fmt.Errorf("no amount found in '%s'", promptCtx.UserInput)
+	}
+
+	if currency == "" {
+		currency = promptCtx.Commodities[0]
+	}
+	if source == "" {
+		source = promptCtx.Accounts[0]
+	}
+	if target == "" {
+		for _, a := range promptCtx.Accounts {
+			if strings.HasPrefix(a, "Expenses") {
+				target = a
+				break
+			}
+		}
+	}
+	if target == "" {
+		for _, a := range promptCtx.Accounts {
+			if a != source && !isSourceAccount(a) {
+				target = a
+				break
+			}
+		}
+	}
+	if target == "" {
+		return Transaction{}, fmt.Errorf("unable to find an account for '%s'", promptCtx.UserInput)
+	}
+
+	desc := strings.Join(description, " ")
+	if desc == "" {
+		desc = "Expense"
+	}
+
+	return Transaction{
+		Date:        promptCtx.Datetime.Format("2006-01-02"),
+		Description: desc,
+		Postings: []Posting{
+			{Account: source, Amount: amount.Neg(), Currency: currency},
+			{Account: target, Amount: amount, Currency: currency},
+		},
+		Comment:      promptCtx.UserInput,
+		RealDateTime: promptCtx.Datetime,
+	}, nil
+}
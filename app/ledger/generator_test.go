@@ -0,0 +1,312 @@
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const generatedTransactionJSON = `{
+  "date": "2024-02-14",
+  "description": "Taco Bell",
+  "postings": [
+    {"account": "Assets:Cash", "amount": -20, "currency": "EUR"},
+    {"account": "Expenses:Food", "amount": 20, "currency": "EUR"}
+  ]
+}`
+
+func testPromptCtx() PromptCtx {
+	dt, _ := time.Parse(time.RFC3339, "2024-02-14T11:45:26Z")
+	return PromptCtx{
+		Accounts:    []string{"Assets:Cash", "Assets:Card", "Expenses:Food", "Expenses:Taxi"},
+		Commodities: []string{"EUR", "USD"},
+		UserInput:   "20 Taco Bell",
+		Datetime:    dt,
+	}
+}
+
+func assertGeneratedTransaction(t *testing.T, tr Transaction) {
+	t.Helper()
+	assert.Equal(t, "Taco Bell", tr.Description)
+	assert.Equal(t, "20 Taco Bell", tr.Comment)
+	assert.Equal(t, []Posting{
+		{Account: "Assets:Cash", Amount: NewDecimal(-20, 0), Currency: "EUR"},
+		{Account: "Expenses:Food", Amount: NewDecimal(20, 0), Currency: "EUR"},
+	}, tr.Postings)
+}
+
+func TestNewTransactionGenerator(t *testing.T) {
+	_, err := NewTransactionGenerator(ProviderOpenAI, LLMOpts{})
+	assert.ErrorContains(t, err, "token is required")
+
+	_, err = NewTransactionGenerator(ProviderOpenAICompatible, LLMOpts{Model: "llama3"})
+	assert.ErrorContains(t, err, "base url is required")
+
+	_, err = NewTransactionGenerator(ProviderAnthropic, LLMOpts{})
+	assert.ErrorContains(t, err, "token is required")
+
+	_, err = NewTransactionGenerator("unknown", LLMOpts{})
+	assert.ErrorContains(t, err, "unknown llm provider")
+
+	gen, err := NewTransactionGenerator(ProviderRules, LLMOpts{})
+	assert.NoError(t, err)
+	assert.IsType(t, &RuleBasedTransactionGenerator{}, gen)
+}
+
+func TestOpenAITransactionGenerator(t *testing.T) {
+	var req openai.ChatCompletionRequest
+	content := generatedTransactionJSON
+	toolCall := false
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/chat/completions", r.URL.Path)
+		assert.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		msg := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content}
+		if toolCall {
+			msg = openai.ChatCompletionMessage{
+				Role: openai.ChatMessageRoleAssistant,
+				ToolCalls: []openai.ToolCall{
+					{
+						ID:       "call_1",
+						Type:     openai.ToolTypeFunction,
+						Function: openai.FunctionCall{Name: proposeTransactionTool, Arguments: content},
+					},
+				},
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{Message: msg}},
+		})
+	}))
+	defer srv.Close()
+
+	gen, err := NewTransactionGenerator(ProviderOpenAICompatible, LLMOpts{
+		Token:       "test-token",
+		Model:       "llama3",
+		BaseURL:     srv.URL,
+		Temperature: 0.2,
+	})
+	require.NoError(t, err)
+
+	t.Run("service settings", func(t *testing.T) {
+		tr, err := gen.GenerateTransaction(testPromptCtx())
+		require.NoError(t, err)
+		assertGeneratedTransaction(t, tr)
+
+		assert.Equal(t, "llama3", req.Model)
+		assert.InDelta(t, 0.2, req.Temperature, 0.001)
+		require.Len(t, req.Messages, 2)
+		assert.Equal(t, openai.ChatMessageRoleSystem, req.Messages[0].Role)
+		assert.Contains(t, req.Messages[0].Content, "Assets:Cash")
+		assert.Equal(t, "20 Taco Bell", req.Messages[1].Content)
+	})
+
+	t.Run("function call with schema", func(t *testing.T) {
+		toolCall = true
+		defer func() { toolCall = false }()
+
+		promptCtx := testPromptCtx()
+
+		tr, err := gen.GenerateTransaction(promptCtx)
+		require.NoError(t, err)
+		assertGeneratedTransaction(t, tr)
+
+		require.Len(t, req.Tools, 1)
+		assert.Equal(t, proposeTransactionTool, req.Tools[0].Function.Name)
+		params, err := json.Marshal(req.Tools[0].Function.Parameters)
+		require.NoError(t, err)
+		assert.Contains(t, string(params), `"enum":["Assets:Cash","Assets:Card","Expenses:Food","Expenses:Taxi"]`)
+		assert.Contains(t, string(params), `"enum":["EUR","USD"]`)
+	})
+
+	t.Run("ledger config overrides", func(t *testing.T) {
+		promptCtx := testPromptCtx()
+		promptCtx.Config.LLM = LLMConfig{Model: "mistral", Temperature: 0.7}
+
+		_, err := gen.GenerateTransaction(promptCtx)
+		require.NoError(t, err)
+
+		assert.Equal(t, "mistral", req.Model)
+		assert.InDelta(t, 0.7, req.Temperature, 0.001)
+	})
+
+	t.Run("rejected attempts are sent back", func(t *testing.T) {
+		promptCtx := testPromptCtx()
+		rejected := Transaction{Description: "Taco Bell", Postings: []Posting{{Account: "cash", Amount: NewDecimal(-20, 0), Currency: "EUR"}}}
+		promptCtx.History = []Attempt{
+			{Number: 1, Transaction: &rejected, Error: fmt.Errorf("Unknown account 'cash'")},
+			{Number: 2, Error: fmt.Errorf("unable to unmarshal response")},
+		}
+
+		_, err := gen.GenerateTransaction(promptCtx)
+		require.NoError(t, err)
+
+		require.Len(t, req.Messages, 4)
+		assert.Equal(t, openai.ChatMessageRoleUser, req.Messages[1].Role)
+		assert.Equal(t, "20 Taco Bell", req.Messages[1].Content)
+		assert.Equal(t, openai.ChatMessageRoleAssistant, req.Messages[2].Role)
+		assert.JSONEq(t,
+			`{"date":"","description":"Taco Bell","postings":[{"account":"cash","amount":-20,"currency":"EUR"}]}`,
+			req.Messages[2].Content,
+		)
+		assert.Equal(t, openai.ChatMessageRoleUser, req.Messages[3].Role)
+		assert.Contains(t, req.Messages[3].Content, "Unknown account 'cash'")
+		assert.Contains(t, req.Messages[3].Content, "unable to unmarshal response")
+	})
+
+	t.Run("response in markdown block", func(t *testing.T) {
+		content = "```json\n" + generatedTransactionJSON + "\n```"
+		tr, err := gen.GenerateTransaction(testPromptCtx())
+		require.NoError(t, err)
+		assertGeneratedTransaction(t, tr)
+	})
+
+	t.Run("response with details", func(t *testing.T) {
+		content = `{"date": "2024-02-14", "state": "pending", "code": "42", "description": "Taco Bell", "tags": ["food"],
+			"metadata": {"Card": "visa"}, "postings": [
+			{"account": "Assets:Card", "amount": -20, "currency": "EUR", "note": "card", "cost": {"amount": 21.50, "currency": "USD", "total": true}},
+			{"account": "Expenses:Food", "amount": 20, "currency": "EUR", "account_kind": "balanced_virtual", "aux_date": "2024-02-15"}]}`
+		tr, err := gen.GenerateTransaction(testPromptCtx())
+		require.NoError(t, err)
+		assert.Equal(t, StatePending, tr.State)
+		assert.Equal(t, "42", tr.Code)
+		assert.Equal(t, []string{"food"}, tr.Tags)
+		assert.Equal(t, map[string]string{"Card": "visa"}, tr.Metadata)
+		assert.Equal(t, "card", tr.Postings[0].Note)
+		assert.Equal(t, &Price{Amount: NewDecimal(2150, 2), Currency: "USD", Total: true}, tr.Postings[0].Cost)
+		assert.Equal(t, AccountBalancedVirtual, tr.Postings[1].AccountKind)
+		assert.Equal(t, "2024-02-15", tr.Postings[1].AuxDate)
+	})
+
+	t.Run("invalid response", func(t *testing.T) {
+		content = "I can't do that"
+		_, err := gen.GenerateTransaction(testPromptCtx())
+		assert.ErrorContains(t, err, "unable to unmarshal response")
+	})
+
+	t.Run("unknown fields in response", func(t *testing.T) {
+		content = `{"date": "2024-02-14", "description": "Taco Bell", "payee": "Taco Bell", "postings": []}`
+		_, err := gen.GenerateTransaction(testPromptCtx())
+		assert.ErrorContains(t, err, `unknown field "payee"`)
+	})
+}
+
+func TestAnthropicTransactionGenerator(t *testing.T) {
+	var req anthropicRequest
+	status := http.StatusOK
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/messages", r.URL.Path)
+		assert.Equal(t, "test-key", r.Header.Get("x-api-key"))
+		assert.Equal(t, anthropicAPIVersion, r.Header.Get("anthropic-version"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			_, _ = w.Write([]byte(`{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"content": []map[string]any{
+				{"type": "text", "text": "Sure, here is the transaction."},
+				{"type": "tool_use", "id": "toolu_1", "name": proposeTransactionTool, "input": json.RawMessage(generatedTransactionJSON)},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	gen, err := NewTransactionGenerator(ProviderAnthropic, LLMOpts{
+		Token:   "test-key",
+		BaseURL: srv.URL,
+	})
+	require.NoError(t, err)
+
+	t.Run("success", func(t *testing.T) {
+		tr, err := gen.GenerateTransaction(testPromptCtx())
+		require.NoError(t, err)
+		assertGeneratedTransaction(t, tr)
+
+		assert.Equal(t, anthropicDefaultModel, req.Model)
+		assert.Contains(t, req.System, "Assets:Cash")
+		assert.Equal(t, []anthropicMessage{{Role: "user", Content: "20 Taco Bell"}}, req.Messages)
+		require.Len(t, req.Tools, 1)
+		assert.Equal(t, proposeTransactionTool, req.Tools[0].Name)
+		assert.Equal(t, &anthropicToolChoice{Type: "tool", Name: proposeTransactionTool}, req.ToolChoice)
+	})
+
+	t.Run("api error", func(t *testing.T) {
+		status = http.StatusUnauthorized
+		_, err := gen.GenerateTransaction(testPromptCtx())
+		assert.ErrorContains(t, err, "invalid x-api-key")
+	})
+}
+
+func TestRuleBasedTransactionGenerator(t *testing.T) {
+	gen := NewRuleBasedTransactionGenerator()
+
+	cases := []struct {
+		input    string
+		desc     string
+		postings []Posting
+		err      string
+	}{
+		{
+			input: "20 Taco Bell",
+			desc:  "Taco Bell",
+			postings: []Posting{
+				{Account: "Assets:Cash", Amount: NewDecimal(-20, 0), Currency: "EUR"},
+				{Account: "Expenses:Food", Amount: NewDecimal(20, 0), Currency: "EUR"},
+			},
+		},
+		{
+			input: "taxi 12,50 usd card",
+			desc:  "taxi",
+			postings: []Posting{
+				{Account: "Assets:Card", Amount: NewDecimal(-1250, 2), Currency: "USD"},
+				{Account: "Expenses:Taxi", Amount: NewDecimal(1250, 2), Currency: "USD"},
+			},
+		},
+		{
+			input: "$7 coffee",
+			desc:  "coffee",
+			postings: []Posting{
+				{Account: "Assets:Cash", Amount: NewDecimal(-7, 0), Currency: "USD"},
+				{Account: "Expenses:Food", Amount: NewDecimal(7, 0), Currency: "USD"},
+			},
+		},
+		{
+			input: "coffee",
+			err:   "no amount found",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.input, func(t *testing.T) {
+			promptCtx := testPromptCtx()
+			promptCtx.UserInput = c.input
+
+			tr, err := gen.GenerateTransaction(promptCtx)
+			if c.err != "" {
+				assert.ErrorContains(t, err, c.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, c.desc, tr.Description)
+			assert.Equal(t, "2024-02-14", tr.Date)
+			assert.Equal(t, c.input, tr.Comment)
+			assert.Equal(t, c.postings, tr.Postings)
+		})
+	}
+}
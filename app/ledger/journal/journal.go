@@ -0,0 +1,579 @@
+// Package journal parses ledger-cli journals into typed entries.
+// The entries keep their source, so the journal is written back exactly
+// as it was read, only the edited lines are formatted again.
+package journal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Pos is the position of an entry or a posting in the journal
+type Pos struct {
+	File string
+	// Line is 1-based
+	Line int
+}
+
+func (p Pos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("line %d", p.Line)
+	}
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// Error is a syntax error in the journal
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// source is the text of a line as it was read. The line is written as is
+// while its fields are formatted to the same canonical text,
+// so the formatting of the untouched lines is kept.
+type source struct {
+	raw, canonical string
+}
+
+func (s source) render(canonical string) string {
+	if s.raw != "" && s.canonical == canonical {
+		return s.raw
+	}
+	return canonical
+}
+
+// render returns the line i of the list formatted as canonical,
+// the list may have been changed since it was read
+func render(srcs []source, i int, canonical string) string {
+	if i < len(srcs) {
+		return srcs[i].render(canonical)
+	}
+	return canonical
+}
+
+// indent of the lines of the transactions and the directives formatted again
+const indent = "    "
+
+// Journal is a parsed journal file, the includes are not followed
+type Journal struct {
+	File    string
+	Entries []Entry
+	// the source doesn't end with a newline
+	noFinalNewline bool
+}
+
+// Entry is a top level item of the journal:
+// *Transaction, *Directive, *Comment or *Blank
+type Entry interface {
+	// Position returns the position of the first line
+	Position() Pos
+	// String returns the text of the entry, every line ends with a newline
+	String() string
+}
+
+func (j *Journal) String() string {
+	var b strings.Builder
+	for _, e := range j.Entries {
+		b.WriteString(e.String())
+	}
+	s := b.String()
+	if j.noFinalNewline {
+		s = strings.TrimSuffix(s, "\n")
+	}
+	return s
+}
+
+// Transactions returns the transactions of the journal in order
+func (j *Journal) Transactions() []*Transaction {
+	var res []*Transaction
+	for _, e := range j.Entries {
+		if t, ok := e.(*Transaction); ok {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// Directives returns the directives with the name, e.g. "account", in order
+func (j *Journal) Directives(name string) []*Directive {
+	var res []*Directive
+	for _, e := range j.Entries {
+		if d, ok := e.(*Directive); ok && d.Name == name {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// FindByMeta returns the first transaction with the metadata, nil if there is none
+func (j *Journal) FindByMeta(key, value string) *Transaction {
+	for _, t := range j.Transactions() {
+		if v, ok := t.Meta(key); ok && v == value {
+			return t
+		}
+	}
+	return nil
+}
+
+// State is the clearing state of a transaction or a posting
+type State int
+
+const (
+	Uncleared State = iota
+	// Pending is marked with `!`
+	Pending
+	// Cleared is marked with `*`
+	Cleared
+)
+
+func (s State) String() string {
+	switch s {
+	case Pending:
+		return "!"
+	case Cleared:
+		return "*"
+	}
+	return ""
+}
+
+// Notes are the comments of a transaction or a posting: the note on the same
+// line and the indented comment lines below it. They carry the tags,
+// `; :food:travel:`, and the metadata, `; Key: value`.
+type Notes struct {
+	Note string
+	// Comments are the text of the comment lines, without the `;`
+	Comments   []string
+	commentSrc []source
+}
+
+// tagsRe matches the tags, `:food:travel:`
+var tagsRe = regexp.MustCompile(`(?:^|\s):((?:[^\s:]+:)+)(?:\s|$)`)
+
+// metaRe matches the metadata, `Key: value` or the typed `Key:: value`
+var metaRe = regexp.MustCompile(`^([^\s:]+)::?(?:\s+(.*?))?\s*$`)
+
+func (n *Notes) all() []string {
+	if n.Note == "" {
+		return n.Comments
+	}
+	return append([]string{n.Note}, n.Comments...)
+}
+
+// Tags returns the tags of the notes in order
+func (n *Notes) Tags() []string {
+	var res []string
+	for _, c := range n.all() {
+		for _, m := range tagsRe.FindAllStringSubmatch(c, -1) {
+			res = append(res, strings.Split(strings.TrimSuffix(m[1], ":"), ":")...)
+		}
+	}
+	return res
+}
+
+// HasTag reports whether the notes have the tag
+func (n *Notes) HasTag(tag string) bool {
+	for _, t := range n.Tags() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Meta returns the value of the metadata
+func (n *Notes) Meta(key string) (string, bool) {
+	for _, c := range n.all() {
+		if m := metaRe.FindStringSubmatch(strings.TrimSpace(c)); m != nil && m[1] == key {
+			return m[2], true
+		}
+	}
+	return "", false
+}
+
+// Metadata returns all the metadata, the first value of a key wins
+func (n *Notes) Metadata() map[string]string {
+	res := make(map[string]string)
+	for _, c := range n.all() {
+		if m := metaRe.FindStringSubmatch(strings.TrimSpace(c)); m != nil {
+			if _, ok := res[m[1]]; !ok {
+				res[m[1]] = m[2]
+			}
+		}
+	}
+	return res
+}
+
+// SetMeta replaces the value of the metadata, or adds a comment line with it
+func (n *Notes) SetMeta(key, value string) {
+	line := fmt.Sprintf("%s: %s", key, value)
+	if m := metaRe.FindStringSubmatch(strings.TrimSpace(n.Note)); m != nil && m[1] == key {
+		n.Note = line
+		return
+	}
+	for i, c := range n.Comments {
+		if m := metaRe.FindStringSubmatch(strings.TrimSpace(c)); m != nil && m[1] == key {
+			n.Comments[i] = line
+			return
+		}
+	}
+	n.Comments = append(n.Comments, line)
+}
+
+func (n *Notes) writeComments(b *strings.Builder, prefix string) {
+	for i, c := range n.Comments {
+		b.WriteString(render(n.commentSrc, i, formatComment(prefix, c)))
+		b.WriteString("\n")
+	}
+}
+
+func formatComment(prefix, text string) string {
+	if text == "" {
+		return prefix + ";"
+	}
+	return prefix + "; " + text
+}
+
+// Transaction is a dated transaction:
+//
+//	2024-02-13=2024-02-15 * (1042) Taco Bell  ; note
+//	    ; :food:
+//	    Expenses:Food  10.00 EUR
+//	    Assets:Cash
+type Transaction struct {
+	Pos Pos
+	// Date and AuxDate as written, see Time
+	Date    string
+	AuxDate string
+	State   State
+	Code    string
+	Payee   string
+	// Notes are the note after the payee and the comment lines
+	// before the first posting
+	Notes
+	Postings []*Posting
+	src      source
+}
+
+func (t *Transaction) Position() Pos {
+	return t.Pos
+}
+
+// dateLayouts are the layouts of the dates with the year
+var dateLayouts = []string{"2006-01-02", "2006/01/02", "2006.01.02", "2006-1-2", "2006/1/2", "2006.1.2"}
+
+// Time returns the date of the transaction, the dates without the year are not supported
+func (t *Transaction) Time() (time.Time, error) {
+	for _, layout := range dateLayouts {
+		d, err := time.Parse(layout, t.Date)
+		if err == nil {
+			return d, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported date %q", t.Date)
+}
+
+func (t *Transaction) header() string {
+	var b strings.Builder
+	b.WriteString(t.Date)
+	if t.AuxDate != "" {
+		b.WriteString("=" + t.AuxDate)
+	}
+	if t.State != Uncleared {
+		b.WriteString(" " + t.State.String())
+	}
+	if t.Code != "" {
+		b.WriteString(" (" + t.Code + ")")
+	}
+	if t.Payee != "" {
+		b.WriteString(" " + t.Payee)
+	}
+	if t.Note != "" {
+		b.WriteString("  ; " + t.Note)
+	}
+	return b.String()
+}
+
+func (t *Transaction) String() string {
+	var b strings.Builder
+	b.WriteString(t.src.render(t.header()))
+	b.WriteString("\n")
+	t.writeComments(&b, indent)
+	for _, p := range t.Postings {
+		b.WriteString(p.String())
+	}
+	return b.String()
+}
+
+// AccountKind tells whether the posting is real or virtual
+type AccountKind int
+
+const (
+	Real AccountKind = iota
+	// Virtual postings, `(Budget:Food)`, don't have to balance
+	Virtual
+	// BalancedVirtual postings, `[Budget:Food]`, balance among themselves
+	BalancedVirtual
+)
+
+// Posting is a line of a transaction:
+//
+//	! Assets:Broker  10 AAPL {150.00 USD} [2024-02-13] @ 160.00 USD = 30 AAPL  ; note
+type Posting struct {
+	Pos     Pos
+	State   State
+	Account string
+	Kind    AccountKind
+	// Amount is nil if it's elided, so it balances the transaction
+	Amount *Amount
+	Lot    *Lot
+	Cost   *Cost
+	// Assertion is the balance of the account after the posting, `= 30 AAPL`
+	Assertion *Amount
+	// Notes are the note after the amount and the comment lines below the posting
+	Notes
+	src source
+}
+
+func (p *Posting) account() string {
+	switch p.Kind {
+	case Virtual:
+		return "(" + p.Account + ")"
+	case BalancedVirtual:
+		return "[" + p.Account + "]"
+	}
+	return p.Account
+}
+
+// amounts returns the amount with the annotations, empty if there are none
+func (p *Posting) amounts() string {
+	var parts []string
+	if p.Amount != nil {
+		parts = append(parts, p.Amount.String())
+	}
+	if p.Lot != nil {
+		parts = append(parts, p.Lot.String())
+	}
+	if p.Cost != nil {
+		parts = append(parts, p.Cost.String())
+	}
+	if p.Assertion != nil {
+		parts = append(parts, "= "+p.Assertion.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (p *Posting) line() string {
+	var b strings.Builder
+	b.WriteString(indent)
+	if p.State != Uncleared {
+		b.WriteString(p.State.String() + " ")
+	}
+	b.WriteString(p.account())
+	if a := p.amounts(); a != "" {
+		b.WriteString("  " + a)
+	}
+	if p.Note != "" {
+		b.WriteString("  ; " + p.Note)
+	}
+	return b.String()
+}
+
+func (p *Posting) String() string {
+	var b strings.Builder
+	b.WriteString(p.src.render(p.line()))
+	b.WriteString("\n")
+	p.writeComments(&b, indent+"  ")
+	return b.String()
+}
+
+// Amount is a quantity of a commodity as it's written: `-1,000.50 EUR`, `$10`
+// or `10 "AAPL 2024"`, or a value expression: `(10 EUR * 2)`
+type Amount struct {
+	// Quantity is the number with the sign and the separators
+	Quantity string
+	// Commodity is unquoted, it's quoted when it's formatted if necessary
+	Commodity string
+	// Prefix means the commodity is written before the quantity
+	Prefix bool
+	// Space separates the commodity and the quantity
+	Space bool
+	// Expr is the value expression in parentheses,
+	// the other fields are empty then
+	Expr string
+}
+
+// NewAmount returns the amount with the commodity after the quantity,
+// separated with a space
+func NewAmount(quantity, commodity string) *Amount {
+	return &Amount{Quantity: quantity, Commodity: commodity, Space: commodity != ""}
+}
+
+func (a *Amount) String() string {
+	if a.Expr != "" {
+		return a.Expr
+	}
+	if a.Commodity == "" {
+		return a.Quantity
+	}
+	c := quoteCommodity(a.Commodity)
+	sep := ""
+	if a.Space {
+		sep = " "
+	}
+	if a.Prefix {
+		return c + sep + a.Quantity
+	}
+	return a.Quantity + sep + c
+}
+
+// Lot is the annotation of the lot the commodity is bought in:
+// `{150.00 USD} [2024-02-13] (note)`
+type Lot struct {
+	// Price is per unit, or of the whole lot with `{{}}`
+	Price      *Amount
+	TotalPrice bool
+	// Fixated price, `{=150.00 USD}`
+	Fixated bool
+	Date    string
+	Note    string
+}
+
+func (l *Lot) String() string {
+	var parts []string
+	if l.Price != nil {
+		open, closing := "{", "}"
+		if l.TotalPrice {
+			open, closing = "{{", "}}"
+		}
+		if l.Fixated {
+			open += "="
+		}
+		parts = append(parts, open+l.Price.String()+closing)
+	}
+	if l.Date != "" {
+		parts = append(parts, "["+l.Date+"]")
+	}
+	if l.Note != "" {
+		parts = append(parts, "("+l.Note+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Cost is the price the amount is exchanged at,
+// `@ 1.10 USD` per unit or `@@ 110.00 USD` in total
+type Cost struct {
+	Amount Amount
+	Total  bool
+	// Virtual costs, `(@)`, are not used to balance the transaction
+	Virtual bool
+}
+
+func (c *Cost) String() string {
+	op := "@"
+	if c.Total {
+		op = "@@"
+	}
+	if c.Virtual {
+		op = "(" + op + ")"
+	}
+	return op + " " + c.Amount.String()
+}
+
+// Directive is a top level line which is not a transaction,
+// e.g. `account Expenses:Food`, `commodity EUR`, `include 2024.ledger`
+// or `P 2024-02-13 EUR 1.08 USD`, with the indented lines below it
+type Directive struct {
+	Pos Pos
+	// Name is the keyword, `=` of the automated and `~` of the periodic transactions
+	Name string
+	Arg  string
+	// Sub are the indented lines, e.g. `format 1,000.00 EUR` of a commodity,
+	// a comment line has the name `;`
+	Sub []*Directive
+	src source
+}
+
+func (d *Directive) Position() Pos {
+	return d.Pos
+}
+
+func (d *Directive) line() string {
+	if d.Arg == "" {
+		return d.Name
+	}
+	return d.Name + " " + d.Arg
+}
+
+// SubArg returns the argument of the first sub-directive with the name
+func (d *Directive) SubArg(name string) (string, bool) {
+	for _, s := range d.Sub {
+		if s.Name == name {
+			return s.Arg, true
+		}
+	}
+	return "", false
+}
+
+func (d *Directive) String() string {
+	var b strings.Builder
+	b.WriteString(d.src.render(d.line()))
+	b.WriteString("\n")
+	for _, s := range d.Sub {
+		b.WriteString(s.src.render(indent + s.line()))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Comment is a block of top level comment lines, or a `comment` ... `end comment` block
+type Comment struct {
+	Pos Pos
+	// Lines as they're written
+	Lines []string
+}
+
+func (c *Comment) Position() Pos {
+	return c.Pos
+}
+
+// Text returns the text of the comment lines, without the comment characters
+func (c *Comment) Text() string {
+	var res []string
+	for _, line := range c.Lines {
+		res = append(res, strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), ";#%|*")))
+	}
+	return strings.Join(res, "\n")
+}
+
+func (c *Comment) String() string {
+	return joinLines(c.Lines)
+}
+
+// Blank is a run of empty lines
+type Blank struct {
+	Pos   Pos
+	Lines []string
+}
+
+func (b *Blank) Position() Pos {
+	return b.Pos
+}
+
+func (b *Blank) String() string {
+	return joinLines(b.Lines)
+}
+
+func joinLines(lines []string) string {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,274 @@
+package journal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sample = `;; main journal
+# other comment
+
+account Expenses:Food
+    note food and drinks
+commodity EUR
+    format 1,000.00 EUR
+include 2024.ledger
+P 2024-02-13 EUR 1.08 USD
+
+2024-02-13=2024-02-15 * (1042) Taco Bell  ; :food:lunch:
+    ; tid: 3f2a
+    Expenses:Food            10.00 EUR  ; Place: Berlin
+        ; :work:
+    ! Assets:Cash           -10.00 EUR
+    (Budget:Food)           -10 EUR
+
+2024/02/14 ! Broker
+	Assets:Broker	10 "AAPL 2024" {150.00 USD} [2024-02-13] (first) @ $160.00 = 30 "AAPL 2024"
+	[Assets:Checking]  $-1,600.00
+	Equity:Gains  (10 * 2 USD)
+	Assets:Cash  = 0 USD
+
+2024-02-15 Uncleared
+    Expenses:Food  €5
+    Assets:Cash  -5 € @@ $5.40
+
+comment
+anything 2024-01-01 here
+end comment
+= /Food/
+    (Budget:Food)  -1
+~ Monthly
+    Expenses:Rent  500 EUR
+    Assets:Cash
+`
+
+func parseString(t *testing.T, s string) *Journal {
+	t.Helper()
+	j, err := Parse(strings.NewReader(s), "main.ledger")
+	require.NoError(t, err)
+	return j
+}
+
+func TestParse(t *testing.T) {
+	j := parseString(t, sample)
+
+	t.Run("entries", func(t *testing.T) {
+		var kinds []string
+		for _, e := range j.Entries {
+			switch e := e.(type) {
+			case *Transaction:
+				kinds = append(kinds, "transaction")
+			case *Directive:
+				kinds = append(kinds, e.Name)
+			case *Comment:
+				kinds = append(kinds, "comment")
+			case *Blank:
+				kinds = append(kinds, "blank")
+			}
+		}
+		assert.Equal(t, []string{
+			"comment", "blank", "account", "commodity", "include", "P", "blank",
+			"transaction", "blank", "transaction", "blank", "transaction", "blank",
+			"comment", "=", "~",
+		}, kinds)
+		assert.Equal(t, Pos{File: "main.ledger", Line: 11}, j.Entries[7].Position())
+		assert.Equal(t, "main journal\nother comment", j.Entries[0].(*Comment).Text())
+	})
+
+	t.Run("directives", func(t *testing.T) {
+		commodities := j.Directives("commodity")
+		require.Len(t, commodities, 1)
+		assert.Equal(t, "EUR", commodities[0].Arg)
+		format, ok := commodities[0].SubArg("format")
+		assert.True(t, ok)
+		assert.Equal(t, "1,000.00 EUR", format)
+		assert.Equal(t, "2024.ledger", j.Directives("include")[0].Arg)
+		assert.Equal(t, "2024-02-13 EUR 1.08 USD", j.Directives("P")[0].Arg)
+		assert.Equal(t, "/Food/", j.Directives("=")[0].Arg)
+		assert.Len(t, j.Directives("~")[0].Sub, 2)
+	})
+
+	transactions := j.Transactions()
+	require.Len(t, transactions, 3)
+
+	t.Run("transaction", func(t *testing.T) {
+		tr := transactions[0]
+		assert.Equal(t, "2024-02-13", tr.Date)
+		assert.Equal(t, "2024-02-15", tr.AuxDate)
+		assert.Equal(t, Cleared, tr.State)
+		assert.Equal(t, "1042", tr.Code)
+		assert.Equal(t, "Taco Bell", tr.Payee)
+		assert.Equal(t, []string{"food", "lunch"}, tr.Tags())
+		tid, ok := tr.Meta("tid")
+		assert.True(t, ok)
+		assert.Equal(t, "3f2a", tid)
+		assert.Same(t, tr, j.FindByMeta("tid", "3f2a"))
+		assert.Nil(t, j.FindByMeta("tid", "other"))
+		date, err := tr.Time()
+		require.NoError(t, err)
+		assert.Equal(t, "2024-02-13", date.Format("2006-01-02"))
+
+		require.Len(t, tr.Postings, 3)
+		food := tr.Postings[0]
+		assert.Equal(t, Pos{File: "main.ledger", Line: 13}, food.Pos)
+		assert.Equal(t, "Expenses:Food", food.Account)
+		assert.Equal(t, &Amount{Quantity: "10.00", Commodity: "EUR", Space: true}, food.Amount)
+		assert.Equal(t, map[string]string{"Place": "Berlin"}, food.Metadata())
+		assert.Equal(t, []string{"work"}, food.Tags())
+		assert.True(t, food.HasTag("work"))
+		assert.False(t, tr.HasTag("work"))
+
+		assert.Equal(t, Pending, tr.Postings[1].State)
+		assert.Equal(t, "Assets:Cash", tr.Postings[1].Account)
+		assert.Equal(t, Virtual, tr.Postings[2].Kind)
+		assert.Equal(t, "Budget:Food", tr.Postings[2].Account)
+	})
+
+	t.Run("annotations", func(t *testing.T) {
+		tr := transactions[1]
+		assert.Equal(t, Pending, tr.State)
+		assert.Equal(t, "Broker", tr.Payee)
+		require.Len(t, tr.Postings, 4)
+
+		broker := tr.Postings[0]
+		assert.Equal(t, &Amount{Quantity: "10", Commodity: "AAPL 2024", Space: true}, broker.Amount)
+		assert.Equal(t, &Lot{
+			Price: &Amount{Quantity: "150.00", Commodity: "USD", Space: true},
+			Date:  "2024-02-13",
+			Note:  "first",
+		}, broker.Lot)
+		assert.Equal(t, &Cost{Amount: Amount{Quantity: "160.00", Commodity: "$", Prefix: true}}, broker.Cost)
+		assert.Equal(t, &Amount{Quantity: "30", Commodity: "AAPL 2024", Space: true}, broker.Assertion)
+
+		assert.Equal(t, BalancedVirtual, tr.Postings[1].Kind)
+		assert.Equal(t, &Amount{Quantity: "-1,600.00", Commodity: "$", Prefix: true}, tr.Postings[1].Amount)
+		assert.Equal(t, &Amount{Expr: "(10 * 2 USD)"}, tr.Postings[2].Amount)
+		assert.Nil(t, tr.Postings[3].Amount)
+		assert.Equal(t, &Amount{Quantity: "0", Commodity: "USD", Space: true}, tr.Postings[3].Assertion)
+
+		tr = transactions[2]
+		assert.Equal(t, Uncleared, tr.State)
+		assert.Equal(t, &Amount{Quantity: "5", Commodity: "€", Prefix: true}, tr.Postings[0].Amount)
+		assert.Equal(t, &Cost{Amount: Amount{Quantity: "5.40", Commodity: "$", Prefix: true}, Total: true}, tr.Postings[1].Cost)
+	})
+}
+
+func TestJournal_String(t *testing.T) {
+	tbl := []struct {
+		name, in string
+	}{
+		{"sample", sample},
+		{"empty", ""},
+		{"no final newline", "2024-02-13 Shop\n    Expenses:Food  10 EUR\n    Assets:Cash"},
+		{"crlf", "account Food\r\n\r\n2024-02-13 * Shop\r\n    Food  10 EUR\r\n    Cash\r\n"},
+		{"odd spacing", "2024-02-13   *   Shop   ;  note  \n  Food      10   EUR   \n\t\tCash\n\n\n"},
+		{"indented comment", "    ; orphan\n;; top\n    ; continued\n"},
+		{"teledger", ";; user input\n2024-02-13 * Shop\n    ;; tid: 1\n    Food  10,00 EUR\n    Cash\n"},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.in, parseString(t, tt.in).String())
+		})
+	}
+}
+
+func TestJournal_Edit(t *testing.T) {
+	j := parseString(t, sample)
+	tr := j.Transactions()[0]
+
+	tr.Payee = "Taco Bell Berlin"
+	tr.Postings[0].Amount = NewAmount("12.50", "EUR")
+	tr.Postings[1].Amount.Quantity = "-12.50"
+	tr.SetMeta("tid", "4b1c")
+	tr.Postings[1].SetMeta("Paid", "cash")
+	tr.Postings = append(tr.Postings[:2], &Posting{
+		Account: "Budget:Food",
+		Kind:    BalancedVirtual,
+		Amount:  &Amount{Quantity: "-12.50", Commodity: "€", Prefix: true},
+		Cost:    &Cost{Amount: *NewAmount("1", "EUR"), Virtual: true},
+	})
+
+	assert.Equal(t, `2024-02-13=2024-02-15 * (1042) Taco Bell Berlin  ; :food:lunch:
+    ; tid: 4b1c
+    Expenses:Food  12.50 EUR  ; Place: Berlin
+        ; :work:
+    ! Assets:Cash  -12.50 EUR
+      ; Paid: cash
+    [Budget:Food]  €-12.50 (@) 1 EUR
+`, tr.String())
+
+	// the other entries are kept as they were
+	before := strings.Split(sample, "\n")
+	after := strings.Split(j.String(), "\n")
+	assert.Equal(t, before[:10], after[:10])
+	assert.Equal(t, before[16:], after[17:])
+
+	again := parseString(t, j.String()).Transactions()[0]
+	assert.Equal(t, "Taco Bell Berlin", again.Payee)
+	assert.Equal(t, NewAmount("12.50", "EUR"), again.Postings[0].Amount)
+	paid, _ := again.Postings[1].Meta("Paid")
+	assert.Equal(t, "cash", paid)
+}
+
+func TestParseAmount(t *testing.T) {
+	tbl := []struct {
+		in  string
+		out *Amount
+		// str is the formatted amount if it differs from the input
+		str string
+		err string
+	}{
+		{in: "10", out: &Amount{Quantity: "10"}},
+		{in: "-1,000.50 EUR", out: &Amount{Quantity: "-1,000.50", Commodity: "EUR", Space: true}},
+		{in: "10EUR", out: &Amount{Quantity: "10", Commodity: "EUR"}},
+		{in: "$10", out: &Amount{Quantity: "10", Commodity: "$", Prefix: true}},
+		{in: "-$10", out: &Amount{Quantity: "-10", Commodity: "$", Prefix: true}, str: "$-10"},
+		{in: "EUR -10", out: &Amount{Quantity: "-10", Commodity: "EUR", Prefix: true, Space: true}},
+		{in: `10 "AAPL 2024"`, out: &Amount{Quantity: "10", Commodity: "AAPL 2024", Space: true}},
+		{in: "(10 EUR * (1 + 1))", out: &Amount{Expr: "(10 EUR * (1 + 1))"}},
+		{in: "EUR", err: "quantity is missing"},
+		{in: "10 EUR EUR", err: "unexpected"},
+		{in: "(10 EUR", err: "not closed"},
+		{in: "", err: "amount is missing"},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.in, func(t *testing.T) {
+			a, err := ParseAmount(tt.in)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.out, a)
+			if tt.str == "" {
+				tt.str = tt.in
+			}
+			assert.Equal(t, tt.str, a.String())
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tbl := []struct {
+		name, in, err string
+	}{
+		{"date", "2024-02-13x Shop\n", "main.ledger:1: invalid date"},
+		{"aux date", "2024-02-13=x Shop\n", "main.ledger:1: invalid auxiliary date"},
+		{"code", "2024-02-13 (12 Shop\n", "main.ledger:1: code is not closed"},
+		{"amount", "\n2024-02-13 Shop\n    Food  10 EUR @\n", "main.ledger:3: invalid amount of Food: amount is missing"},
+		{"lot", "2024-02-13 Shop\n    Food  10 AAPL {1 USD\n", "main.ledger:2: invalid amount of Food: lot price is not closed"},
+		{"comment block", "comment\nfoo\n", "main.ledger:1: comment block is not closed"},
+		{"indented", "\n    Food  10 EUR\n", `main.ledger:2: unexpected indented line "Food  10 EUR"`},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.in), "main.ledger")
+			var perr *Error
+			require.ErrorAs(t, err, &perr)
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
@@ -0,0 +1,540 @@
+package journal
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// dateRe matches the dates, the year is optional in ledger
+var dateRe = regexp.MustCompile(`^(?:\d{4}[-/.]\d{1,2}[-/.]\d{1,2}|\d{1,2}[-/.]\d{1,2})`)
+
+// commentChars start the top level comment lines
+const commentChars = ";#%|*"
+
+type parser struct {
+	file  string
+	lines []string
+	i     int
+}
+
+// Parse reads the journal, the file is used for the positions only
+// and the includes are not followed
+func Parse(r io.Reader, file string) (*Journal, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read journal: %v", err)
+	}
+	j := &Journal{File: file}
+	s := string(data)
+	if s == "" {
+		return j, nil
+	}
+	j.noFinalNewline = !strings.HasSuffix(s, "\n")
+	p := &parser{file: file, lines: strings.Split(strings.TrimSuffix(s, "\n"), "\n")}
+	for p.i < len(p.lines) {
+		e, err := p.entry()
+		if err != nil {
+			return nil, err
+		}
+		j.Entries = append(j.Entries, e)
+	}
+	return j, nil
+}
+
+func (p *parser) pos() Pos {
+	return Pos{File: p.file, Line: p.i + 1}
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &Error{Pos: p.pos(), Msg: fmt.Sprintf(format, args...)}
+}
+
+// text returns the current line without the carriage return
+func (p *parser) text() string {
+	return strings.TrimSuffix(p.lines[p.i], "\r")
+}
+
+func isBlank(s string) bool {
+	return strings.TrimSpace(s) == ""
+}
+
+func isIndented(s string) bool {
+	return s != "" && (s[0] == ' ' || s[0] == '\t')
+}
+
+// isIndentedComment reports whether the line is an indented `;` comment
+func isIndentedComment(s string) bool {
+	return isIndented(s) && strings.HasPrefix(strings.TrimLeft(s, " \t"), ";")
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (p *parser) entry() (Entry, error) {
+	text := p.text()
+	switch {
+	case isBlank(text):
+		b := &Blank{Pos: p.pos()}
+		for p.i < len(p.lines) && isBlank(p.text()) {
+			b.Lines = append(b.Lines, p.lines[p.i])
+			p.i++
+		}
+		return b, nil
+	case isIndentedComment(text), strings.ContainsRune(commentChars, rune(text[0])):
+		c := &Comment{Pos: p.pos()}
+		for p.i < len(p.lines) {
+			s := p.text()
+			if isBlank(s) || !isIndentedComment(s) && !strings.ContainsRune(commentChars, rune(s[0])) {
+				break
+			}
+			c.Lines = append(c.Lines, p.lines[p.i])
+			p.i++
+		}
+		return c, nil
+	case isIndented(text):
+		return nil, p.errorf("unexpected indented line %q", strings.TrimSpace(text))
+	case isDigit(text[0]):
+		return p.transaction()
+	}
+	if word := strings.Fields(text)[0]; word == "comment" || word == "test" {
+		return p.commentBlock(word)
+	}
+	return p.directive(), nil
+}
+
+// commentBlock reads the lines up to the `end comment`
+func (p *parser) commentBlock(word string) (*Comment, error) {
+	c := &Comment{Pos: p.pos()}
+	for p.i < len(p.lines) {
+		s := p.text()
+		c.Lines = append(c.Lines, p.lines[p.i])
+		p.i++
+		if len(c.Lines) > 1 && strings.Join(strings.Fields(s), " ") == "end "+word {
+			return c, nil
+		}
+	}
+	return nil, &Error{Pos: c.Pos, Msg: fmt.Sprintf("%s block is not closed", word)}
+}
+
+func (p *parser) directive() *Directive {
+	d := newDirective(p.pos(), p.text())
+	d.src = source{raw: p.lines[p.i], canonical: d.line()}
+	p.i++
+	for p.i < len(p.lines) && isIndented(p.text()) && !isBlank(p.text()) {
+		s := newDirective(p.pos(), strings.TrimSpace(p.text()))
+		s.src = source{raw: p.lines[p.i], canonical: indent + s.line()}
+		d.Sub = append(d.Sub, s)
+		p.i++
+	}
+	return d
+}
+
+func newDirective(pos Pos, text string) *Directive {
+	d := &Directive{Pos: pos}
+	if text[0] == '=' || text[0] == '~' {
+		d.Name, d.Arg = text[:1], strings.TrimSpace(text[1:])
+		return d
+	}
+	i := strings.IndexAny(text, " \t")
+	if i < 0 {
+		d.Name = text
+		return d
+	}
+	d.Name, d.Arg = text[:i], strings.TrimSpace(text[i:])
+	return d
+}
+
+func (p *parser) transaction() (*Transaction, error) {
+	t := &Transaction{Pos: p.pos()}
+	err := t.parseHeader(p.text())
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	t.src = source{raw: p.lines[p.i], canonical: t.header()}
+	p.i++
+
+	var last *Posting
+	for p.i < len(p.lines) && isIndented(p.text()) && !isBlank(p.text()) {
+		text := p.text()
+		// the comments below a posting belong to it
+		if isIndentedComment(text) {
+			n := &t.Notes
+			prefix := indent
+			if last != nil {
+				n = &last.Notes
+				prefix = indent + "  "
+			}
+			c := commentText(text)
+			n.Comments = append(n.Comments, c)
+			n.commentSrc = append(n.commentSrc, source{raw: p.lines[p.i], canonical: formatComment(prefix, c)})
+			p.i++
+			continue
+		}
+		last, err = parsePosting(text)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		last.Pos = p.pos()
+		last.src = source{raw: p.lines[p.i], canonical: last.line()}
+		t.Postings = append(t.Postings, last)
+		p.i++
+	}
+	return t, nil
+}
+
+func commentText(line string) string {
+	return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), ";"))
+}
+
+// splitNote splits the line at the first `;` which is not quoted
+func splitNote(s string) (string, string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case ';':
+			if !quoted {
+				return s[:i], strings.TrimSpace(s[i+1:])
+			}
+		}
+	}
+	return s, ""
+}
+
+func (t *Transaction) parseHeader(text string) error {
+	t.Date = dateRe.FindString(text)
+	if t.Date == "" {
+		return fmt.Errorf("invalid date in %q", text)
+	}
+	rest := text[len(t.Date):]
+	if strings.HasPrefix(rest, "=") {
+		t.AuxDate = dateRe.FindString(rest[1:])
+		if t.AuxDate == "" {
+			return fmt.Errorf("invalid auxiliary date in %q", text)
+		}
+		rest = rest[1+len(t.AuxDate):]
+	}
+	if rest != "" && !isIndented(rest) {
+		return fmt.Errorf("invalid date in %q", text)
+	}
+	rest = strings.TrimLeft(rest, " \t")
+	t.State, rest = parseState(rest)
+	if strings.HasPrefix(rest, "(") {
+		end := strings.IndexByte(rest, ')')
+		if end < 0 {
+			return fmt.Errorf("code is not closed in %q", text)
+		}
+		t.Code = rest[1:end]
+		rest = strings.TrimLeft(rest[end+1:], " \t")
+	}
+	payee, note := splitNote(rest)
+	t.Payee = strings.TrimSpace(payee)
+	t.Note = note
+	return nil
+}
+
+// parseState returns the state marked at the start of the text
+func parseState(s string) (State, string) {
+	if len(s) > 1 && (s[0] == '*' || s[0] == '!') && (s[1] == ' ' || s[1] == '\t') {
+		state := Cleared
+		if s[0] == '!' {
+			state = Pending
+		}
+		return state, strings.TrimLeft(s[1:], " \t")
+	}
+	if s == "*" {
+		return Cleared, ""
+	}
+	if s == "!" {
+		return Pending, ""
+	}
+	return Uncleared, s
+}
+
+// accountEnd returns the end of the account name: a tab, two spaces,
+// or a space before the note
+func accountEnd(s string) int {
+	end := len(s)
+	for _, sep := range []string{"\t", "  ", " ;"} {
+		if i := strings.Index(s, sep); i >= 0 && i < end {
+			end = i
+		}
+	}
+	return end
+}
+
+func parsePosting(text string) (*Posting, error) {
+	p := &Posting{}
+	rest := strings.TrimLeft(text, " \t")
+	p.State, rest = parseState(rest)
+
+	end := accountEnd(rest)
+	p.Account = rest[:end]
+	rest = rest[end:]
+	switch n := len(p.Account); {
+	case n > 2 && p.Account[0] == '(' && p.Account[n-1] == ')':
+		p.Kind, p.Account = Virtual, p.Account[1:n-1]
+	case n > 2 && p.Account[0] == '[' && p.Account[n-1] == ']':
+		p.Kind, p.Account = BalancedVirtual, p.Account[1:n-1]
+	}
+	if p.Account == "" {
+		return nil, fmt.Errorf("posting without account %q", strings.TrimSpace(text))
+	}
+
+	amounts, note := splitNote(rest)
+	p.Note = note
+	err := p.parseAmounts(amounts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount of %s: %v", p.Account, err)
+	}
+	return p, nil
+}
+
+// parseAmounts parses the amount with the annotations:
+// `AMOUNT {LOT} [DATE] (NOTE) @ COST = ASSERTION`, all of them are optional
+func (p *Posting) parseAmounts(s string) error {
+	i := skipSpace(s, 0)
+	if i == len(s) {
+		return nil
+	}
+	var err error
+	if s[i] != '=' {
+		p.Amount, i, err = parseAmount(s, i)
+		if err != nil {
+			return err
+		}
+	}
+	for i = skipSpace(s, i); i < len(s); i = skipSpace(s, i) {
+		switch {
+		case s[i] == '{':
+			if p.Lot == nil {
+				p.Lot = &Lot{}
+			}
+			i, err = p.Lot.parsePrice(s, i)
+		case s[i] == '[':
+			if p.Lot == nil {
+				p.Lot = &Lot{}
+			}
+			p.Lot.Date, i, err = enclosed(s, i, "[", "]")
+		case strings.HasPrefix(s[i:], "(@"):
+			i, err = p.parseCost(s, i)
+		case s[i] == '(':
+			if p.Lot == nil {
+				p.Lot = &Lot{}
+			}
+			p.Lot.Note, i, err = enclosed(s, i, "(", ")")
+		case s[i] == '@':
+			i, err = p.parseCost(s, i)
+		case s[i] == '=':
+			p.Assertion, i, err = parseAmount(s, i+1)
+		default:
+			err = fmt.Errorf("unexpected %q", s[i:])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Lot) parsePrice(s string, i int) (int, error) {
+	closing := "}"
+	if strings.HasPrefix(s[i:], "{{") {
+		l.TotalPrice = true
+		closing = "}}"
+		i += 2
+	} else {
+		i++
+	}
+	if i < len(s) && s[i] == '=' {
+		l.Fixated = true
+		i++
+	}
+	var err error
+	l.Price, i, err = parseAmount(s, i)
+	if err != nil {
+		return i, err
+	}
+	i = skipSpace(s, i)
+	if !strings.HasPrefix(s[i:], closing) {
+		return i, fmt.Errorf("lot price is not closed in %q", s)
+	}
+	return i + len(closing), nil
+}
+
+func (p *Posting) parseCost(s string, i int) (int, error) {
+	c := &Cost{}
+	if s[i] == '(' {
+		c.Virtual = true
+		i++
+	}
+	i++
+	if i < len(s) && s[i] == '@' {
+		c.Total = true
+		i++
+	}
+	if c.Virtual {
+		if i >= len(s) || s[i] != ')' {
+			return i, fmt.Errorf("virtual cost is not closed in %q", s)
+		}
+		i++
+	}
+	a, i, err := parseAmount(s, i)
+	if err != nil {
+		return i, err
+	}
+	c.Amount = *a
+	p.Cost = c
+	return i, nil
+}
+
+// enclosed returns the text between the brackets at i and the position after them
+func enclosed(s string, i int, open, closing string) (string, int, error) {
+	end := strings.Index(s[i:], closing)
+	if end < 0 {
+		return "", i, fmt.Errorf("%q is not closed in %q", open, s)
+	}
+	return s[i+len(open) : i+end], i + end + len(closing), nil
+}
+
+func skipSpace(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+		i++
+	}
+	return i
+}
+
+// ParseAmount parses an amount: `-1,000.50 EUR`, `$10`, `10 "AAPL 2024"` or `(10 EUR * 2)`
+func ParseAmount(s string) (*Amount, error) {
+	a, i, err := parseAmount(s, 0)
+	if err != nil {
+		return nil, err
+	}
+	if i = skipSpace(s, i); i != len(s) {
+		return nil, fmt.Errorf("unexpected %q after amount", s[i:])
+	}
+	return a, nil
+}
+
+func parseAmount(s string, i int) (*Amount, int, error) {
+	i = skipSpace(s, i)
+	if i == len(s) {
+		return nil, i, fmt.Errorf("amount is missing")
+	}
+	if s[i] == '(' {
+		end, err := closingParen(s, i)
+		if err != nil {
+			return nil, i, err
+		}
+		return &Amount{Expr: s[i : end+1]}, end + 1, nil
+	}
+
+	a := &Amount{}
+	sign := ""
+	if s[i] == '-' || s[i] == '+' {
+		sign = s[i : i+1]
+		i++
+	}
+	if i < len(s) && isNumberChar(s[i]) {
+		var qty string
+		qty, i = number(s, i)
+		a.Quantity = sign + qty
+		j := skipSpace(s, i)
+		c, end, err := commodity(s, j)
+		if err != nil {
+			return nil, i, err
+		}
+		if c == "" {
+			return a, i, nil
+		}
+		a.Commodity, a.Space = c, j > i
+		return a, end, nil
+	}
+
+	c, i, err := commodity(s, i)
+	if err != nil {
+		return nil, i, err
+	}
+	if c == "" {
+		return nil, i, fmt.Errorf("invalid amount %q", s[i:])
+	}
+	a.Commodity, a.Prefix = c, true
+	j := skipSpace(s, i)
+	a.Space = j > i
+	if j < len(s) && (s[j] == '-' || s[j] == '+') && sign == "" {
+		sign = s[j : j+1]
+		j++
+	}
+	if j == len(s) || !isNumberChar(s[j]) {
+		return nil, j, fmt.Errorf("quantity is missing in %q", s)
+	}
+	var qty string
+	qty, i = number(s, j)
+	a.Quantity = sign + qty
+	return a, i, nil
+}
+
+func isNumberChar(c byte) bool {
+	return isDigit(c) || c == '.' || c == ','
+}
+
+func number(s string, i int) (string, int) {
+	j := i
+	for j < len(s) && isNumberChar(s[j]) {
+		j++
+	}
+	return s[i:j], j
+}
+
+// nonCommodityChars can't be a part of an unquoted commodity
+const nonCommodityChars = " \t\r\n0123456789.,;:?!-+*/^&|=<>{}[]()@\""
+
+func isCommodityChar(c byte) bool {
+	return !strings.ContainsRune(nonCommodityChars, rune(c))
+}
+
+// commodity returns the quoted or the unquoted commodity at i, empty if there is none
+func commodity(s string, i int) (string, int, error) {
+	if i < len(s) && s[i] == '"' {
+		end := strings.IndexByte(s[i+1:], '"')
+		if end < 0 {
+			return "", i, fmt.Errorf("commodity is not closed in %q", s)
+		}
+		return s[i+1 : i+1+end], i + end + 2, nil
+	}
+	j := i
+	for j < len(s) && isCommodityChar(s[j]) {
+		j++
+	}
+	return s[i:j], j, nil
+}
+
+func quoteCommodity(c string) string {
+	for i := 0; i < len(c); i++ {
+		if !isCommodityChar(c[i]) {
+			return `"` + c + `"`
+		}
+	}
+	return c
+}
+
+// closingParen returns the position of the parenthesis closing the one at i
+func closingParen(s string, i int) (int, error) {
+	depth := 0
+	for j := i; j < len(s); j++ {
+		switch s[j] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return j, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("expression is not closed in %q", s)
+}
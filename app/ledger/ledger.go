@@ -3,23 +3,27 @@ package ledger
 import (
 	"bufio"
 	"bytes"
-	"context"
+	"crypto/rand"
+	"encoding/base32"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"os"
 	"os/exec"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
 	_ "embed"
 
-	"github.com/dustin/go-humanize"
+	"github.com/mput/teledger/app/ledger/journal"
 	"github.com/mput/teledger/app/repo"
 	"github.com/mput/teledger/app/utils"
-	openai "github.com/sashabaranov/go-openai"
 	"gopkg.in/yaml.v3"
 )
 
@@ -29,7 +33,12 @@ type Ledger struct {
 	// mainFile string
 	// strict    bool
 	generator TransactionGenerator
-	Config    *Config
+	// Config is replaced on every operation while the repo is locked,
+	// use CurrentConfig outside of the ledger operations
+	Config *Config
+	// current is the config read by the last operation, also a read-only one
+	current  *Config
+	configMu sync.RWMutex
 }
 
 type Report struct {
@@ -38,11 +47,22 @@ type Report struct {
 }
 
 type Config struct {
-	MainFile       string   `yaml:"mainFile"`       // default: main.ledger, not required
-	StrictMode     bool     `yaml:"strict"`         // whether to allow non existing accounts and commodities
-	PromptTemplate string   `yaml:"promptTemplate"` // not required
-	Version        string   `yaml:"version"`        // do not include in documentation
-	Reports        []Report `yaml:"reports"`        //
+	MainFile           string       `yaml:"mainFile"`           // default: main.ledger, not required
+	StrictMode         bool         `yaml:"strict"`             // whether to allow non existing accounts and commodities
+	PromptTemplate     string       `yaml:"promptTemplate"`     // not required
+	PromptTemplateFile string       `yaml:"promptTemplateFile"` // path in the repo, takes precedence over promptTemplate
+	Version            string       `yaml:"version"`            // do not include in documentation
+	Reports            []Report     `yaml:"reports"`            //
+	LLM                LLMConfig    `yaml:"llm"`                // overrides of the service llm settings, not required
+	Access             AccessConfig `yaml:"access"`             // not required
+	// git authors of the commits by telegram user id, not required
+	Authors map[int64]Author `yaml:"authors"`
+}
+
+// AccessConfig grants roles to telegram users and chats by their ids
+type AccessConfig struct {
+	Users map[int64]string `yaml:"users"`
+	Chats map[int64]string `yaml:"chats"`
 }
 
 func NewLedger(rs repo.Service, gen TransactionGenerator) *Ledger {
@@ -110,6 +130,18 @@ func resolveIncludesReader(rs repo.Service, file string) (io.ReadCloser, error)
 }
 
 func (l *Ledger) executeWith(additional string, args ...string) (string, error) {
+	res, err := l.run(additional, args...)
+	if err != nil {
+		return "", err
+	}
+	if res == "" {
+		return "", fmt.Errorf("ledger command returned empty result")
+	}
+	return res, nil
+}
+
+// run runs the ledger command, the output may be empty
+func (l *Ledger) run(additional string, args ...string) (string, error) {
 	r, err := resolveIncludesReader(l.repo, l.Config.MainFile)
 	if err != nil {
 		return "", fmt.Errorf("ledger file opening error: %v", err)
@@ -152,11 +184,6 @@ func (l *Ledger) executeWith(additional string, args ...string) (string, error)
 		}
 		return "", fmt.Errorf("ledger command executing error: %v", err)
 	}
-
-	res := out.String()
-	if res == "" {
-		return "", fmt.Errorf("ledger command returned empty result")
-	}
 	return out.String(), nil
 }
 
@@ -164,21 +191,38 @@ func (l *Ledger) execute(args ...string) (string, error) {
 	return l.executeWith("", args...)
 }
 
-func (l *Ledger) Execute(args ...string) (string, error) {
-	err := l.repo.Init()
-	defer l.repo.Free()
+// readOnly returns the ledger working with the snapshot of the repo.
+// The read-only operations don't lock the repo, so they don't wait
+// for each other or for the changes.
+func (l *Ledger) readOnly() (*Ledger, error) {
+	snap, err := l.repo.Snapshot()
 	if err != nil {
-		return "", fmt.Errorf("unable to init repo: %v", err)
+		return nil, fmt.Errorf("unable to get snapshot: %w", err)
+	}
+	ro := &Ledger{
+		repo:      snap,
+		generator: l.generator,
 	}
-	err = l.setConfig()
+	err = ro.setConfig()
 	if err != nil {
-		return "", fmt.Errorf("unable to set config: %v", err)
+		return nil, fmt.Errorf("unable to set config: %v", err)
 	}
+	l.publishConfig(ro.Config)
+	return ro, nil
+}
 
-	return l.execute(args...)
+// Execute runs the ledger command with the files of the snapshot
+func (l *Ledger) Execute(args ...string) (string, error) {
+	ro, err := l.readOnly()
+	if err != nil {
+		return "", err
+	}
+	return ro.execute(args...)
 }
 
-func (l *Ledger) addTransaction(transaction string) error {
+// checkTransaction validates the transaction against the ledger file,
+// the transaction must change the balance
+func (l *Ledger) checkTransaction(transaction string) error {
 	balBefore, err := l.execute("balance")
 	if err != nil {
 		return fmt.Errorf("invalid transaction: %v", err)
@@ -192,12 +236,25 @@ func (l *Ledger) addTransaction(transaction string) error {
 	if balBefore == balAfter {
 		return fmt.Errorf("invalid transaction: transaction doesn't change balance")
 	}
+	return nil
+}
+
+func (l *Ledger) addTransaction(transaction string) error {
+	err := l.checkTransaction(transaction)
+	if err != nil {
+		return err
+	}
+
+	sep, err := l.entrySeparator()
+	if err != nil {
+		return err
+	}
 
 	r, err := l.repo.OpenForAppend(l.Config.MainFile)
 	if err != nil {
 		return fmt.Errorf("unable to open main ledger file: %v", err)
 	}
-	_, err = fmt.Fprintf(r, "\n%s", transaction)
+	_, err = fmt.Fprintf(r, "%s%s", sep, transaction)
 	defer r.Close()
 	if err != nil {
 		return fmt.Errorf("unable to write main ledger file: %v", err)
@@ -205,82 +262,169 @@ func (l *Ledger) addTransaction(transaction string) error {
 	return nil
 }
 
-func (l *Ledger) AddTransaction(transaction string) error {
-	err := l.repo.Init()
-	defer l.repo.Free()
+// entrySeparator returns the newlines to write before the entry appended
+// to the main file, so it's separated from the last entry by an empty line
+func (l *Ledger) entrySeparator() (string, error) {
+	f, err := l.repo.Open(l.Config.MainFile)
 	if err != nil {
-		return fmt.Errorf("unable to init repo: %v", err)
+		return "", fmt.Errorf("unable to open main ledger file: %v", err)
 	}
-	err = l.setConfig()
+	defer f.Close()
+	content, err := io.ReadAll(f)
 	if err != nil {
-		return fmt.Errorf("unable to set config: %v", err)
+		return "", fmt.Errorf("unable to read main ledger file: %v", err)
 	}
-
-	err = l.addTransaction(transaction)
-	if err != nil {
-		return err
+	switch {
+	case len(content) == 0, bytes.HasSuffix(content, []byte("\n\n")):
+		return "", nil
+	case bytes.HasSuffix(content, []byte("\n")):
+		return "\n", nil
+	default:
+		return "\n\n", nil
 	}
+}
+
+func (l *Ledger) AddTransaction(transaction string, user User) error {
+	return l.commitTransaction(transaction, "", user)
+}
+
+// commitTransaction adds the transaction, the commit is marked with its id
+func (l *Ledger) commitTransaction(transaction, id string, user User) error {
+	return l.commitChange(user, func() (string, error) {
+		return repo.TransactionMessage(addMessage(transaction), id), l.addTransaction(transaction)
+	})
+}
+
+// transactionIDTag is the metadata tag with the id of the transaction
+// added by teledger: `    ; tid: 01j5x3k7q2m8d4fa`
+const transactionIDTag = "tid"
+
+// legacyTransactionIDPrefix marks the transactions added by the previous
+// versions of teledger, such transactions can still be deleted by id
+const legacyTransactionIDPrefix = ";; tid:"
 
-	err = l.repo.CommitPush("New comment", "teledger", "teledger@example.com")
+const transactionIDLen = 16
+
+var transactionIDEncoding = base32.NewEncoding("0123456789abcdefghjkmnpqrstvwxyz").WithPadding(base32.NoPadding)
+
+// NewTransactionID returns a random id of the transaction, 16 characters
+// of Crockford's base32, so it fits into telegram callback data
+func NewTransactionID() string {
+	b := make([]byte, transactionIDLen*5/8)
+	_, err := rand.Read(b)
 	if err != nil {
-		return fmt.Errorf("unable to commit: %v", err)
+		panic(err)
 	}
-	return nil
+	return transactionIDEncoding.EncodeToString(b)
 }
 
-const transactionIDPrefix = ";; tid:"
+// transactionIDLine matches the id metadata line of the transaction
+var transactionIDLine = regexp.MustCompile(`^\s+;\s*` + transactionIDTag + `:\s*(.+?)\s*$`)
 
-func (l *Ledger) AddTransactionWithID(transaction, id string) error {
-	return l.AddTransaction(fmt.Sprintf("%s%s\n%s", transactionIDPrefix, id, transaction))
+// withTransactionID adds the id as metadata right after the header
+// of the transaction
+func withTransactionID(transaction, id string) (string, error) {
+	lines := strings.Split(transaction, "\n")
+	for i, line := range lines {
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		meta := fmt.Sprintf("    ; %s: %s", transactionIDTag, id)
+		lines = slices.Insert(lines, i+1, meta)
+		return strings.Join(lines, "\n"), nil
+	}
+	return "", fmt.Errorf("invalid transaction: no header found")
 }
 
-func filterOutTransactionWithID(r io.Reader, id string) (content []byte, err error) {
-	scanner := bufio.NewScanner(r)
-	marker := transactionIDPrefix + id
-
-	afterMarker := 0
+func (l *Ledger) AddTransactionWithID(transaction, id string, user User) error {
+	tr, err := withTransactionID(transaction, id)
+	if err != nil {
+		return err
+	}
+	return l.commitTransaction(tr, id, user)
+}
 
-	for scanner.Scan() {
-		txt := scanner.Text()
+// indented reports whether the line belongs to the entry above it,
+// e.g. a posting or a note
+func indented(line string) bool {
+	return strings.TrimSpace(line) != "" && (line[0] == ' ' || line[0] == '\t')
+}
 
-		if afterMarker == 0 && txt == marker {
-			afterMarker++
-			if len(content) > 0 && content[len(content)-1] == '\n' {
-				content = content[:len(content)-1]
+// findTransactionWithID returns the first and the last line of the transaction
+// with the id. The transaction starts with the comment lines right above
+// its header, or with the legacy marker, and ends with its last indented line,
+// so the adjacent entries without an empty line between are kept intact.
+func findTransactionWithID(lines []string, id string) (start, end int, found bool) {
+	header := -1
+	for i, line := range lines {
+		if line == legacyTransactionIDPrefix+id {
+			start, header = i, i
+			for header+1 < len(lines) && strings.HasPrefix(lines[header], ";") {
+				header++
 			}
-			continue
+			break
 		}
-		if afterMarker == 1 && txt == "" {
-			afterMarker++
-			content = append(content, '\n')
-			continue
-		}
-		if afterMarker == 1 {
-			continue
+		if m := transactionIDLine.FindStringSubmatch(line); m != nil && m[1] == id {
+			header = i
+			for header >= 0 && indented(lines[header]) {
+				header--
+			}
+			if header < 0 || strings.TrimSpace(lines[header]) == "" {
+				return 0, 0, false
+			}
+			start = header
+			for start > 0 && strings.HasPrefix(lines[start-1], ";") {
+				start--
+			}
+			break
 		}
-		content = append(content, scanner.Bytes()...)
-		content = append(content, '\n')
 	}
-	if err := scanner.Err(); err != nil {
-		return content, fmt.Errorf("reading standard input: %v", err)
+	if header < 0 {
+		return 0, 0, false
 	}
-	if afterMarker == 0 {
-		return content, fmt.Errorf("no transaction with id '%s' was found", id)
+	end = header
+	for end+1 < len(lines) && indented(lines[end+1]) {
+		end++
 	}
-	return content, nil
+	return start, end, true
 }
 
-func (l *Ledger) DeleteTransactionWithID(id string) error {
-	err := l.repo.Init()
-	defer l.repo.Free()
-	if err != nil {
-		return fmt.Errorf("unable to init repo: %v", err)
+func filterOutTransactionWithID(r io.Reader, id string) (content []byte, err error) {
+	scanner := bufio.NewScanner(r)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
 	}
-	err = l.setConfig()
-	if err != nil {
-		return fmt.Errorf("unable to set config: %v", err)
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading standard input: %v", err)
 	}
 
+	start, end, found := findTransactionWithID(lines, id)
+	if !found {
+		return nil, fmt.Errorf("no transaction with id '%s' was found", id)
+	}
+	// remove the empty line separating the transaction as well
+	if end+1 < len(lines) && strings.TrimSpace(lines[end+1]) == "" {
+		end++
+	} else if start > 0 && strings.TrimSpace(lines[start-1]) == "" {
+		start--
+	}
+	lines = slices.Delete(lines, start, end+1)
+
+	for _, line := range lines {
+		content = append(content, line...)
+		content = append(content, '\n')
+	}
+	return content, nil
+}
+
+func (l *Ledger) DeleteTransactionWithID(id string, user User) error {
+	return l.commitChange(user, func() (string, error) {
+		return repo.TransactionMessage(fmt.Sprintf("Delete transaction %s", id), id), l.deleteTransactionWithID(id)
+	})
+}
+
+func (l *Ledger) deleteTransactionWithID(id string) error {
 	f, err := l.repo.OpenFile(l.Config.MainFile, os.O_RDWR, 0)
 	if err != nil {
 		return fmt.Errorf("unable to open main ledger file: %v", err)
@@ -310,12 +454,6 @@ func (l *Ledger) DeleteTransactionWithID(id string) error {
 	if err != nil {
 		return fmt.Errorf("unable to close main ledger file: %v", err)
 	}
-
-	err = l.repo.CommitPush("New comment", "teledger", "teledger@example.com")
-	if err != nil {
-		return fmt.Errorf("unable to commit: %v", err)
-	}
-
 	return nil
 }
 
@@ -342,53 +480,92 @@ func wrapIntoComment(s string) string {
 	return res
 }
 
-func (l *Ledger) AddComment(comment string) (string, error) {
-	err := l.repo.Init()
-	defer l.repo.Free()
-	if err != nil {
-		return "", fmt.Errorf("unable to init repo: %v", err)
-	}
-	err = l.setConfig()
+func (l *Ledger) AddComment(comment string, user User) (string, error) {
+	res := wrapIntoComment(comment)
+	err := l.commitChange(user, func() (string, error) {
+		return repo.TransactionMessage("Add comment", ""), l.addComment(res)
+	})
 	if err != nil {
-		return "", fmt.Errorf("unable to set config: %v", err)
+		return "", err
 	}
+	return res, nil
+}
 
+func (l *Ledger) addComment(res string) error {
 	r, err := l.repo.OpenForAppend(l.Config.MainFile)
 	if err != nil {
-		return "", fmt.Errorf("unable to open main ledger file: %v", err)
+		return fmt.Errorf("unable to open main ledger file: %v", err)
 	}
 
-	res := wrapIntoComment(comment)
-
 	if res == "" {
-		return "", fmt.Errorf("empty comment provided")
+		return fmt.Errorf("empty comment provided")
 	}
 
 	_, err = fmt.Fprintf(r, "\n%s\n", res)
 	if err != nil {
-		return "", fmt.Errorf("unable to write main ledger file: %v", err)
+		return fmt.Errorf("unable to write main ledger file: %v", err)
 	}
 
 	err = l.validate()
 	r.Close()
 	if err != nil {
-		return "", fmt.Errorf("ledger file become invalid after an attempt to add comment: %v", err)
+		return fmt.Errorf("ledger file become invalid after an attempt to add comment: %v", err)
 	}
+	return nil
+}
 
-	err = l.repo.CommitPush("New comment", "teledger", "teledger@example.com")
-	if err != nil {
-		return "", fmt.Errorf("unable to commit: %v", err)
+// State is the clearing state of a transaction or a posting
+type State string
+
+const (
+	StateCleared   State = "cleared"
+	StatePending   State = "pending"
+	StateUncleared State = "uncleared"
+)
+
+func (s State) journal() journal.State {
+	switch s {
+	case StateCleared:
+		return journal.Cleared
+	case StatePending:
+		return journal.Pending
 	}
-	return res, nil
+	return journal.Uncleared
+}
+
+// AccountKind tells whether the posting is virtual, it's real if empty
+type AccountKind string
+
+const (
+	// AccountVirtual postings, `(Budget:Food)`, don't have to balance
+	AccountVirtual AccountKind = "virtual"
+	// AccountBalancedVirtual postings, `[Budget:Food]`, balance among themselves
+	AccountBalancedVirtual AccountKind = "balanced_virtual"
+)
+
+func (k AccountKind) journal() journal.AccountKind {
+	switch k {
+	case AccountVirtual:
+		return journal.Virtual
+	case AccountBalancedVirtual:
+		return journal.BalancedVirtual
+	}
+	return journal.Real
 }
 
 // Transaction represents a single transaction in a ledger.
 type Transaction struct {
-	Date         string    `json:"date"`        // The date of the transaction
-	Description  string    `json:"description"` // A description of the transaction
-	Postings     []Posting `json:"postings"`    // A slice of postings that belong to this transaction
-	Comment      string
-	RealDateTime time.Time
+	Date         string            `json:"date"`               // The date of the transaction
+	AuxDate      string            `json:"aux_date,omitempty"` // The auxiliary date, e.g. the date the payment is settled
+	State        State             `json:"state,omitempty"`    // The clearing state, cleared if empty
+	Code         string            `json:"code,omitempty"`     // The code, e.g. the check number
+	Description  string            `json:"description"`        // A description of the transaction
+	Note         string            `json:"note,omitempty"`     // The note after the description
+	Tags         []string          `json:"tags,omitempty"`     // The tags, `; :tag:`
+	Metadata     map[string]string `json:"metadata,omitempty"` // The metadata, `; Key: value`
+	Postings     []Posting         `json:"postings"`           // A slice of postings that belong to this transaction
+	Comment      string            `json:"-"`
+	RealDateTime time.Time         `json:"-"`
 }
 
 func (t *Transaction) Format(withComment bool) string {
@@ -399,106 +576,192 @@ func (t *Transaction) Format(withComment bool) string {
 		)
 		res.WriteString("\n")
 	}
-	res.WriteString(fmt.Sprintf("%s * %s\n", t.RealDateTime.Format("2006-01-02"), t.Description))
-	for _, p := range t.Postings {
-		// format float to 2 decimal places
-		vf := humanize.FormatFloat("#,###.##", p.Amount)
-		res.WriteString(fmt.Sprintf("    %s  %s %s\n", p.Account, vf, p.Currency))
+	res.WriteString(t.entry().String())
+	return res.String()
+}
+
+// entry returns the transaction as the journal entry
+func (t *Transaction) entry() *journal.Transaction {
+	state := t.State
+	if state == "" {
+		state = StateCleared
+	}
+	e := &journal.Transaction{
+		Date:    t.RealDateTime.Format("2006-01-02"),
+		AuxDate: t.AuxDate,
+		State:   state.journal(),
+		Code:    t.Code,
+		Payee:   t.Description,
+	}
+	e.Note = t.Note
+	e.Comments = notes("", t.Tags, t.Metadata)
+	for i := range t.Postings {
+		e.Postings = append(e.Postings, t.Postings[i].entry())
+	}
+	return e
+}
 
+// withAmendedDate sets the real date of the amended transaction to the date
+// the generator has returned, the original date is kept if it's empty
+func (t *Transaction) withAmendedDate(orig time.Time) error {
+	if t.Date == "" {
+		if !orig.IsZero() {
+			t.RealDateTime = orig
+		}
+		return nil
 	}
-	return res.String()
+	d, err := time.Parse("2006-01-02", t.Date)
+	if err != nil {
+		return fmt.Errorf("invalid date '%s', expected YYYY-MM-DD", t.Date)
+	}
+	t.RealDateTime = d
+	return nil
+}
+
+// notes returns the comment lines with the auxiliary date, the tags and the metadata
+func notes(auxDate string, tags []string, metadata map[string]string) []string {
+	var res []string
+	if auxDate != "" {
+		res = append(res, "[="+auxDate+"]")
+	}
+	if len(tags) > 0 {
+		res = append(res, ":"+strings.Join(tags, ":")+":")
+	}
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		res = append(res, fmt.Sprintf("%s: %s", k, metadata[k]))
+	}
+	return res
 }
 
 func (t *Transaction) String() string {
 	return t.Format(false)
 }
 
+// withPrecisions adds the digits after the point up to the precision
+// of the commodities, the amounts are never rounded
+func (t *Transaction) withPrecisions(precisions map[string]int) {
+	for i := range t.Postings {
+		p := &t.Postings[i]
+		if prec, ok := precisions[p.Currency]; ok {
+			p.Amount = p.Amount.WithScale(prec)
+		}
+		for _, price := range []*Price{p.Cost, p.LotPrice} {
+			if price == nil {
+				continue
+			}
+			if prec, ok := precisions[price.Currency]; ok {
+				price.Amount = price.Amount.WithScale(prec)
+			}
+		}
+	}
+}
+
+// Clone returns a deep copy of the transaction
+func (t *Transaction) Clone() *Transaction {
+	res := *t
+	res.Tags = slices.Clone(t.Tags)
+	res.Metadata = maps.Clone(t.Metadata)
+	res.Postings = make([]Posting, len(t.Postings))
+	for i, p := range t.Postings {
+		p.Tags = slices.Clone(p.Tags)
+		p.Metadata = maps.Clone(p.Metadata)
+		if p.Cost != nil {
+			c := *p.Cost
+			p.Cost = &c
+		}
+		if p.LotPrice != nil {
+			lp := *p.LotPrice
+			p.LotPrice = &lp
+		}
+		res.Postings[i] = p
+	}
+	if t.Postings == nil {
+		res.Postings = nil
+	}
+	return &res
+}
+
 // Posting represents a single posting in a transaction, linking an account with an amount and currency.
 type Posting struct {
-	Account  string  `json:"account"`  // The name of the account
-	Amount   float64 `json:"amount"`   // The amount posted to the account
-	Currency string  `json:"currency"` // The currency of the amount
+	Account     string            `json:"account"`                // The name of the account
+	AccountKind AccountKind       `json:"account_kind,omitempty"` // Whether the posting is virtual
+	Amount      Decimal           `json:"amount"`                 // The exact amount posted to the account
+	Currency    string            `json:"currency"`               // The currency of the amount
+	Cost        *Price            `json:"cost,omitempty"`         // The price paid in another currency, `@` or `@@`
+	LotPrice    *Price            `json:"lot_price,omitempty"`    // The price the commodity was bought at, `{}` or `{{}}`
+	State       State             `json:"state,omitempty"`        // The clearing state, the one of the transaction if empty
+	AuxDate     string            `json:"aux_date,omitempty"`     // The auxiliary date of the posting
+	Note        string            `json:"note,omitempty"`         // The note after the amount
+	Tags        []string          `json:"tags,omitempty"`         // The tags, `; :tag:`
+	Metadata    map[string]string `json:"metadata,omitempty"`     // The metadata, `; Key: value`
 }
 
-// TransactionGenerator is an interface for generating transactions from user input
-// using LLM.
-//
-//go:generate moq -out  transaction_generator_mock.go -with-resets . TransactionGenerator
-type TransactionGenerator interface {
-	GenerateTransaction(promptCtx PromptCtx) (Transaction, error)
+// Price is the cost or the lot price of a posting
+type Price struct {
+	Amount   Decimal `json:"amount"`
+	Currency string  `json:"currency"`
+	// Total is the price of the whole amount, otherwise it's per unit
+	Total bool `json:"total,omitempty"`
 }
 
-type OpenAITransactionGenerator struct {
-	openai *openai.Client
+func (p *Price) amount() *journal.Amount {
+	return journal.NewAmount(p.Amount.Format(), p.Currency)
 }
 
-func NewOpenAITransactionGenerator(token string) *OpenAITransactionGenerator {
-	return &OpenAITransactionGenerator{
-		openai: openai.NewClient(token),
+// entry returns the posting as the journal posting
+func (p *Posting) entry() *journal.Posting {
+	e := &journal.Posting{
+		State:   p.State.journal(),
+		Account: p.Account,
+		Kind:    p.AccountKind.journal(),
+		Amount:  journal.NewAmount(p.Amount.Format(), p.Currency),
+	}
+	if p.Cost != nil {
+		e.Cost = &journal.Cost{Amount: *p.Cost.amount(), Total: p.Cost.Total}
 	}
+	if p.LotPrice != nil {
+		e.Lot = &journal.Lot{Price: p.LotPrice.amount(), TotalPrice: p.LotPrice.Total}
+	}
+	e.Note = p.Note
+	e.Comments = notes(p.AuxDate, p.Tags, p.Metadata)
+	return e
 }
 
-//nolint:gocritic
-func (b OpenAITransactionGenerator) GenerateTransaction(promptCtx PromptCtx) (Transaction, error) {
-	var buf bytes.Buffer
-	prTmp := template.Must(template.New("letter").Parse(defaultPromtpTemplate))
-	err := prTmp.Execute(&buf, promptCtx)
-	if err != nil {
-		return Transaction{}, fmt.Errorf("unable to execute template: %v", err)
+func parseCommodityOrAccount(ledger io.Reader, directive string) ([]string, error) {
+	if directive != "commodity" && directive != "account" {
+		panic("unsupported directive")
 	}
-
-	prompt := buf.String()
-
-	resp, err := b.openai.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model: "gpt-5-mini",
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleSystem,
-					Content: prompt,
-				},
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: promptCtx.UserInput,
-				},
-			},
-		},
-	)
+	content, err := io.ReadAll(ledger)
 	if err != nil {
-		fmt.Println("ChatCompletion error: ", err)
-		return Transaction{}, fmt.Errorf("chatCompletion error: %v", err)
+		return nil, fmt.Errorf("unable to read ledger file: %v", err)
 	}
-
-	res := Transaction{}
-	err = json.Unmarshal([]byte(resp.Choices[0].Message.Content), &res)
+	j, err := journal.Parse(bytes.NewReader(content), "")
 	if err != nil {
-		return Transaction{}, fmt.Errorf("unable to unmarshal response: %v", err)
+		// the syntax the parser doesn't support doesn't hide the directives
+		slog.Warn("unable to parse ledger file, directives are scanned line by line", "error", err)
+		return scanDirectives(content, directive), nil
+	}
+	var res []string
+	for _, d := range j.Directives(directive) {
+		res = append(res, d.Arg)
 	}
-
-	res.Comment = promptCtx.UserInput
-	res.RealDateTime = promptCtx.Datetime
-
 	return res, nil
 }
 
-func parseCommodityOrAccount(ledger io.Reader, directive string) ([]string, error) {
-	if directive != "commodity" && directive != "account" {
-		panic("unsupported directive")
-	}
-	scanner := bufio.NewScanner(ledger)
+// scanDirectives returns the arguments of the lines starting with the directive
+func scanDirectives(content []byte, directive string) []string {
 	var res []string
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, directive) {
-			v := strings.TrimSpace(strings.TrimPrefix(line, directive))
-			res = append(res, v)
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, directive+" ") || strings.HasPrefix(line, directive+"\t") {
+			res = append(res, strings.TrimSpace(strings.TrimPrefix(line, directive)))
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("unable to read ledger file: %v", err)
-	}
-	return res, nil
+	return res
 }
 
 func (l *Ledger) extractAccounts() ([]string, error) {
@@ -530,6 +793,22 @@ func (l *Ledger) extractAccounts() ([]string, error) {
 	return accsdedup, nil
 }
 
+func (l *Ledger) extractPrecisions() (map[string]int, error) {
+	r, err := resolveIncludesReader(l.repo, l.Config.MainFile)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	j, err := journal.Parse(r, l.Config.MainFile)
+	if err != nil {
+		// the amounts are written as they're generated
+		slog.Warn("unable to extract commodity precisions", "error", err)
+		return nil, nil
+	}
+	return commodityPrecisions(j), nil
+}
+
 func (l *Ledger) extractCommodities() ([]string, error) {
 	r, err := resolveIncludesReader(l.repo, l.Config.MainFile)
 	if err != nil {
@@ -559,34 +838,68 @@ func (l *Ledger) extractCommodities() ([]string, error) {
 	return dedup, nil
 }
 
-// Receive a short free-text description of a transaction
-// and returns a formatted transaction validated with the
-// ledger file.
-func (l *Ledger) proposeTransaction(userInput string) (Transaction, error) {
+// newPromptCtx collects the data from the ledger file
+// required to generate a transaction from the userInput
+func (l *Ledger) newPromptCtx(userInput, userName string, tmpl *template.Template) (PromptCtx, error) {
 	accounts, err := l.extractAccounts()
 	if err != nil {
-		return Transaction{}, err
+		return PromptCtx{}, err
 	}
 
 	commodities, err := l.extractCommodities()
 	if err != nil {
-		return Transaction{}, err
+		return PromptCtx{}, err
 	}
 
-	promptCtx := PromptCtx{
-		Accounts:    accounts,
-		Commodities: commodities,
-		UserInput:   userInput,
-		Datetime:    time.Now(),
+	precisions, err := l.extractPrecisions()
+	if err != nil {
+		return PromptCtx{}, err
 	}
 
+	recent, err := l.extractRecentTransactions(recentTransactionsCount)
+	if err != nil {
+		return PromptCtx{}, err
+	}
+
+	return PromptCtx{
+		Accounts:           accounts,
+		Commodities:        commodities,
+		Precisions:         precisions,
+		UserInput:          userInput,
+		UserName:           userName,
+		Datetime:           time.Now(),
+		RecentTransactions: recent,
+		Config:             *l.Config,
+		Template:           tmpl,
+	}, nil
+}
+
+// Receive a short free-text description of a transaction
+// and returns a formatted transaction validated with the
+// ledger file.
+//
+//nolint:gocritic
+func (l *Ledger) proposeTransaction(promptCtx PromptCtx) (Transaction, error) {
 	trx, err := l.generator.GenerateTransaction(promptCtx)
 	if err != nil {
 		return trx, fmt.Errorf("unable to generate transaction: %v", err)
 	}
+	trx.withPrecisions(promptCtx.Precisions)
 
-	// try to add for validation
-	err = l.addTransaction(trx.Format(false))
+	err = validateTransaction(&trx, promptCtx.Accounts, promptCtx.Commodities)
+	if err != nil {
+		return trx, fmt.Errorf("transaction doesn't match the schema: %v", err)
+	}
+	if promptCtx.Amendment != nil {
+		// the amended transaction may be moved to another date,
+		// the date of the original message is kept otherwise
+		err = trx.withAmendedDate(promptCtx.Datetime)
+		if err != nil {
+			return trx, fmt.Errorf("transaction doesn't match the schema: %v", err)
+		}
+	}
+
+	err = l.checkTransaction(trx.Format(false))
 	if err != nil {
 		return trx, fmt.Errorf("unable to validate transaction: %v", err)
 	}
@@ -603,14 +916,15 @@ func parseConfig(r io.Reader, c *Config) error {
 	return nil
 }
 
+// setConfig reads the config from the repo, it should be called
+// right after the repo Init. The config is replaced as a whole,
+// so the readers holding the previous one are not affected.
 func (l *Ledger) setConfig() error {
-	if l.Config == nil {
-		l.Config = &Config{}
-	}
+	cfg := &Config{}
 	const configFile = "teledger.yaml"
 	r, err := l.repo.Open(configFile)
 	if err == nil {
-		err = parseConfig(r, l.Config)
+		err = parseConfig(r, cfg)
 		if err != nil {
 			return err
 		}
@@ -619,21 +933,40 @@ func (l *Ledger) setConfig() error {
 		return err
 	}
 	// set defaults:
-	if l.Config.MainFile == "" {
-		l.Config.MainFile = "main.ledger"
+	if cfg.MainFile == "" {
+		cfg.MainFile = "main.ledger"
 	}
 
-	if l.Config.PromptTemplate == "" {
-		l.Config.PromptTemplate = defaultPromtpTemplate
+	if cfg.PromptTemplate == "" {
+		cfg.PromptTemplate = defaultPromtpTemplate
 	}
 
-	if l.Config.Version == "" {
-		l.Config.Version = "0"
+	if cfg.Version == "" {
+		cfg.Version = "0"
 	}
 
+	l.Config = cfg
+	l.publishConfig(cfg)
+
 	return nil
 }
 
+// publishConfig makes the config available with CurrentConfig
+func (l *Ledger) publishConfig(cfg *Config) {
+	l.configMu.Lock()
+	l.current = cfg
+	l.configMu.Unlock()
+}
+
+// CurrentConfig returns the config read during the last operation,
+// it's safe to call concurrently with other operations.
+// Returns nil if no operations have been done yet.
+func (l *Ledger) CurrentConfig() *Config {
+	l.configMu.RLock()
+	defer l.configMu.RUnlock()
+	return l.current
+}
+
 type ProposeTransactionRespones struct {
 	// If the user provided a valid transaction as
 	// a description, it will be stored here
@@ -645,35 +978,39 @@ type ProposeTransactionRespones struct {
 	Error error
 	// Attempt from which the transaction was generated
 	AttemptNumber int
-	Committed     bool
+	// All generation attempts, the last one is the GeneratedTransaction
+	Attempts  []Attempt
+	Committed bool
 }
 
-func (l *Ledger) AddOrProposeTransaction(userInput string, attempts int) ProposeTransactionRespones {
-	resp := ProposeTransactionRespones{}
-
-	err := l.repo.Init()
-	defer l.repo.Free()
-	if err != nil {
-		resp.Error = err
-		return resp
+// RejectedAttempts returns the attempts preceding the last one,
+// all of them were rejected
+func (r *ProposeTransactionRespones) RejectedAttempts() []Attempt {
+	if len(r.Attempts) < 2 {
+		return nil
 	}
+	return r.Attempts[:len(r.Attempts)-1]
+}
 
-	err = l.setConfig()
-	if err != nil {
-		resp.Error = err
-		return resp
-	}
+// Attempt is a single transaction generation attempt
+type Attempt struct {
+	Number int
+	// Nil if the generator failed to produce a transaction
+	Transaction *Transaction
+	Error       error
+}
+
+// AddOrProposeTransaction commits userInput as is if it's already a valid
+// transaction, otherwise asks the generator to propose one.
+// The name of the user who sent the input is available in the prompt template.
+func (l *Ledger) AddOrProposeTransaction(userInput string, user User, attempts int) ProposeTransactionRespones {
+	resp := ProposeTransactionRespones{}
 
 	// first try to add userInput as transaction
-	err = l.addTransaction(userInput)
+	// if user input was a valid transaction, it's committed
+	err := l.AddTransaction(userInput, user)
 	if err == nil {
-		// if user input was a valid transaction, commit it
-		err = l.repo.CommitPush("New comment", "teledger", "teledger@example.com")
 		resp.UserProvidedTransaction = userInput
-		if err != nil {
-			resp.Error = err
-			return resp
-		}
 		resp.Committed = true
 		return resp
 	}
@@ -690,28 +1027,287 @@ func (l *Ledger) AddOrProposeTransaction(userInput string, attempts int) Propose
 		panic("times should be greater than 0")
 	}
 
-	var addErr error
-	var tr Transaction
+	// the transaction is proposed from the snapshot, so the repo
+	// isn't locked while the generator is working
+	ro, err := l.readOnly()
+	if err != nil {
+		resp.Error = err
+		return resp
+	}
+
+	tmpl, err := ro.promptTemplate()
+	if err != nil {
+		resp.Error = err
+		return resp
+	}
+
+	promptCtx, err := ro.newPromptCtx(userInput, user.Name, tmpl)
+	if err != nil {
+		resp.Error = err
+		return resp
+	}
+
+	ro.proposeWithRetries(&resp, promptCtx, attempts)
+	return resp
+}
 
+// proposeWithRetries asks the generator for a transaction until it's valid
+// or the attempts are exhausted, the result is stored in the resp
+//
+//nolint:gocritic
+func (l *Ledger) proposeWithRetries(resp *ProposeTransactionRespones, promptCtx PromptCtx, attempts int) {
 	for i := 1; i <= attempts; i++ {
 		if i > 1 {
-			slog.Warn("retrying transaction generation", "attempt", i)
+			slog.Warn("retrying transaction generation", "attempt", i, "error", resp.Error)
 		}
-		tr, addErr = l.proposeTransaction(userInput)
+		tr, addErr := l.proposeTransaction(promptCtx)
 		resp.Error = addErr
 		resp.GeneratedTransaction = &tr
 		resp.AttemptNumber = i
+
+		attempt := Attempt{Number: i, Error: addErr}
+		if tr.Postings != nil {
+			attempt.Transaction = &tr
+		}
+		resp.Attempts = append(resp.Attempts, attempt)
+		// each retry continues the conversation with the previous rejected attempts
+		promptCtx.History = resp.Attempts
+
 		if addErr == nil {
-			return resp
+			return
 		}
 	}
+}
+
+// AmendTransaction asks the generator to correct the proposed transaction
+// according to the user's correction. The prompt is built the same way as
+// for the original proposal, followed by the transaction and the correction.
+// The transaction is validated but not committed.
+func (l *Ledger) AmendTransaction(tr Transaction, correction string, user User, attempts int) ProposeTransactionRespones {
+	resp := ProposeTransactionRespones{}
+	if attempts <= 0 {
+		panic("times should be greater than 0")
+	}
+
+	ro, err := l.readOnly()
+	if err != nil {
+		resp.Error = err
+		return resp
+	}
+
+	tmpl, err := ro.promptTemplate()
+	if err != nil {
+		resp.Error = err
+		return resp
+	}
 
+	promptCtx, err := ro.newPromptCtx(tr.Comment, user.Name, tmpl)
+	if err != nil {
+		resp.Error = err
+		return resp
+	}
+	// relative dates are resolved against the original message
+	if !tr.RealDateTime.IsZero() {
+		promptCtx.Datetime = tr.RealDateTime
+	}
+	// the generator sees the date the transaction is written with
+	if !tr.RealDateTime.IsZero() {
+		tr.Date = tr.RealDateTime.Format("2006-01-02")
+	}
+	trJSON, err := json.Marshal(tr)
+	if err != nil {
+		resp.Error = fmt.Errorf("unable to encode transaction: %v", err)
+		return resp
+	}
+	promptCtx.Amendment = &Amendment{
+		Transaction: string(trJSON),
+		Correction:  correction,
+	}
+
+	ro.proposeWithRetries(&resp, promptCtx, attempts)
+	if resp.Error == nil {
+		resp.GeneratedTransaction.Comment = tr.Comment + "\n" + correction
+	}
 	return resp
 }
 
+// PromptCtx is the data available in the prompt template
 type PromptCtx struct {
 	Accounts    []string
 	Commodities []string
-	UserInput   string
-	Datetime    time.Time
+	// Digits after the point of the commodities
+	Precisions map[string]int
+	UserInput  string
+	// Name of the telegram user who sent the input, may be empty
+	UserName string
+	Datetime time.Time
+	// Last transactions from the ledger file, oldest first
+	RecentTransactions []string
+	// Config of the ledger repository (teledger.yaml)
+	Config Config
+	// Parsed prompt template, the default one is used if nil
+	Template *template.Template
+	// Previous rejected attempts, they are sent back to the LLM
+	// so it's able to fix the mistakes
+	History []Attempt
+	// Correction of a previously generated transaction, nil for new transactions
+	Amendment *Amendment
+}
+
+// Amendment is the user's correction of a transaction
+type Amendment struct {
+	// The transaction being corrected, as JSON or in the ledger format
+	Transaction string
+	Correction  string
+}
+
+type chatMessage struct {
+	Role    string
+	Content string
+}
+
+const (
+	chatRoleUser      = "user"
+	chatRoleAssistant = "assistant"
+)
+
+// conversation returns the messages following the system prompt:
+// the user input followed by the amendment if any,
+// and the rejected attempts with their errors.
+// Consecutive messages of the same role are merged, as some APIs
+// require roles to alternate.
+func (p PromptCtx) conversation() []chatMessage {
+	msgs := []chatMessage{{Role: chatRoleUser, Content: p.UserInput}}
+
+	add := func(role, content string) {
+		last := &msgs[len(msgs)-1]
+		if last.Role == role {
+			last.Content += "\n\n" + content
+			return
+		}
+		msgs = append(msgs, chatMessage{Role: role, Content: content})
+	}
+
+	if am := p.Amendment; am != nil {
+		add(chatRoleAssistant, am.Transaction)
+		add(chatRoleUser, fmt.Sprintf(
+			"The user corrects the transaction:\n%s\nRespond with the whole corrected transaction as JSON only.",
+			am.Correction,
+		))
+	}
+
+	for _, a := range p.History {
+		if a.Error == nil {
+			continue
+		}
+		if a.Transaction != nil {
+			trJSON, err := json.Marshal(a.Transaction)
+			if err == nil {
+				add(chatRoleAssistant, string(trJSON))
+			}
+		}
+		add(chatRoleUser, fmt.Sprintf(
+			"The transaction was rejected with the error:\n%v\nFix the transaction and respond with JSON only.",
+			a.Error,
+		))
+	}
+	return msgs
+}
+
+// RenderPrompt executes the prompt template with the context
+func (p PromptCtx) RenderPrompt() (string, error) {
+	tmpl := p.Template
+	if tmpl == nil {
+		var err error
+		tmpl, err = parsePromptTemplate(defaultPromtpTemplate)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, p)
+	if err != nil {
+		return "", fmt.Errorf("unable to execute prompt template: %v", err)
+	}
+	return buf.String(), nil
+}
+
+func parsePromptTemplate(s string) (*template.Template, error) {
+	tmpl, err := template.New("prompt").Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse prompt template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// promptTemplate returns the prompt template configured in teledger.yaml.
+// The template is taken from the promptTemplateFile if it is set,
+// from the inline promptTemplate otherwise.
+func (l *Ledger) promptTemplate() (*template.Template, error) {
+	if l.Config.PromptTemplateFile == "" {
+		return parsePromptTemplate(l.Config.PromptTemplate)
+	}
+
+	f, err := l.repo.Open(l.Config.PromptTemplateFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open prompt template file: %v", err)
+	}
+	defer f.Close()
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read prompt template file: %v", err)
+	}
+	return parsePromptTemplate(string(content))
+}
+
+const recentTransactionsCount = 5
+
+// parseRecentTransactions returns the last n transactions from the ledger file.
+// A transaction starts with a date at the beginning of a line and
+// continues while the lines are indented.
+func parseRecentTransactions(r io.Reader, n int) ([]string, error) {
+	var res []string
+	var cur []string
+
+	flush := func() {
+		if len(cur) > 0 {
+			res = append(res, strings.Join(cur, "\n"))
+			cur = nil
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line != "" && line[0] >= '0' && line[0] <= '9':
+			flush()
+			cur = append(cur, line)
+		case len(cur) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")):
+			cur = append(cur, line)
+		default:
+			flush()
+		}
+	}
+	flush()
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read ledger file: %v", err)
+	}
+
+	if len(res) > n {
+		res = res[len(res)-n:]
+	}
+	return res, nil
+}
+
+func (l *Ledger) extractRecentTransactions(n int) ([]string, error) {
+	r, err := resolveIncludesReader(l.repo, l.Config.MainFile)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return parseRecentTransactions(r, n)
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/mput/teledger/app/repo"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLedger_Execute(t *testing.T) {
@@ -83,6 +84,23 @@ commodity EUR
 	})
 }
 
+func TestLedger_ExecuteWhileLocked(t *testing.T) {
+	r := &repo.Mock{Files: map[string]string{
+		"main.ledger":   "account Assets:Cash\naccount Equity\n\n2024-02-13 * Test\n  Assets:Cash  100.00 EUR\n  Equity\n",
+		"teledger.yaml": "strict: true\n",
+	}}
+	l := NewLedger(r, nil)
+
+	// the reports are made from the snapshot, they don't wait for the changes
+	require.NoError(t, r.Init())
+	defer r.Free()
+	res, err := l.Execute("accounts")
+	require.NoError(t, err)
+	assert.Contains(t, res, "Assets:Cash")
+	assert.True(t, l.CurrentConfig().StrictMode)
+	assert.Nil(t, l.Config, "the config of the changes is not touched")
+}
+
 func TestLedger_AddTransaction(t *testing.T) {
 	t.Run("success path", func(t *testing.T) {
 		t.Parallel()
@@ -99,7 +117,7 @@ func TestLedger_AddTransaction(t *testing.T) {
 2024-02-14 * Test
   Assets:Cash  42.00 EUR
   Equity
-`)
+`, User{})
 		if err != nil {
 			t.Fatalf("Error: %v", err)
 		}
@@ -121,26 +139,26 @@ func TestLedger_AddTransaction(t *testing.T) {
 
 		err = ledger.AddTransaction(`
 dummy
-`)
+`, User{})
 		if err == nil {
 			t.Fatalf("Expected error")
 		}
 
 		err = ledger.AddTransaction(`
 dummy dummy
-`)
+`, User{})
 		if err == nil {
 			t.Fatalf("Expected error")
 		}
 
-		err = ledger.AddTransaction(``)
+		err = ledger.AddTransaction(``, User{})
 		if err == nil {
 			t.Fatalf("Expected error")
 		}
 
 		err = ledger.AddTransaction(`
 
-`)
+`, User{})
 		if err == nil {
 			t.Fatalf("Expected error")
 		}
@@ -166,12 +184,12 @@ func TestLedger_ProposeTransaction(t *testing.T) {
 					Postings: []Posting{
 						{
 							Account:  "cash",
-							Amount:   -3000.43,
+							Amount:   NewDecimal(-300043, 2),
 							Currency: "EUR",
 						},
 						{
 							Account:  "taxi",
-							Amount:   3000.43,
+							Amount:   NewDecimal(300043, 2),
 							Currency: "EUR",
 						},
 					},
@@ -184,12 +202,12 @@ func TestLedger_ProposeTransaction(t *testing.T) {
 					Postings: []Posting{
 						{
 							Account:  "Assets:Cash",
-							Amount:   -3000.43,
+							Amount:   NewDecimal(-300043, 2),
 							Currency: "EUR",
 						},
 						{
 							Account:  "Food",
-							Amount:   3000.43,
+							Amount:   NewDecimal(300043, 2),
 							Currency: "EUR",
 						},
 					},
@@ -225,7 +243,7 @@ strict: true
 	)
 
 	t.Run("happy path", func(t *testing.T) {
-		resp := ledger.AddOrProposeTransaction("20 Taco Bell", 5)
+		resp := ledger.AddOrProposeTransaction("20 Taco Bell", User{Name: "john"}, 5)
 
 		assert.True(t, ledger.Config.StrictMode)
 
@@ -256,6 +274,32 @@ strict: true
 			mockedTransactionGenerator.calls.GenerateTransaction[0].PromptCtx.UserInput,
 		)
 
+		assert.Equal(
+			t,
+			"john",
+			mockedTransactionGenerator.calls.GenerateTransaction[0].PromptCtx.UserName,
+		)
+
+		assert.Equal(
+			t,
+			[]string{"2024-02-13 * Test\n  Assets:Cash  100.00 EUR\n  Equity"},
+			mockedTransactionGenerator.calls.GenerateTransaction[0].PromptCtx.RecentTransactions,
+		)
+
+		assert.True(t, mockedTransactionGenerator.calls.GenerateTransaction[0].PromptCtx.Config.StrictMode)
+
+		assert.Empty(t, mockedTransactionGenerator.calls.GenerateTransaction[0].PromptCtx.History)
+
+		history := mockedTransactionGenerator.calls.GenerateTransaction[1].PromptCtx.History
+		if assert.Len(t, history, 1) {
+			assert.Equal(t, 1, history[0].Number)
+			assert.Equal(t, "My tr", history[0].Transaction.Description)
+			assert.ErrorContains(t, history[0].Error, "account 'cash' is not in the list of accounts")
+		}
+
+		assert.Len(t, resp.Attempts, 2)
+		assert.Equal(t, resp.Attempts[:1], resp.RejectedAttempts())
+
 		assert.False(t, resp.Committed)
 
 		assert.Equal(t,
@@ -276,7 +320,7 @@ strict: true
 2014-11-12 * Tacos
     Assets:Cash  -2.43 EUR
     Food  2.43 EUR
-`, 1)
+`, User{}, 1)
 
 		assert.Nil(t, resp.GeneratedTransaction)
 		assert.True(t, resp.Committed)
@@ -288,27 +332,486 @@ strict: true
 	t.Run("validation error path", func(t *testing.T) {
 		mockedTransactionGenerator.ResetCalls()
 
-		resp := ledger.AddOrProposeTransaction("20 Taco Bell", 1)
-		assert.ErrorContains(t, resp.Error, "Unknown account 'cash'")
+		resp := ledger.AddOrProposeTransaction("20 Taco Bell", User{}, 1)
+		assert.ErrorContains(t, resp.Error, "transaction doesn't match the schema: account 'cash' is not in the list of accounts")
 
 		assert.Equal(t, len(mockedTransactionGenerator.calls.GenerateTransaction), 1)
 	})
 }
 
+func TestLedger_PromptTemplate(t *testing.T) {
+	promptCtx := PromptCtx{
+		Accounts:    []string{"Assets:Cash", "Food"},
+		Commodities: []string{"EUR"},
+		UserInput:   "20 Taco Bell",
+		UserName:    "john",
+	}
+
+	newLedger := func(files map[string]string) *Ledger {
+		l := NewLedger(&repo.Mock{Files: files}, nil)
+		err := l.repo.Init()
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		t.Cleanup(l.repo.Free)
+		err = l.setConfig()
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		return l
+	}
+
+	t.Run("default template", func(t *testing.T) {
+		l := newLedger(map[string]string{"main.ledger": ""})
+
+		tmpl, err := l.promptTemplate()
+		assert.NoError(t, err)
+
+		promptCtx.Template = tmpl
+		prompt, err := promptCtx.RenderPrompt()
+		assert.NoError(t, err)
+		assert.Contains(t, prompt, "Use Assets:Cash as default assets account")
+	})
+
+	t.Run("inline template", func(t *testing.T) {
+		l := newLedger(map[string]string{
+			"main.ledger": "",
+			"teledger.yaml": `
+promptTemplate: "{{ .UserName }} spent in {{ index .Commodities 0 }}, strict: {{ .Config.StrictMode }}"
+`,
+		})
+
+		tmpl, err := l.promptTemplate()
+		assert.NoError(t, err)
+
+		promptCtx.Template = tmpl
+		promptCtx.Config = *l.Config
+		prompt, err := promptCtx.RenderPrompt()
+		assert.NoError(t, err)
+		assert.Equal(t, "john spent in EUR, strict: false", prompt)
+	})
+
+	t.Run("template from file", func(t *testing.T) {
+		l := newLedger(map[string]string{
+			"main.ledger":   "",
+			"prompt.txt":    "Accounts: {{ range .Accounts }}{{ . }};{{ end }}",
+			"teledger.yaml": "promptTemplateFile: prompt.txt\n",
+		})
+
+		tmpl, err := l.promptTemplate()
+		assert.NoError(t, err)
+
+		promptCtx.Template = tmpl
+		prompt, err := promptCtx.RenderPrompt()
+		assert.NoError(t, err)
+		assert.Equal(t, "Accounts: Assets:Cash;Food;", prompt)
+	})
+
+	t.Run("missing template file", func(t *testing.T) {
+		l := newLedger(map[string]string{
+			"main.ledger":   "",
+			"teledger.yaml": "promptTemplateFile: prompt.txt\n",
+		})
+
+		_, err := l.promptTemplate()
+		assert.ErrorContains(t, err, "unable to open prompt template file")
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		l := newLedger(map[string]string{
+			"main.ledger":   "",
+			"teledger.yaml": "promptTemplate: \"{{ .UserName \"\n",
+		})
+
+		_, err := l.promptTemplate()
+		assert.ErrorContains(t, err, "unable to parse prompt template")
+	})
+}
+
+func TestParseCommodityOrAccount(t *testing.T) {
+	const directives = `account Assets:Cash
+account Expenses:Food ; groceries
+commodity EUR
+    format 1,000.00 EUR
+`
+	// the cost expressions aren't supported by the parser
+	const unsupported = directives + `
+2024-02-14 * Exchange
+    Assets:Cash  10 EUR @ (1/3) USD
+    Assets:Cash
+`
+
+	tbl := []struct {
+		name string
+		file string
+	}{
+		{name: "parsed", file: directives},
+		{name: "automated and periodic transactions", file: `= expr account =~ /Food/
+    (Budget:Food)  -1
+~ Monthly
+    Expenses:Rent  500 EUR
+    Assets:Cash
+apply account Personal
+` + directives + `end apply account
+include other.ledger
+`},
+		{name: "unsupported syntax", file: unsupported},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			accs, err := parseCommodityOrAccount(strings.NewReader(tt.file), "account")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"Assets:Cash", "Expenses:Food ; groceries"}, accs)
+
+			coms, err := parseCommodityOrAccount(strings.NewReader(tt.file), "commodity")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"EUR"}, coms)
+		})
+	}
+
+	t.Run("prompt context", func(t *testing.T) {
+		l := NewLedger(&repo.Mock{Files: map[string]string{"main.ledger": unsupported}}, nil)
+		ro, err := l.readOnly()
+		require.NoError(t, err)
+		tmpl, err := ro.promptTemplate()
+		require.NoError(t, err)
+		promptCtx, err := ro.newPromptCtx("10 EUR for food", "", tmpl)
+		require.NoError(t, err)
+		assert.Contains(t, promptCtx.Accounts, "Assets:Cash")
+		assert.Equal(t, []string{"EUR"}, promptCtx.Commodities)
+		assert.Empty(t, promptCtx.Precisions)
+	})
+}
+
+func TestParseRecentTransactions(t *testing.T) {
+	const ledgerFile = `
+account Food
+commodity EUR
+
+2024-02-13 * First
+  Assets:Cash  100.00 EUR
+  Equity
+
+;; a comment
+2024-02-14 * Second
+    ; posting note
+    Assets:Cash  -10.00 EUR
+    Food
+2024-02-15 Third
+	Assets:Cash  -5.00 EUR
+	Food
+`
+
+	res, err := parseRecentTransactions(strings.NewReader(ledgerFile), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"2024-02-14 * Second\n    ; posting note\n    Assets:Cash  -10.00 EUR\n    Food",
+		"2024-02-15 Third\n\tAssets:Cash  -5.00 EUR\n\tFood",
+	}, res)
+
+	res, err = parseRecentTransactions(strings.NewReader(ledgerFile), 5)
+	assert.NoError(t, err)
+	assert.Len(t, res, 3)
+}
+
+func TestTransaction_Format(t *testing.T) {
+	tr := Transaction{
+		RealDateTime: time.Date(2024, 2, 14, 0, 0, 0, 0, time.UTC),
+		Description:  "Taco Bell",
+		Postings: []Posting{
+			{Account: "Liabilities:Card", Amount: NewDecimal(-20, 0), Currency: "EUR"},
+			{Account: "Expenses:Food", Amount: NewDecimal(20, 0), Currency: "EUR"},
+		},
+	}
+	assert.Equal(t, "2024-02-14 * Taco Bell\n    Liabilities:Card  -20 EUR\n    Expenses:Food  20 EUR\n", tr.String())
+
+	tr.State = StatePending
+	tr.AuxDate = "2024-02-16"
+	tr.Code = "INV-42"
+	tr.Note = "lunch with Bob"
+	tr.Tags = []string{"food", "work"}
+	tr.Metadata = map[string]string{"Place": "Berlin", "Card": "visa"}
+	tr.Postings[0].State = StateCleared
+	tr.Postings[0].Metadata = map[string]string{"Statement": "2024-02"}
+	tr.Postings[1].Note = "tacos"
+	tr.Postings[1].AuxDate = "2024-02-15"
+	tr.Postings[1].Tags = []string{"tacos"}
+	tr.Postings = append(tr.Postings,
+		Posting{Account: "Budget:Food", AccountKind: AccountVirtual, Amount: NewDecimal(-20, 0), Currency: "EUR"},
+		Posting{Account: "Budget:Savings", AccountKind: AccountBalancedVirtual, Amount: NewDecimal(5, 0), Currency: "EUR"},
+		Posting{Account: "Budget:Free", AccountKind: AccountBalancedVirtual, Amount: NewDecimal(-5, 0), Currency: "EUR"},
+	)
+	assert.Equal(t, `2024-02-14=2024-02-16 ! (INV-42) Taco Bell  ; lunch with Bob
+    ; :food:work:
+    ; Card: visa
+    ; Place: Berlin
+    * Liabilities:Card  -20 EUR
+      ; Statement: 2024-02
+    Expenses:Food  20 EUR  ; tacos
+      ; [=2024-02-15]
+      ; :tacos:
+    (Budget:Food)  -20 EUR
+    [Budget:Savings]  5 EUR
+    [Budget:Free]  -5 EUR
+`, tr.String())
+
+	tr.State = StateUncleared
+	tr.AuxDate, tr.Code, tr.Note, tr.Tags, tr.Metadata = "", "", "", nil, nil
+	tr.Postings = tr.Postings[2:3]
+	assert.Equal(t, "2024-02-14 Taco Bell\n    (Budget:Food)  -20 EUR\n", tr.String())
+}
+
+func TestTransaction_FormatPrices(t *testing.T) {
+	tr := Transaction{
+		RealDateTime: time.Date(2024, 2, 14, 0, 0, 0, 0, time.UTC),
+		Description:  "Diner in New York",
+		Postings: []Posting{
+			{Account: "Expenses:Food", Amount: NewDecimal(5000, 2), Currency: "USD", Cost: &Price{Amount: NewDecimal(4620, 2), Currency: "EUR", Total: true}},
+			{Account: "Liabilities:Card", Amount: NewDecimal(-4620, 2), Currency: "EUR"},
+		},
+	}
+	assert.Equal(t, `2024-02-14 * Diner in New York
+    Expenses:Food  50.00 USD @@ 46.20 EUR
+    Liabilities:Card  -46.20 EUR
+`, tr.String())
+
+	tr = Transaction{
+		RealDateTime: time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC),
+		Description:  "Sell shares",
+		Postings: []Posting{
+			{
+				Account: "Assets:Broker", Amount: NewDecimal(-10, 0), Currency: "AAPL",
+				LotPrice: &Price{Amount: NewDecimal(15000, 2), Currency: "USD"},
+				Cost:     &Price{Amount: NewDecimal(1600, 1), Currency: "USD"},
+			},
+			{Account: "Assets:Cash", Amount: NewDecimal(1600, 0), Currency: "USD"},
+			{Account: "Income:Gains", Amount: NewDecimal(-100, 0), Currency: "USD"},
+		},
+	}
+	tr.withPrecisions(map[string]int{"USD": 2})
+	assert.Equal(t, `2024-02-15 * Sell shares
+    Assets:Broker  -10 AAPL {150.00 USD} @ 160.00 USD
+    Assets:Cash  1,600.00 USD
+    Income:Gains  -100.00 USD
+`, tr.String())
+
+	tr.Postings[0].LotPrice.Total = true
+	assert.Contains(t, tr.String(), "    Assets:Broker  -10 AAPL {{150.00 USD}} @ 160.00 USD\n")
+}
+
+func TestTransaction_Clone(t *testing.T) {
+	tr := &Transaction{
+		Description: "Taco Bell",
+		Tags:        []string{"food"},
+		Metadata:    map[string]string{"Place": "Berlin"},
+		Postings: []Posting{{
+			Account:  "Expenses:Food",
+			Tags:     []string{"tacos"},
+			Metadata: map[string]string{"Kind": "lunch"},
+			Cost:     &Price{Amount: NewDecimal(1, 0), Currency: "USD"},
+			LotPrice: &Price{Amount: NewDecimal(2, 0), Currency: "USD"},
+		}},
+	}
+	c := tr.Clone()
+	assert.Equal(t, tr, c)
+
+	c.Tags[0] = "changed"
+	c.Metadata["Place"] = "changed"
+	c.Postings[0].Account = "changed"
+	c.Postings[0].Tags[0] = "changed"
+	c.Postings[0].Metadata["Kind"] = "changed"
+	c.Postings[0].Cost.Currency = "changed"
+	c.Postings[0].LotPrice.Currency = "changed"
+	assert.Equal(t, []string{"food"}, tr.Tags)
+	assert.Equal(t, "Berlin", tr.Metadata["Place"])
+	assert.Equal(t, "Expenses:Food", tr.Postings[0].Account)
+	assert.Equal(t, []string{"tacos"}, tr.Postings[0].Tags)
+	assert.Equal(t, "lunch", tr.Postings[0].Metadata["Kind"])
+	assert.Equal(t, "USD", tr.Postings[0].Cost.Currency)
+	assert.Equal(t, "USD", tr.Postings[0].LotPrice.Currency)
+}
+
+func TestNewTransactionID(t *testing.T) {
+	ids := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := NewTransactionID()
+		assert.Regexp(t, `^[0-9a-z]{16}$`, id)
+		assert.False(t, ids[id], "duplicate id %s", id)
+		ids[id] = true
+	}
+}
+
+func TestWithTransactionID(t *testing.T) {
+	res, err := withTransactionID(";; 10 taxi\n2024-02-14 * Taxi\n    Assets:Cash  -10.00 EUR\n    Expenses:Taxi  10.00 EUR\n", "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, ";; 10 taxi\n2024-02-14 * Taxi\n    ; tid: abc\n    Assets:Cash  -10.00 EUR\n    Expenses:Taxi  10.00 EUR\n", res)
+
+	_, err = withTransactionID(";; only comment\n", "abc")
+	assert.ErrorContains(t, err, "no header found")
+}
+
+func TestFilterOutTransactionWithID(t *testing.T) {
+	const ledgerFile = `commodity EUR
+
+;; tid:2014-11-30 11:45:26.111 Sun
+;; legacy
+2014-11-30 * Legacy
+    Assets:Cash  -10.00 EUR
+    Food  10.00 EUR
+
+;; new
+2014-11-30 * New
+    ; tid: 0123456789abcdef
+    Assets:Cash  -10.00 EUR
+    Food  10.00 EUR
+
+2014-12-01 * Last
+    ; tid: fedcba9876543210
+    Assets:Cash  -10.00 EUR
+    Food  10.00 EUR
+`
+
+	cases := []struct {
+		id  string
+		exp string
+	}{
+		{
+			id: "2014-11-30 11:45:26.111 Sun",
+			exp: `commodity EUR
+
+;; new
+2014-11-30 * New
+    ; tid: 0123456789abcdef
+    Assets:Cash  -10.00 EUR
+    Food  10.00 EUR
+
+2014-12-01 * Last
+    ; tid: fedcba9876543210
+    Assets:Cash  -10.00 EUR
+    Food  10.00 EUR
+`,
+		},
+		{
+			id: "0123456789abcdef",
+			exp: `commodity EUR
+
+;; tid:2014-11-30 11:45:26.111 Sun
+;; legacy
+2014-11-30 * Legacy
+    Assets:Cash  -10.00 EUR
+    Food  10.00 EUR
+
+2014-12-01 * Last
+    ; tid: fedcba9876543210
+    Assets:Cash  -10.00 EUR
+    Food  10.00 EUR
+`,
+		},
+		{
+			id: "fedcba9876543210",
+			exp: `commodity EUR
+
+;; tid:2014-11-30 11:45:26.111 Sun
+;; legacy
+2014-11-30 * Legacy
+    Assets:Cash  -10.00 EUR
+    Food  10.00 EUR
+
+;; new
+2014-11-30 * New
+    ; tid: 0123456789abcdef
+    Assets:Cash  -10.00 EUR
+    Food  10.00 EUR
+`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.id, func(t *testing.T) {
+			res, err := filterOutTransactionWithID(strings.NewReader(ledgerFile), c.id)
+			assert.NoError(t, err)
+			assert.Equal(t, c.exp, string(res))
+		})
+	}
+
+	t.Run("unknown id", func(t *testing.T) {
+		_, err := filterOutTransactionWithID(strings.NewReader(ledgerFile), "0123456789")
+		assert.ErrorContains(t, err, "no transaction with id '0123456789' was found")
+	})
+
+	t.Run("adjacent transactions", func(t *testing.T) {
+		const adjacent = `;; raw
+2024-01-01 * Raw
+    A  1 EUR
+    B
+;; new
+2024-01-02 * New
+    ; tid: 0123456789abcdef
+    A  2 EUR
+    B
+2024-01-03 * Raw
+    A  3 EUR
+    B
+`
+		res, err := filterOutTransactionWithID(strings.NewReader(adjacent), "0123456789abcdef")
+		assert.NoError(t, err)
+		assert.Equal(t, `;; raw
+2024-01-01 * Raw
+    A  1 EUR
+    B
+2024-01-03 * Raw
+    A  3 EUR
+    B
+`, string(res))
+	})
+}
+
+func TestLedger_AddTransactionWithID(t *testing.T) {
+	r := &repo.Mock{Files: map[string]string{"main.ledger": "2024-01-01 * Raw\n    A  1 EUR\n    B"}}
+	ledger := NewLedger(r, nil)
+
+	err := ledger.AddTransactionWithID("2024-01-02 * New\n    A  2 EUR\n    B\n", "0123456789abcdef", User{})
+	require.NoError(t, err)
+	assert.Equal(t, `2024-01-01 * Raw
+    A  1 EUR
+    B
+
+2024-01-02 * New
+    ; tid: 0123456789abcdef
+    A  2 EUR
+    B
+`, r.Files["main.ledger"])
+
+	_, err = ledger.EditTransactionWithID("0123456789abcdef", "2024-01-02 * New\n    A  3 EUR\n    B", User{}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, `2024-01-01 * Raw
+    A  1 EUR
+    B
+
+2024-01-02 * New
+    ; tid: 0123456789abcdef
+    A  3 EUR
+    B
+`, r.Files["main.ledger"])
+
+	require.NoError(t, ledger.DeleteTransactionWithID("0123456789abcdef", User{}))
+	assert.Equal(t, "2024-01-01 * Raw\n    A  1 EUR\n    B\n", r.Files["main.ledger"])
+}
+
 func TestWithRepo(t *testing.T) {
 	_ = godotenv.Load("../../.env.dev")
 
-	gitURL := os.Getenv("GITHUB_URL")
+	gitURL := os.Getenv("GIT_URL")
 	if gitURL == "" {
 		t.Fatal("GIT_URL is not set")
 	}
 
-	gitToken := os.Getenv("GITHUB_TOKEN")
+	gitToken := os.Getenv("GIT_PASSWORD")
 	if gitToken == "" {
-		t.Fatal("GIT_ACCESS_TOKEN is not set")
+		t.Fatal("GIT_PASSWORD is not set")
 	}
 
-	inmemrepo := repo.NewInMemoryRepo(gitURL, gitToken)
+	inmemrepo := repo.NewInMemoryRepo(gitURL, repo.TokenAuth(gitToken))
 
 	ledger := NewLedger(inmemrepo, nil)
 
@@ -316,7 +819,7 @@ func TestWithRepo(t *testing.T) {
 
 	assert.NoError(t, err)
 
-	assert.True(t, ledger.Config.StrictMode)
+	assert.True(t, ledger.CurrentConfig().StrictMode)
 
 	assert.NotEmpty(t, res)
 }
@@ -0,0 +1,339 @@
+package ledger
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mput/teledger/app/ledger/journal"
+)
+
+// The reports are printed with the formats below, the fields are separated
+// with the unit separator and the rows end with the record separator,
+// so the payees and the multi-commodity amounts don't break the parsing.
+const (
+	fieldSep  = "\x1f"
+	recordSep = "\x1e"
+
+	balanceFormat = "%(partial_account(true))" + fieldSep +
+		"%(scrub(display_total))" + recordSep + "\n"
+
+	registerFormat = `%(format_date(date, "%Y-%m-%d"))` + fieldSep +
+		`%(cleared ? "*" : (pending ? "!" : ""))` + fieldSep +
+		"%(code)" + fieldSep +
+		"%(payee)" + fieldSep +
+		"%(display_account)" + fieldSep +
+		"%(scrub(display_amount))" + fieldSep +
+		"%(scrub(display_total))" + recordSep + "\n"
+)
+
+// AccountBalance is a row of the balance report
+type AccountBalance struct {
+	Account string
+	// Total has an amount per commodity
+	Total []journal.Amount
+}
+
+// RegisterRow is a row of the register report, a posting with the running total
+type RegisterRow struct {
+	Date  time.Time
+	State journal.State
+	Code  string
+	Payee string
+	// Account is in parentheses or brackets for the virtual postings
+	Account string
+	Amount  []journal.Amount
+	Total   []journal.Amount
+}
+
+// periodOptions are the only options allowed in the queries,
+// the others could read or write the files of the host
+var periodOptions = map[string]bool{"-b": true, "-e": true, "-p": true}
+
+// periodWords are the words of the period expressions, e.g. `from last month to today`
+var periodWords = map[string]bool{
+	"this": true, "last": true, "next": true, "from": true, "to": true, "since": true, "until": true, "in": true,
+	"today": true, "yesterday": true, "tomorrow": true,
+	"day": true, "week": true, "month": true, "quarter": true, "year": true,
+	"daily": true, "weekly": true, "monthly": true, "quarterly": true, "yearly": true, "every": true,
+	"january": true, "february": true, "march": true, "april": true, "may": true, "june": true,
+	"july": true, "august": true, "september": true, "october": true, "november": true, "december": true,
+	"jan": true, "feb": true, "mar": true, "apr": true, "jun": true, "jul": true,
+	"aug": true, "sep": true, "oct": true, "nov": true, "dec": true,
+}
+
+var periodDateRe = regexp.MustCompile(`^\d{4}([-/.]\d{1,2}([-/.]\d{1,2})?)?$`)
+
+// ParseQuery splits the query of a report like the shell does, the terms
+// may be quoted: `expenses -p "this month"`
+func ParseQuery(s string) ([]string, error) {
+	var res []string
+	var b strings.Builder
+	var quote rune
+	inTerm := false
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			b.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inTerm = r, true
+		case unicode.IsSpace(r):
+			if inTerm {
+				res = append(res, b.String())
+				b.Reset()
+				inTerm = false
+			}
+		default:
+			b.WriteRune(r)
+			inTerm = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unclosed quote in the query")
+	}
+	if inTerm {
+		res = append(res, b.String())
+	}
+	if err := validateQuery(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// validateQuery checks that the query has only the terms and the period options
+func validateQuery(query []string) error {
+	for i := 0; i < len(query); i++ {
+		term := query[i]
+		if !strings.HasPrefix(term, "-") {
+			continue
+		}
+		if !periodOptions[term] {
+			return fmt.Errorf("option %s is not allowed, only -b, -e and -p may be used", term)
+		}
+		i++
+		if i == len(query) {
+			return fmt.Errorf("option %s needs a period", term)
+		}
+		if err := validatePeriod(query[i]); err != nil {
+			return fmt.Errorf("invalid period of %s: %v", term, err)
+		}
+	}
+	return nil
+}
+
+// validatePeriod accepts the dates and the period expressions made of the known words
+func validatePeriod(period string) error {
+	words := strings.Fields(strings.ToLower(period))
+	if len(words) == 0 {
+		return fmt.Errorf("empty period")
+	}
+	for _, w := range words {
+		if !periodWords[w] && !periodDateRe.MatchString(w) {
+			return fmt.Errorf("unexpected '%s' in '%s'", w, period)
+		}
+	}
+	return nil
+}
+
+// Balance returns the balances of the accounts matching the query, e.g.
+// `expenses -p "this month"`, the accounts are listed flat without the total
+func (l *Ledger) Balance(query ...string) ([]AccountBalance, error) {
+	if err := validateQuery(query); err != nil {
+		return nil, err
+	}
+	args := append([]string{"balance", "--flat", "--no-total", "--balance-format", balanceFormat}, query...)
+	out, err := l.report(args)
+	if err != nil {
+		return nil, err
+	}
+	return parseBalance(out)
+}
+
+// Register returns the postings matching the query with the running total
+func (l *Ledger) Register(query ...string) ([]RegisterRow, error) {
+	if err := validateQuery(query); err != nil {
+		return nil, err
+	}
+	args := append([]string{"register", "--register-format", registerFormat}, query...)
+	out, err := l.report(args)
+	if err != nil {
+		return nil, err
+	}
+	return parseRegister(out)
+}
+
+// FormatBalance renders the balances like the flat balance report,
+// the amounts are right-aligned and the other commodities follow on the next lines
+func FormatBalance(rows []AccountBalance) string {
+	width := 0
+	for _, r := range rows {
+		for _, a := range amountStrings(r.Total) {
+			width = max(width, utf8.RuneCountInString(a))
+		}
+	}
+	var b strings.Builder
+	for _, r := range rows {
+		for i, a := range amountStrings(r.Total) {
+			if i == 0 {
+				fmt.Fprintf(&b, "%s  %s\n", padLeft(a, width), r.Account)
+			} else {
+				fmt.Fprintf(&b, "%s\n", padLeft(a, width))
+			}
+		}
+	}
+	return b.String()
+}
+
+// FormatRegister renders the postings with the running total,
+// the other commodities follow on the next lines
+func FormatRegister(rows []RegisterRow) string {
+	var payeeW, accountW, amountW, totalW int
+	for _, r := range rows {
+		payeeW = max(payeeW, utf8.RuneCountInString(r.Payee))
+		accountW = max(accountW, utf8.RuneCountInString(r.Account))
+		for _, a := range amountStrings(r.Amount) {
+			amountW = max(amountW, utf8.RuneCountInString(a))
+		}
+		for _, a := range amountStrings(r.Total) {
+			totalW = max(totalW, utf8.RuneCountInString(a))
+		}
+	}
+	var b strings.Builder
+	for _, r := range rows {
+		amounts, totals := amountStrings(r.Amount), amountStrings(r.Total)
+		for i := 0; i < max(len(amounts), len(totals)); i++ {
+			var date, payee, account, amount, total string
+			if i == 0 {
+				date, payee, account = r.Date.Format(time.DateOnly), r.Payee, r.Account
+			} else {
+				date = strings.Repeat(" ", len(time.DateOnly))
+			}
+			if i < len(amounts) {
+				amount = amounts[i]
+			}
+			if i < len(totals) {
+				total = totals[i]
+			}
+			line := fmt.Sprintf("%s %s  %s  %s  %s", date, padRight(payee, payeeW), padRight(account, accountW),
+				padLeft(amount, amountW), padLeft(total, totalW))
+			b.WriteString(strings.TrimRight(line, " ") + "\n")
+		}
+	}
+	return b.String()
+}
+
+// amountStrings returns the amounts one per commodity, the empty one is zero
+func amountStrings(amounts []journal.Amount) []string {
+	if len(amounts) == 0 {
+		return []string{"0"}
+	}
+	res := make([]string, 0, len(amounts))
+	for _, a := range amounts {
+		res = append(res, a.String())
+	}
+	return res
+}
+
+func padLeft(s string, width int) string {
+	return strings.Repeat(" ", max(0, width-utf8.RuneCountInString(s))) + s
+}
+
+func padRight(s string, width int) string {
+	return s + strings.Repeat(" ", max(0, width-utf8.RuneCountInString(s)))
+}
+
+// report runs the report with the files of the snapshot,
+// the empty report is not an error
+func (l *Ledger) report(args []string) (string, error) {
+	ro, err := l.readOnly()
+	if err != nil {
+		return "", err
+	}
+	return ro.run("", args...)
+}
+
+// records splits the report into the rows of the fields
+func records(out string, fields int) ([][]string, error) {
+	var res [][]string
+	for _, rec := range strings.Split(out, recordSep) {
+		rec = strings.TrimPrefix(rec, "\n")
+		if strings.TrimSpace(rec) == "" {
+			continue
+		}
+		fs := strings.Split(rec, fieldSep)
+		if len(fs) != fields {
+			return nil, fmt.Errorf("unexpected report row %q", rec)
+		}
+		res = append(res, fs)
+	}
+	return res, nil
+}
+
+// parseAmounts parses the amounts printed one per line
+func parseAmounts(s string) ([]journal.Amount, error) {
+	var res []journal.Amount
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		a, err := journal.ParseAmount(line)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse amount %q: %v", line, err)
+		}
+		res = append(res, *a)
+	}
+	return res, nil
+}
+
+func parseBalance(out string) ([]AccountBalance, error) {
+	recs, err := records(out, 2)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse balance report: %v", err)
+	}
+	res := make([]AccountBalance, 0, len(recs))
+	for _, rec := range recs {
+		total, err := parseAmounts(rec[1])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse balance of %s: %v", rec[0], err)
+		}
+		res = append(res, AccountBalance{Account: rec[0], Total: total})
+	}
+	return res, nil
+}
+
+func parseRegister(out string) ([]RegisterRow, error) {
+	recs, err := records(out, 7)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse register report: %v", err)
+	}
+	res := make([]RegisterRow, 0, len(recs))
+	for _, rec := range recs {
+		date, err := time.Parse(time.DateOnly, rec[0])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse register date: %v", err)
+		}
+		row := RegisterRow{Date: date, Code: rec[2], Payee: rec[3], Account: rec[4]}
+		switch rec[1] {
+		case "*":
+			row.State = journal.Cleared
+		case "!":
+			row.State = journal.Pending
+		}
+		row.Amount, err = parseAmounts(rec[5])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse amount of %s: %v", row.Account, err)
+		}
+		row.Total, err = parseAmounts(rec[6])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse total of %s: %v", row.Account, err)
+		}
+		res = append(res, row)
+	}
+	return res, nil
+}
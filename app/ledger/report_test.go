@@ -0,0 +1,166 @@
+package ledger
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mput/teledger/app/ledger/journal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// row joins the fields like the report formats
+func row(fields ...string) string {
+	return strings.Join(fields, fieldSep) + recordSep + "\n"
+}
+
+func TestParseBalance(t *testing.T) {
+	out := row("Assets:Cash", "-1,010.00 EUR\n$-5.50") +
+		row("Expenses:Food", "10.00 EUR") +
+		row("Expenses:Rent; flat", "1,000.00 EUR")
+
+	res, err := parseBalance(out)
+	require.NoError(t, err)
+	assert.Equal(t, []AccountBalance{
+		{Account: "Assets:Cash", Total: []journal.Amount{
+			{Quantity: "-1,010.00", Commodity: "EUR", Space: true},
+			{Quantity: "-5.50", Commodity: "$", Prefix: true},
+		}},
+		{Account: "Expenses:Food", Total: []journal.Amount{{Quantity: "10.00", Commodity: "EUR", Space: true}}},
+		{Account: "Expenses:Rent; flat", Total: []journal.Amount{{Quantity: "1,000.00", Commodity: "EUR", Space: true}}},
+	}, res)
+
+	t.Run("empty report", func(t *testing.T) {
+		res, err := parseBalance("")
+		require.NoError(t, err)
+		assert.Empty(t, res)
+	})
+
+	t.Run("invalid amount", func(t *testing.T) {
+		_, err := parseBalance(row("Assets:Cash", "EUR"))
+		assert.ErrorContains(t, err, "unable to parse balance of Assets:Cash")
+	})
+
+	t.Run("unexpected row", func(t *testing.T) {
+		_, err := parseBalance("Assets:Cash  10 EUR\n")
+		assert.ErrorContains(t, err, "unexpected report row")
+	})
+}
+
+func TestParseRegister(t *testing.T) {
+	out := row("2024-02-13", "*", "1042", "Taco Bell", "Expenses:Food", "10.00 EUR", "10.00 EUR") +
+		row("2024-02-13", "!", "", "Taco Bell", "(Budget:Food)", "-10.00 EUR", "0") +
+		row("2024-02-14", "", "", "Broker", "Assets:Broker", "10 AAPL", "10 AAPL\n-10.00 EUR")
+
+	res, err := parseRegister(out)
+	require.NoError(t, err)
+	eur := func(q string) journal.Amount {
+		return journal.Amount{Quantity: q, Commodity: "EUR", Space: true}
+	}
+	assert.Equal(t, []RegisterRow{
+		{
+			Date: time.Date(2024, 2, 13, 0, 0, 0, 0, time.UTC), State: journal.Cleared, Code: "1042",
+			Payee: "Taco Bell", Account: "Expenses:Food",
+			Amount: []journal.Amount{eur("10.00")}, Total: []journal.Amount{eur("10.00")},
+		},
+		{
+			Date: time.Date(2024, 2, 13, 0, 0, 0, 0, time.UTC), State: journal.Pending,
+			Payee: "Taco Bell", Account: "(Budget:Food)",
+			Amount: []journal.Amount{eur("-10.00")}, Total: []journal.Amount{{Quantity: "0"}},
+		},
+		{
+			Date: time.Date(2024, 2, 14, 0, 0, 0, 0, time.UTC), State: journal.Uncleared,
+			Payee: "Broker", Account: "Assets:Broker",
+			Amount: []journal.Amount{{Quantity: "10", Commodity: "AAPL", Space: true}},
+			Total:  []journal.Amount{{Quantity: "10", Commodity: "AAPL", Space: true}, eur("-10.00")},
+		},
+	}, res)
+
+	t.Run("invalid date", func(t *testing.T) {
+		_, err := parseRegister(row("13.02.2024", "", "", "Shop", "Food", "1 EUR", "1 EUR"))
+		assert.ErrorContains(t, err, "unable to parse register date")
+	})
+}
+
+func TestFormatBalance(t *testing.T) {
+	res := FormatBalance([]AccountBalance{
+		{Account: "Assets:Cash", Total: []journal.Amount{
+			{Quantity: "-1,010.00", Commodity: "EUR", Space: true},
+			{Quantity: "-5.50", Commodity: "$", Prefix: true},
+		}},
+		{Account: "Expenses:Food", Total: []journal.Amount{{Quantity: "10.00", Commodity: "EUR", Space: true}}},
+		{Account: "Equity"},
+	})
+	assert.Equal(t, `-1,010.00 EUR  Assets:Cash
+       $-5.50
+    10.00 EUR  Expenses:Food
+            0  Equity
+`, res)
+
+	assert.Empty(t, FormatBalance(nil))
+}
+
+func TestFormatRegister(t *testing.T) {
+	eur := func(q string) journal.Amount {
+		return journal.Amount{Quantity: q, Commodity: "EUR", Space: true}
+	}
+	res := FormatRegister([]RegisterRow{
+		{
+			Date: time.Date(2024, 2, 13, 0, 0, 0, 0, time.UTC), Payee: "Taco Bell", Account: "Expenses:Food",
+			Amount: []journal.Amount{eur("10.00")}, Total: []journal.Amount{eur("10.00")},
+		},
+		{
+			Date: time.Date(2024, 2, 14, 0, 0, 0, 0, time.UTC), Payee: "Broker", Account: "Assets:Broker",
+			Amount: []journal.Amount{{Quantity: "10", Commodity: "AAPL", Space: true}},
+			Total:  []journal.Amount{{Quantity: "10", Commodity: "AAPL", Space: true}, eur("10.00")},
+		},
+	})
+	assert.Equal(t, `2024-02-13 Taco Bell  Expenses:Food  10.00 EUR  10.00 EUR
+2024-02-14 Broker     Assets:Broker    10 AAPL    10 AAPL
+                                                10.00 EUR
+`, res)
+}
+
+func TestParseQuery(t *testing.T) {
+	tbl := []struct {
+		in   string
+		res  []string
+		err  string
+		name string
+	}{
+		{name: "terms", in: " expenses  food ", res: []string{"expenses", "food"}},
+		{name: "empty", in: "", res: nil},
+		{name: "quoted period", in: `food -p "this month"`, res: []string{"food", "-p", "this month"}},
+		{name: "single quotes", in: `-b 2024-02-01 -e 'last week' @Taco`, res: []string{"-b", "2024-02-01", "-e", "last week", "@Taco"}},
+		{name: "quoted term", in: `"Expenses:Eating Out"`, res: []string{"Expenses:Eating Out"}},
+		{name: "period range", in: `-p "from 2024/01 to Mar"`, res: []string{"-p", "from 2024/01 to Mar"}},
+		{name: "unclosed quote", in: `-p "this month`, err: "unclosed quote"},
+		{name: "output", in: "expenses -o /tmp/out", err: "option -o is not allowed"},
+		{name: "file", in: "--file=/etc/passwd", err: "option --file=/etc/passwd is not allowed"},
+		{name: "format", in: "--balance-format %(account)", err: "option --balance-format is not allowed"},
+		{name: "attached value", in: "-p2024", err: "option -p2024 is not allowed"},
+		{name: "missing period", in: "food -p", err: "option -p needs a period"},
+		{name: "option as period", in: "-b -f /etc/passwd", err: "invalid period of -b"},
+		{name: "unknown period", in: `-p "this (1/0)"`, err: "unexpected '(1/0)'"},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ParseQuery(tt.in)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.res, res)
+		})
+	}
+
+	t.Run("reports check the query", func(t *testing.T) {
+		l := &Ledger{}
+		_, err := l.Balance("-f", "/etc/passwd")
+		assert.ErrorContains(t, err, "option -f is not allowed")
+		_, err = l.Register("--register-format", "%(payee)")
+		assert.ErrorContains(t, err, "option --register-format is not allowed")
+	})
+}
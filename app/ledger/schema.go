@@ -0,0 +1,272 @@
+package ledger
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// proposeTransactionTool is the name of the tool (function)
+// the LLM is forced to call with the transaction as arguments
+const proposeTransactionTool = "propose_transaction"
+
+const proposeTransactionToolDescription = "Propose a transaction in the ledger for the user request"
+
+// transactionSchema returns the JSON schema of the Transaction,
+// the accounts and currencies are limited to the ones existing in the ledger file.
+func transactionSchema(accounts, commodities []string) map[string]any {
+	account := map[string]any{
+		"type":        "string",
+		"description": "The name of the account",
+	}
+	currency := map[string]any{
+		"type":        "string",
+		"description": "The currency of the amount",
+	}
+	if len(accounts) > 0 {
+		account["enum"] = accounts
+	}
+	if len(commodities) > 0 {
+		currency["enum"] = commodities
+	}
+
+	return map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"date": map[string]any{
+				"type":        "string",
+				"description": "The date of the transaction in YYYY-MM-DD format",
+			},
+			"aux_date": map[string]any{
+				"type":        "string",
+				"description": "The auxiliary date in YYYY-MM-DD format, e.g. the date the payment is settled. Omit if not mentioned",
+			},
+			"state": stateSchema(
+				"The clearing state of the transaction, cleared if omitted. " +
+					"Use pending for the transactions to be reconciled later, e.g. card payments",
+			),
+			"code": map[string]any{
+				"type":        "string",
+				"description": "The code of the transaction, e.g. the check or the invoice number. Omit if not mentioned",
+			},
+			"description": map[string]any{
+				"type":        "string",
+				"description": "A description of the transaction",
+			},
+			"note":     noteSchema,
+			"tags":     tagsSchema,
+			"metadata": metadataSchema,
+			"postings": map[string]any{
+				"type":        "array",
+				"description": "Postings of the transaction, amounts of all postings must balance to zero",
+				"minItems":    2,
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"account": account,
+						"account_kind": map[string]any{
+							"type": "string",
+							"enum": []AccountKind{AccountVirtual, AccountBalancedVirtual},
+							"description": "Omit for real postings. Virtual postings don't have to balance, " +
+								"balanced_virtual postings balance among themselves, e.g. for budgets",
+						},
+						"amount": map[string]any{
+							"type":        "number",
+							"description": "The amount posted to the account, with all its digits, it's not rounded",
+						},
+						"currency": currency,
+						"cost": priceSchema(currency,
+							"The price paid in another currency when the amount is exchanged, "+
+								"e.g. 50 USD paid from the EUR card is the amount 50 USD with the total cost in EUR. "+
+								"Omit if the posting is in the currency of the account",
+						),
+						"lot_price": priceSchema(currency,
+							"The price the commodity, e.g. shares, was bought at, it's used to track the lots. "+
+								"Omit if not needed",
+						),
+						"state": stateSchema("The clearing state of the posting if it differs from the transaction"),
+						"aux_date": map[string]any{
+							"type":        "string",
+							"description": "The auxiliary date of the posting in YYYY-MM-DD format. Omit if not mentioned",
+						},
+						"note":     noteSchema,
+						"tags":     tagsSchema,
+						"metadata": metadataSchema,
+					},
+					"required":             []string{"account", "amount", "currency"},
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required":             []string{"date", "description", "postings"},
+		"additionalProperties": false,
+	}
+}
+
+func stateSchema(description string) map[string]any {
+	return map[string]any{
+		"type":        "string",
+		"enum":        []State{StateCleared, StatePending, StateUncleared},
+		"description": description,
+	}
+}
+
+func priceSchema(currency map[string]any, description string) map[string]any {
+	return map[string]any{
+		"type":        "object",
+		"description": description,
+		"properties": map[string]any{
+			"amount": map[string]any{
+				"type":        "number",
+				"description": "The positive price, with all its digits",
+			},
+			"currency": currency,
+			"total": map[string]any{
+				"type":        "boolean",
+				"description": "The price is of the whole amount (@@), otherwise it's per unit (@)",
+			},
+		},
+		"required":             []string{"amount", "currency"},
+		"additionalProperties": false,
+	}
+}
+
+var noteSchema = map[string]any{
+	"type":        "string",
+	"description": "A short note, a single line. Omit if not needed",
+}
+
+var tagsSchema = map[string]any{
+	"type":        "array",
+	"description": "Tags, single words without spaces and colons. Omit if not needed",
+	"items":       map[string]any{"type": "string"},
+}
+
+var metadataSchema = map[string]any{
+	"type":                 "object",
+	"description":          "Metadata as key-value pairs, the keys are single words without colons. Omit if not needed",
+	"additionalProperties": map[string]any{"type": "string"},
+}
+
+// schema returns the JSON schema of the transaction for the prompt context
+func (p PromptCtx) schema() map[string]any {
+	return transactionSchema(p.Accounts, p.Commodities)
+}
+
+// Schema returns the JSON schema of the transaction as a string,
+// to be used in the prompt template
+func (p PromptCtx) Schema() string {
+	res, err := json.MarshalIndent(p.schema(), "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return string(res)
+}
+
+// validateTransaction checks that the transaction matches the schema
+func validateTransaction(tr *Transaction, accounts, commodities []string) error {
+	if tr.Date != "" {
+		if _, err := time.Parse("2006-01-02", tr.Date); err != nil {
+			return fmt.Errorf("invalid date '%s', expected YYYY-MM-DD", tr.Date)
+		}
+	}
+	if tr.Description == "" {
+		return fmt.Errorf("empty description")
+	}
+	err := validateDetails(tr.AuxDate, tr.State, tr.Code, tr.Note, tr.Tags, tr.Metadata)
+	if err != nil {
+		return err
+	}
+	if len(tr.Postings) < 2 {
+		return fmt.Errorf("at least two postings are required, got %d", len(tr.Postings))
+	}
+	for _, p := range tr.Postings {
+		if p.Account == "" {
+			return fmt.Errorf("empty account in posting")
+		}
+		if p.Currency == "" {
+			return fmt.Errorf("empty currency in posting to '%s'", p.Account)
+		}
+		if p.AccountKind != "" && p.AccountKind != AccountVirtual && p.AccountKind != AccountBalancedVirtual {
+			return fmt.Errorf("invalid account kind '%s' in posting to '%s'", p.AccountKind, p.Account)
+		}
+		err = validateDetails(p.AuxDate, p.State, "", p.Note, p.Tags, p.Metadata)
+		if err != nil {
+			return fmt.Errorf("%v in posting to '%s'", err, p.Account)
+		}
+		for _, price := range []struct {
+			name  string
+			price *Price
+		}{{"cost", p.Cost}, {"lot price", p.LotPrice}} {
+			err = validatePrice(price.price, p.Currency, commodities)
+			if err != nil {
+				return fmt.Errorf("invalid %s in posting to '%s': %v", price.name, p.Account, err)
+			}
+		}
+		if len(accounts) > 0 && !slices.Contains(accounts, p.Account) {
+			return fmt.Errorf("account '%s' is not in the list of accounts", p.Account)
+		}
+		if len(commodities) > 0 && !slices.Contains(commodities, p.Currency) {
+			return fmt.Errorf("currency '%s' is not in the list of currencies", p.Currency)
+		}
+	}
+	return nil
+}
+
+// validateDetails checks the optional fields of a transaction or a posting,
+// they must fit into the header or the comment lines
+func validateDetails(auxDate string, state State, code, note string, tags []string, metadata map[string]string) error {
+	if auxDate != "" {
+		if _, err := time.Parse("2006-01-02", auxDate); err != nil {
+			return fmt.Errorf("invalid auxiliary date '%s', expected YYYY-MM-DD", auxDate)
+		}
+	}
+	if state != "" && state != StateCleared && state != StatePending && state != StateUncleared {
+		return fmt.Errorf("invalid state '%s'", state)
+	}
+	if strings.ContainsAny(code, "()\n") {
+		return fmt.Errorf("invalid code '%s'", code)
+	}
+	if strings.Contains(note, "\n") {
+		return fmt.Errorf("multiline note")
+	}
+	for _, tag := range tags {
+		if tag == "" || strings.ContainsAny(tag, ": \t\n") {
+			return fmt.Errorf("invalid tag '%s'", tag)
+		}
+	}
+	for k, v := range metadata {
+		if k == "" || strings.ContainsAny(k, ": \t\n") {
+			return fmt.Errorf("invalid metadata key '%s'", k)
+		}
+		if k == transactionIDTag {
+			return fmt.Errorf("metadata key '%s' is reserved", k)
+		}
+		if strings.Contains(v, "\n") {
+			return fmt.Errorf("multiline metadata value of '%s'", k)
+		}
+	}
+	return nil
+}
+
+// validatePrice checks the cost or the lot price of the posting in the currency
+func validatePrice(price *Price, currency string, commodities []string) error {
+	if price == nil {
+		return nil
+	}
+	if price.Currency == "" {
+		return fmt.Errorf("empty currency")
+	}
+	if price.Currency == currency {
+		return fmt.Errorf("currency '%s' is the same as of the amount", currency)
+	}
+	if price.Amount.Sign() < 0 {
+		return fmt.Errorf("negative amount %s", price.Amount)
+	}
+	if len(commodities) > 0 && !slices.Contains(commodities, price.Currency) {
+		return fmt.Errorf("currency '%s' is not in the list of currencies", price.Currency)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package ledger
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTransaction(t *testing.T) {
+	accounts := []string{"Assets:Cash", "Expenses:Food"}
+	commodities := []string{"EUR", "USD"}
+
+	valid := func() Transaction {
+		return Transaction{
+			Date:        "2024-02-14",
+			Description: "Taco Bell",
+			Postings: []Posting{
+				{Account: "Assets:Cash", Amount: NewDecimal(-20, 0), Currency: "EUR"},
+				{Account: "Expenses:Food", Amount: NewDecimal(20, 0), Currency: "EUR"},
+			},
+		}
+	}
+
+	cases := []struct {
+		name   string
+		modify func(tr *Transaction)
+		err    string
+	}{
+		{name: "valid", modify: func(_ *Transaction) {}},
+		{name: "invalid date", modify: func(tr *Transaction) { tr.Date = "14.02.2024" }, err: "invalid date"},
+		{name: "empty description", modify: func(tr *Transaction) { tr.Description = "" }, err: "empty description"},
+		{name: "single posting", modify: func(tr *Transaction) { tr.Postings = tr.Postings[:1] }, err: "at least two postings"},
+		{name: "empty currency", modify: func(tr *Transaction) { tr.Postings[0].Currency = "" }, err: "empty currency"},
+		{
+			name:   "unknown account",
+			modify: func(tr *Transaction) { tr.Postings[1].Account = "Expenses:Tacos" },
+			err:    "account 'Expenses:Tacos' is not in the list of accounts",
+		},
+		{
+			name:   "unknown currency",
+			modify: func(tr *Transaction) { tr.Postings[1].Currency = "GBP" },
+			err:    "currency 'GBP' is not in the list of currencies",
+		},
+		{name: "invalid aux date", modify: func(tr *Transaction) { tr.AuxDate = "16.02.2024" }, err: "invalid auxiliary date"},
+		{name: "invalid state", modify: func(tr *Transaction) { tr.State = "reconciled" }, err: "invalid state 'reconciled'"},
+		{name: "invalid code", modify: func(tr *Transaction) { tr.Code = "(42)" }, err: "invalid code"},
+		{name: "invalid tag", modify: func(tr *Transaction) { tr.Tags = []string{"fast food"} }, err: "invalid tag 'fast food'"},
+		{name: "reserved metadata", modify: func(tr *Transaction) { tr.Metadata = map[string]string{"tid": "x"} }, err: "metadata key 'tid' is reserved"},
+		{
+			name:   "multiline posting note",
+			modify: func(tr *Transaction) { tr.Postings[1].Note = "one\ntwo" },
+			err:    "multiline note in posting to 'Expenses:Food'",
+		},
+		{
+			name:   "invalid account kind",
+			modify: func(tr *Transaction) { tr.Postings[1].AccountKind = "budget" },
+			err:    "invalid account kind 'budget'",
+		},
+		{
+			name: "details",
+			modify: func(tr *Transaction) {
+				tr.State = StatePending
+				tr.AuxDate = "2024-02-16"
+				tr.Tags = []string{"food"}
+				tr.Metadata = map[string]string{"Place": "Berlin"}
+				tr.Postings[1].AccountKind = AccountVirtual
+				tr.Postings[1].State = StateCleared
+			},
+		},
+		{
+			name: "cost",
+			modify: func(tr *Transaction) {
+				tr.Postings[1].Currency = "USD"
+				tr.Postings[1].Cost = &Price{Amount: NewDecimal(20, 0), Currency: "EUR", Total: true}
+			},
+		},
+		{
+			name:   "cost in the same currency",
+			modify: func(tr *Transaction) { tr.Postings[1].Cost = &Price{Amount: NewDecimal(1, 0), Currency: "EUR"} },
+			err:    "invalid cost in posting to 'Expenses:Food': currency 'EUR' is the same as of the amount",
+		},
+		{
+			name: "negative cost",
+			modify: func(tr *Transaction) {
+				tr.Postings[1].Currency = "USD"
+				tr.Postings[1].Cost = &Price{Amount: NewDecimal(-20, 0), Currency: "EUR", Total: true}
+			},
+			err: "invalid cost in posting to 'Expenses:Food': negative amount -20",
+		},
+		{
+			name:   "lot price without currency",
+			modify: func(tr *Transaction) { tr.Postings[1].LotPrice = &Price{Amount: NewDecimal(1, 0)} },
+			err:    "invalid lot price in posting to 'Expenses:Food': empty currency",
+		},
+		{
+			name:   "unknown cost currency",
+			modify: func(tr *Transaction) { tr.Postings[1].Cost = &Price{Amount: NewDecimal(1, 0), Currency: "GBP"} },
+			err:    "invalid cost in posting to 'Expenses:Food': currency 'GBP' is not in the list of currencies",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tr := valid()
+			c.modify(&tr)
+			err := validateTransaction(&tr, accounts, commodities)
+			if c.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, c.err)
+			}
+		})
+	}
+}
+
+func TestPromptCtx_Schema(t *testing.T) {
+	promptCtx := PromptCtx{
+		Accounts:    []string{"Assets:Cash", "Expenses:Food"},
+		Commodities: []string{"EUR"},
+	}
+
+	schema := promptCtx.Schema()
+	assert.Contains(t, schema, `"Assets:Cash",`)
+	assert.Contains(t, schema, `"enum": [
+              "EUR"
+            ]`)
+}
@@ -0,0 +1,89 @@
+package ledger
+
+import (
+	"fmt"
+
+	"github.com/mput/teledger/app/repo"
+)
+
+// undoDepth limits how deep in the history the changes made by teledger are looked for
+const undoDepth = 50
+
+// history returns the history of the repo, if it's kept
+func (l *Ledger) history() (repo.History, error) {
+	h, ok := l.repo.(repo.History)
+	if !ok {
+		return nil, fmt.Errorf("undo is not supported by the repo")
+	}
+	return h, nil
+}
+
+// RecentChanges returns up to n last changes made by teledger, the newest first.
+// Only the commits marked with repo.TransactionTrailer are the changes.
+func (l *Ledger) RecentChanges(n int) ([]repo.Change, error) {
+	h, err := l.history()
+	if err != nil {
+		return nil, err
+	}
+	err = l.repo.Init()
+	defer l.repo.Free()
+	if err != nil {
+		return nil, fmt.Errorf("unable to init repo: %v", err)
+	}
+
+	log, err := h.Log(undoDepth)
+	if err != nil {
+		return nil, err
+	}
+	var res []repo.Change
+	for _, c := range log {
+		if len(res) == n {
+			break
+		}
+		if c.Teledger {
+			res = append(res, c)
+		}
+	}
+	return res, nil
+}
+
+// Undo reverts the change made by teledger with a new commit,
+// the ledger must stay valid after the revert.
+// Returns the reverted change.
+func (l *Ledger) Undo(hash string, user User) (repo.Change, error) {
+	h, err := l.history()
+	if err != nil {
+		return repo.Change{}, err
+	}
+	var reverted repo.Change
+	err = l.commitChange(user, func() (string, error) {
+		log, err := h.Log(undoDepth)
+		if err != nil {
+			return "", err
+		}
+		found := false
+		for _, c := range log {
+			if c.Hash == hash {
+				reverted, found = c, true
+				break
+			}
+		}
+		if !found || !reverted.Teledger {
+			return "", fmt.Errorf("change %s is not found among the recent teledger changes", hash)
+		}
+
+		err = h.Revert(hash)
+		if err != nil {
+			return "", fmt.Errorf("unable to undo %q: %w", reverted.Subject(), err)
+		}
+		err = l.validate()
+		if err != nil {
+			return "", fmt.Errorf("unable to undo %q, the ledger becomes invalid: %v", reverted.Subject(), err)
+		}
+		return repo.TransactionMessage(repo.RevertMessage(reverted), reverted.TransactionID), nil
+	})
+	if err != nil {
+		return repo.Change{}, err
+	}
+	return reverted, nil
+}
@@ -0,0 +1,59 @@
+package ledger
+
+import (
+	"testing"
+
+	"github.com/mput/teledger/app/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLedger_Undo(t *testing.T) {
+	r := &repo.Mock{Files: map[string]string{
+		"main.ledger": "\naccount Food\naccount Assets:Cash\ncommodity EUR\n",
+	}}
+	l := NewLedger(r, nil)
+	user := User{ID: 42, Name: "john"}
+
+	require.NoError(t, l.AddTransactionWithID("2014-12-01 * Tacos\n    Assets:Cash  -10.00 EUR\n    Food", "0123456789abcdef", user))
+	_, err := l.AddComment("hello", user)
+	require.NoError(t, err)
+	// e.g. a commit of the pending transactions, it's not a change of the ledger
+	require.NoError(t, r.Init())
+	require.NoError(t, r.CommitPush("Add pending transaction 0123456789abcdef", defaultAuthorName, defaultAuthorEmail))
+	r.Free()
+
+	changes, err := l.RecentChanges(5)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.Equal(t, "Add comment", changes[0].Subject())
+	assert.Equal(t, "Add transaction: Tacos, 10.00 EUR", changes[1].Subject())
+	assert.Equal(t, "0123456789abcdef", changes[1].TransactionID)
+
+	changes, err = l.RecentChanges(1)
+	require.NoError(t, err)
+	assert.Len(t, changes, 1)
+
+	reverted, err := l.Undo(changes[0].Hash, user)
+	require.NoError(t, err)
+	assert.Equal(t, "Add comment", reverted.Subject())
+	assert.NotContains(t, r.Files["main.ledger"], "hello")
+	assert.Contains(t, r.Files["main.ledger"], "Tacos")
+	assert.Equal(t, repo.Commit{Msg: repo.TransactionMessage(repo.RevertMessage(reverted), ""), Name: defaultAuthorName, Email: defaultAuthorEmail}, r.Commits[len(r.Commits)-1])
+
+	t.Run("unknown change", func(t *testing.T) {
+		_, err := l.Undo("0123", user)
+		assert.ErrorContains(t, err, "not found")
+	})
+
+	t.Run("not supported", func(t *testing.T) {
+		l := NewLedger(noHistoryRepo{r}, nil)
+		_, err := l.RecentChanges(5)
+		assert.ErrorContains(t, err, "not supported")
+	})
+}
+
+// noHistoryRepo hides the history of the repo
+type noHistoryRepo struct {
+	repo.Service
+}
@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"fmt"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+// defaultHTTPUsername is used with a password-only credentials,
+// GitHub and most of the other forges accept any username with a token
+const defaultHTTPUsername = "username"
+
+// Auth holds the credentials for the remote,
+// the ones matching the protocol of the url are used
+type Auth struct {
+	// Username for HTTPS, defaults to defaultHTTPUsername if only the password is set
+	Username string
+	// Password or access token for HTTPS
+	Password string
+	// SSHKey is the path to the private key, e.g. a deploy key.
+	// The SSH agent is used if empty.
+	SSHKey string
+	// SSHKeyPassphrase decrypts the private key
+	SSHKeyPassphrase string
+	// KnownHosts are the files with the host keys of the server,
+	// ~/.ssh/known_hosts and /etc/ssh/ssh_known_hosts (or $SSH_KNOWN_HOSTS) if empty
+	KnownHosts []string
+}
+
+// TokenAuth returns the HTTPS credentials with the access token
+func TokenAuth(token string) Auth {
+	return Auth{Password: token}
+}
+
+// Method returns the auth method for the url, nil if no credentials are needed
+func (a Auth) Method(url string) (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repo url: %v", err)
+	}
+
+	switch ep.Protocol {
+	case "ssh":
+		return a.sshMethod(ep.User)
+	case "http", "https":
+		if a.Username == "" && a.Password == "" {
+			return nil, nil
+		}
+		username := a.Username
+		if username == "" {
+			username = defaultHTTPUsername
+		}
+		return &http.BasicAuth{
+			Username: username,
+			Password: a.Password,
+		}, nil
+	default:
+		// local repos
+		return nil, nil
+	}
+}
+
+func (a Auth) sshMethod(user string) (transport.AuthMethod, error) {
+	if user == "" {
+		user = ssh.DefaultUsername
+	}
+
+	// host keys are always verified
+	hostKeyCallback, err := ssh.NewKnownHostsCallback(a.KnownHosts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load known hosts: %v", err)
+	}
+
+	if a.SSHKey != "" {
+		keys, err := ssh.NewPublicKeysFromFile(user, a.SSHKey, a.SSHKeyPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load ssh key %s: %v", a.SSHKey, err)
+		}
+		keys.HostKeyCallback = hostKeyCallback
+		return keys, nil
+	}
+
+	agent, err := ssh.NewSSHAgentAuth(user)
+	if err != nil {
+		return nil, fmt.Errorf("no ssh key provided and unable to use ssh agent: %v", err)
+	}
+	agent.HostKeyCallback = hostKeyCallback
+	return agent, nil
+}
@@ -0,0 +1,103 @@
+package repo
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSSHKey writes a new private key to the dir and returns its path
+// and the parsed key
+func newSSHKey(t *testing.T, dir, name string) (string, *ssh.PublicKeys) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pemBytes, 0o600))
+	keys, err := ssh.NewPublicKeys("git", pemBytes, "")
+	require.NoError(t, err)
+	return path, keys
+}
+
+func TestAuth_Method(t *testing.T) {
+	dir := t.TempDir()
+	keyPath, _ := newSSHKey(t, dir, "deploy_key")
+	_, hostKey := newSSHKey(t, dir, "host_key")
+	_, otherKey := newSSHKey(t, dir, "other_key")
+
+	knownHosts := filepath.Join(dir, "known_hosts")
+	pub := hostKey.Signer.PublicKey()
+	require.NoError(t, os.WriteFile(knownHosts, []byte(fmt.Sprintf(
+		"git.example.com %s %s\n[git.example.com]:2222 %[1]s %[2]s\n",
+		pub.Type(), base64.StdEncoding.EncodeToString(pub.Marshal()),
+	)), 0o600))
+
+	t.Run("ssh with deploy key", func(t *testing.T) {
+		m, err := Auth{SSHKey: keyPath, KnownHosts: []string{knownHosts}}.Method("git@git.example.com:team/ledger.git")
+		require.NoError(t, err)
+		keys, ok := m.(*ssh.PublicKeys)
+		require.True(t, ok, "unexpected auth method %T", m)
+		assert.Equal(t, "git", keys.User)
+
+		addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+		assert.NoError(t, keys.HostKeyCallback("git.example.com:22", addr, hostKey.Signer.PublicKey()))
+		assert.Error(t, keys.HostKeyCallback("git.example.com:22", addr, otherKey.Signer.PublicKey()))
+		assert.Error(t, keys.HostKeyCallback("evil.example.com:22", addr, hostKey.Signer.PublicKey()))
+	})
+
+	t.Run("ssh url with user and port", func(t *testing.T) {
+		m, err := Auth{SSHKey: keyPath, KnownHosts: []string{knownHosts}}.Method("ssh://deploy@git.example.com:2222/team/ledger.git")
+		require.NoError(t, err)
+		keys := m.(*ssh.PublicKeys)
+		assert.Equal(t, "deploy", keys.User)
+		addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222}
+		assert.NoError(t, keys.HostKeyCallback("git.example.com:2222", addr, hostKey.Signer.PublicKey()))
+	})
+
+	t.Run("ssh errors", func(t *testing.T) {
+		_, err := Auth{SSHKey: keyPath, KnownHosts: []string{filepath.Join(dir, "missing")}}.Method("git@git.example.com:ledger.git")
+		assert.ErrorContains(t, err, "unable to load known hosts")
+
+		_, err = Auth{SSHKey: filepath.Join(dir, "missing"), KnownHosts: []string{knownHosts}}.Method("git@git.example.com:ledger.git")
+		assert.ErrorContains(t, err, "unable to load ssh key")
+
+		t.Setenv("SSH_AUTH_SOCK", "")
+		_, err = Auth{KnownHosts: []string{knownHosts}}.Method("git@git.example.com:ledger.git")
+		assert.ErrorContains(t, err, "unable to use ssh agent")
+	})
+
+	t.Run("https", func(t *testing.T) {
+		m, err := Auth{Username: "john", Password: "secret"}.Method("https://git.example.com/team/ledger.git")
+		require.NoError(t, err)
+		assert.Equal(t, &http.BasicAuth{Username: "john", Password: "secret"}, m)
+
+		m, err = TokenAuth("token").Method("https://github.com/team/ledger.git")
+		require.NoError(t, err)
+		assert.Equal(t, &http.BasicAuth{Username: defaultHTTPUsername, Password: "token"}, m)
+
+		m, err = Auth{}.Method("https://github.com/team/ledger.git")
+		require.NoError(t, err)
+		assert.Nil(t, m)
+	})
+
+	t.Run("local", func(t *testing.T) {
+		m, err := Auth{SSHKey: keyPath}.Method(dir)
+		require.NoError(t, err)
+		assert.Nil(t, m)
+	})
+}
@@ -0,0 +1,89 @@
+package repo
+
+import (
+	"fmt"
+	"sync"
+)
+
+// PullRequestState is the review state of a pull request
+type PullRequestState string
+
+const (
+	PullRequestOpen   PullRequestState = "open"
+	PullRequestClosed PullRequestState = "closed"
+	PullRequestMerged PullRequestState = "merged"
+)
+
+// PullRequest is a pull (merge) request on the forge
+type PullRequest struct {
+	Number int
+	URL    string
+	State  PullRequestState
+	// source and target branches
+	Head, Base string
+}
+
+// Forge is the API of the git hosting used to open pull requests
+type Forge interface {
+	// OpenPullRequest opens a pull request from the head branch to the base one,
+	// the already open pull request of the head branch is returned if there is one
+	OpenPullRequest(head, base, title, body string) (*PullRequest, error)
+	// FindPullRequest returns the last pull request of the head branch,
+	// nil if there are none
+	FindPullRequest(head string) (*PullRequest, error)
+}
+
+// FakeForge is an in-memory Forge for tests
+type FakeForge struct {
+	mu           sync.Mutex
+	pullRequests []PullRequest
+}
+
+func (f *FakeForge) OpenPullRequest(head, base, _, _ string) (*PullRequest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.pullRequests) - 1; i >= 0; i-- {
+		pr := f.pullRequests[i]
+		if pr.Head == head && pr.State == PullRequestOpen {
+			return &pr, nil
+		}
+	}
+	pr := PullRequest{
+		Number: len(f.pullRequests) + 1,
+		State:  PullRequestOpen,
+		Head:   head,
+		Base:   base,
+	}
+	pr.URL = fmt.Sprintf("https://forge.example.com/pulls/%d", pr.Number)
+	f.pullRequests = append(f.pullRequests, pr)
+	return &pr, nil
+}
+
+func (f *FakeForge) FindPullRequest(head string) (*PullRequest, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.pullRequests) - 1; i >= 0; i-- {
+		if pr := f.pullRequests[i]; pr.Head == head {
+			return &pr, nil
+		}
+	}
+	return nil, nil
+}
+
+// PullRequests returns all the opened pull requests
+func (f *FakeForge) PullRequests() []PullRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]PullRequest(nil), f.pullRequests...)
+}
+
+// SetState changes the state of the pull request, e.g. to merge it
+func (f *FakeForge) SetState(number int, state PullRequestState) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if number <= 0 || number > len(f.pullRequests) {
+		return fmt.Errorf("no pull request #%d", number)
+	}
+	f.pullRequests[number-1].State = state
+	return nil
+}
@@ -0,0 +1,161 @@
+package repo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const defaultGitHubAPIURL = "https://api.github.com"
+
+// gitHubRepoURL matches the owner and the name of the repo in
+// https://github.com/owner/repo.git and git@github.com:owner/repo.git
+var gitHubRepoURL = regexp.MustCompile(`[/:]([^/:]+)/([^/]+?)(?:\.git)?/?$`)
+
+// GitHubForge opens pull requests with the GitHub REST API
+type GitHubForge struct {
+	apiURL string
+	token  string
+	owner  string
+	name   string
+	client *http.Client
+}
+
+// NewGitHubForge creates the forge of the repo, the token needs
+// the Pull requests RW scope
+func NewGitHubForge(repoURL, token string) (*GitHubForge, error) {
+	m := gitHubRepoURL.FindStringSubmatch(repoURL)
+	if m == nil {
+		return nil, fmt.Errorf("unable to find owner and name of the repo in %s", repoURL)
+	}
+	return &GitHubForge{
+		apiURL: defaultGitHubAPIURL,
+		token:  token,
+		owner:  m[1],
+		name:   m[2],
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// WithAPIURL makes the forge use the API of GitHub Enterprise Server
+func (gh *GitHubForge) WithAPIURL(apiURL string) *GitHubForge {
+	gh.apiURL = strings.TrimRight(apiURL, "/")
+	return gh
+}
+
+type gitHubPullRequest struct {
+	Number   int        `json:"number"`
+	HTMLURL  string     `json:"html_url"`
+	State    string     `json:"state"`
+	MergedAt *time.Time `json:"merged_at"`
+	Head     struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+func (p *gitHubPullRequest) pullRequest() *PullRequest {
+	state := PullRequestState(p.State)
+	if p.MergedAt != nil {
+		state = PullRequestMerged
+	}
+	return &PullRequest{
+		Number: p.Number,
+		URL:    p.HTMLURL,
+		State:  state,
+		Head:   p.Head.Ref,
+		Base:   p.Base.Ref,
+	}
+}
+
+// do sends the request to the API and decodes the response into res
+func (gh *GitHubForge) do(method, path string, body, res any) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, gh.apiURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if gh.token != "" {
+		req.Header.Set("Authorization", "Bearer "+gh.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := gh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("github api %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return json.Unmarshal(respBody, res)
+}
+
+// listPullRequests returns the pull requests of the head branch, the newest first
+func (gh *GitHubForge) listPullRequests(head, state string) ([]gitHubPullRequest, error) {
+	q := url.Values{
+		"head":      {gh.owner + ":" + head},
+		"state":     {state},
+		"sort":      {"created"},
+		"direction": {"desc"},
+	}
+	var prs []gitHubPullRequest
+	err := gh.do(http.MethodGet, fmt.Sprintf("/repos/%s/%s/pulls?%s", gh.owner, gh.name, q.Encode()), nil, &prs)
+	return prs, err
+}
+
+func (gh *GitHubForge) OpenPullRequest(head, base, title, body string) (*PullRequest, error) {
+	open, err := gh.listPullRequests(head, "open")
+	if err != nil {
+		return nil, err
+	}
+	if len(open) > 0 {
+		return open[0].pullRequest(), nil
+	}
+
+	var pr gitHubPullRequest
+	err = gh.do(http.MethodPost, fmt.Sprintf("/repos/%s/%s/pulls", gh.owner, gh.name), map[string]string{
+		"head":  head,
+		"base":  base,
+		"title": title,
+		"body":  body,
+	}, &pr)
+	if err != nil {
+		return nil, err
+	}
+	return pr.pullRequest(), nil
+}
+
+func (gh *GitHubForge) FindPullRequest(head string) (*PullRequest, error) {
+	prs, err := gh.listPullRequests(head, "all")
+	if err != nil {
+		return nil, err
+	}
+	if len(prs) == 0 {
+		return nil, nil
+	}
+	return prs[0].pullRequest(), nil
+}
@@ -0,0 +1,95 @@
+package repo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewGitHubForge(t *testing.T) {
+	for _, url := range []string{
+		"https://github.com/mput/ledger.git",
+		"https://github.com/mput/ledger",
+		"git@github.com:mput/ledger.git",
+		"ssh://git@github.com/mput/ledger.git",
+	} {
+		gh, err := NewGitHubForge(url, "")
+		require.NoError(t, err, url)
+		assert.Equal(t, "mput", gh.owner, url)
+		assert.Equal(t, "ledger", gh.name, url)
+	}
+
+	_, err := NewGitHubForge("ledger", "")
+	assert.Error(t, err)
+}
+
+func TestGitHubForge(t *testing.T) {
+	var created map[string]string
+	pulls := []map[string]any{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		assert.Equal(t, "/repos/mput/ledger/pulls", r.URL.Path)
+		switch r.Method {
+		case http.MethodGet:
+			assert.Equal(t, "mput:teledger/2024-02-14", r.URL.Query().Get("head"))
+			var res []map[string]any
+			for _, p := range pulls {
+				if r.URL.Query().Get("state") == "all" || p["state"] == r.URL.Query().Get("state") {
+					res = append(res, p)
+				}
+			}
+			_ = json.NewEncoder(w).Encode(res)
+		case http.MethodPost:
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+			p := map[string]any{
+				"number":    7,
+				"html_url":  "https://github.com/mput/ledger/pull/7",
+				"state":     "open",
+				"merged_at": nil,
+				"head":      map[string]any{"ref": created["head"]},
+				"base":      map[string]any{"ref": created["base"]},
+			}
+			pulls = append(pulls, p)
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(p)
+		}
+	}))
+	defer srv.Close()
+
+	gh, err := NewGitHubForge("https://github.com/mput/ledger.git", "secret")
+	require.NoError(t, err)
+	gh.WithAPIURL(srv.URL + "/")
+
+	found, err := gh.FindPullRequest("teledger/2024-02-14")
+	require.NoError(t, err)
+	assert.Nil(t, found)
+
+	opened, err := gh.OpenPullRequest("teledger/2024-02-14", "main", "teledger: 2024-02-14", "body")
+	require.NoError(t, err)
+	expected := &PullRequest{
+		Number: 7,
+		URL:    "https://github.com/mput/ledger/pull/7",
+		State:  PullRequestOpen,
+		Head:   "teledger/2024-02-14",
+		Base:   "main",
+	}
+	assert.Equal(t, expected, opened)
+	assert.Equal(t, map[string]string{"head": "teledger/2024-02-14", "base": "main", "title": "teledger: 2024-02-14", "body": "body"}, created)
+
+	// the open pull request is reused
+	created = nil
+	opened, err = gh.OpenPullRequest("teledger/2024-02-14", "main", "teledger: 2024-02-14", "body")
+	require.NoError(t, err)
+	assert.Equal(t, expected, opened)
+	assert.Nil(t, created)
+
+	pulls[0]["state"] = "closed"
+	pulls[0]["merged_at"] = "2024-02-15T10:00:00Z"
+	found, err = gh.FindPullRequest("teledger/2024-02-14")
+	require.NoError(t, err)
+	assert.Equal(t, PullRequestMerged, found.State)
+}
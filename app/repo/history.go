@@ -0,0 +1,367 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Committer of all the commits made by teledger,
+// the author is the user who made the change
+const (
+	CommitterName  = "teledger"
+	CommitterEmail = "teledger@example.com"
+)
+
+// TransactionTrailer marks the commits of the ledger changes made by teledger,
+// e.g. `Teledger-Transaction: 0123456789abcdef`. Only the marked commits may be
+// undone, the other commits of teledger (e.g. of the pending transactions) aren't.
+const TransactionTrailer = "Teledger-Transaction"
+
+// noTransaction is the trailer value of the changes not bound to a transaction,
+// e.g. of the comments
+const noTransaction = "none"
+
+// TransactionMessage appends the trailer with the id of the changed transaction
+// to the commit message, the id may be empty
+func TransactionMessage(msg, id string) string {
+	if id == "" {
+		id = noTransaction
+	}
+	return fmt.Sprintf("%s\n\n%s: %s", msg, TransactionTrailer, id)
+}
+
+// parseTransactionMessage splits the commit message into the message itself
+// and the transaction id of the trailer, ok is false if there is no trailer
+func parseTransactionMessage(msg string) (message, id string, ok bool) {
+	msg = strings.TrimSpace(msg)
+	message, trailer := "", msg
+	if i := strings.LastIndex(msg, "\n"); i >= 0 {
+		message, trailer = strings.TrimSpace(msg[:i]), msg[i+1:]
+	}
+	id, ok = strings.CutPrefix(trailer, TransactionTrailer+":")
+	if !ok {
+		return msg, "", false
+	}
+	id = strings.TrimSpace(id)
+	if id == noTransaction {
+		id = ""
+	}
+	return message, id, true
+}
+
+// ErrRevertConflict means the changes of the commit
+// have been changed by the following commits
+var ErrRevertConflict = errors.New("the changes have been modified since, unable to revert them")
+
+// Change is a commit in the history of the repo
+type Change struct {
+	Hash    string
+	Message string
+	Author  string
+	When    time.Time
+	// Teledger is set for the ledger changes made by teledger,
+	// i.e. the commits with TransactionTrailer
+	Teledger bool
+	// TransactionID is the id of the changed transaction, if any
+	TransactionID string
+}
+
+// Subject returns the first line of the commit message
+func (c Change) Subject() string {
+	subject, _, _ := strings.Cut(c.Message, "\n")
+	return subject
+}
+
+// History is implemented by the services keeping the history of the changes
+type History interface {
+	// Log returns the last commits of the current branch, the newest first
+	Log(limit int) ([]Change, error)
+	// Revert reverts the changes of the commit in the worktree,
+	// they're committed by CommitPush
+	Revert(hash string) error
+}
+
+// RevertMessage returns the message of the commit reverting the change
+func RevertMessage(c Change) string {
+	return fmt.Sprintf("Revert \"%s\"\n\nThis reverts commit %s.", c.Subject(), c.Hash)
+}
+
+func newChange(c *object.Commit) Change {
+	msg, id, ok := parseTransactionMessage(c.Message)
+	return Change{
+		Hash:          c.Hash.String(),
+		Message:       msg,
+		Author:        c.Author.Name,
+		When:          c.Author.When,
+		Teledger:      ok,
+		TransactionID: id,
+	}
+}
+
+// gitLog returns the last commits of HEAD, the history of
+// a shallow clone ends on the first missing commit
+func gitLog(r *git.Repository, limit int) ([]Change, error) {
+	head, err := r.Head()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get head: %v", err)
+	}
+	var res []Change
+	c, err := r.CommitObject(head.Hash())
+	for err == nil && len(res) < limit {
+		res = append(res, newChange(c))
+		if c.NumParents() == 0 {
+			break
+		}
+		c, err = c.Parent(0)
+	}
+	if err != nil && !errors.Is(err, plumbing.ErrObjectNotFound) {
+		return nil, fmt.Errorf("unable to read history: %v", err)
+	}
+	return res, nil
+}
+
+// worktree is the files the reverted changes are written to
+type worktree interface {
+	OpenFile(file string, flag int, perm os.FileMode) (billy.File, error)
+}
+
+// gitRevert reverts the changes of the commit in the worktree,
+// returns the removed files
+func gitRevert(r *git.Repository, hash string, wt worktree) (removed []string, err error) {
+	c, err := r.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return nil, fmt.Errorf("commit %s not found: %v", hash, err)
+	}
+	if c.NumParents() != 1 {
+		return nil, fmt.Errorf("only commits with a single parent can be reverted, %s has %d", hash, c.NumParents())
+	}
+	parent, err := c.Parent(0)
+	if err != nil {
+		return nil, fmt.Errorf("the history is too short to revert %s: %v", hash, err)
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := parentTree.Diff(tree)
+	if err != nil {
+		return nil, fmt.Errorf("unable to diff %s: %v", hash, err)
+	}
+
+	for _, ch := range changes {
+		action, err := ch.Action()
+		if err != nil {
+			return nil, err
+		}
+		from, to, err := ch.Files()
+		if err != nil {
+			return nil, err
+		}
+		switch action {
+		case merkletrie.Insert:
+			// the file has been added, it's removed if it's not changed since
+			err = revertFile(wt, to.Name, fileContents(to), "", true)
+			removed = append(removed, to.Name)
+		case merkletrie.Delete:
+			err = revertFile(wt, from.Name, "", fileContents(from), false)
+		default:
+			if from.Name != to.Name {
+				return nil, fmt.Errorf("unable to revert renaming of %s to %s", from.Name, to.Name)
+			}
+			err = revertFile(wt, to.Name, fileContents(to), fileContents(from), false)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return removed, nil
+}
+
+func fileContents(f *object.File) string {
+	s, err := f.Contents()
+	if err != nil {
+		return ""
+	}
+	return s
+}
+
+// revertFile replaces the changed content of the file with the parent one,
+// the changes made after are kept.
+// The file is truncated if remove is set, it's removed by the caller.
+func revertFile(wt worktree, name, changed, parent string, remove bool) error {
+	current, exists, err := readWorktreeFile(wt, name)
+	if err != nil {
+		return err
+	}
+	var content string
+	switch {
+	case !exists && changed == "":
+		// the file has been deleted and isn't restored yet
+		content = parent
+	case !exists:
+		return fmt.Errorf("%w: %s has been removed", ErrRevertConflict, name)
+	default:
+		content, err = revertContent(changed, parent, current)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, name)
+		}
+	}
+	if remove && content != "" {
+		return fmt.Errorf("%w: %s", ErrRevertConflict, name)
+	}
+
+	f, err := wt.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %v", name, err)
+	}
+	_, err = f.Write([]byte(content))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %v", name, err)
+	}
+	return nil
+}
+
+func readWorktreeFile(wt worktree, name string) (content string, exists bool, err error) {
+	f, err := wt.OpenFile(name, os.O_RDONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("unable to open %s: %v", name, err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return "", false, fmt.Errorf("unable to read %s: %v", name, err)
+	}
+	return string(b), true, nil
+}
+
+// revertContent reverts the changes made from the parent to the changed content
+// in the current one, like `git revert`. The lines of every change must be
+// unchanged in the current content, the lines around them may be changed,
+// so a transaction is reverted even if another one is added after it.
+func revertContent(changed, parent, current string) (string, error) {
+	if current == changed {
+		return parent, nil
+	}
+	// the positions of the changed lines in the current content, -1 if removed
+	pos := make([]int, 0)
+	j := 0
+	for _, d := range lineDiff(changed, current) {
+		n := len(splitLines(d.Text))
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			for k := 0; k < n; k++ {
+				pos = append(pos, j)
+				j++
+			}
+		case diffmatchpatch.DiffDelete:
+			for k := 0; k < n; k++ {
+				pos = append(pos, -1)
+			}
+		case diffmatchpatch.DiffInsert:
+			j += n
+		}
+	}
+
+	lines := splitLines(current)
+	hunks := revertHunks(changed, parent)
+	// from the end, so the positions of the previous hunks stay valid
+	for k := len(hunks) - 1; k >= 0; k-- {
+		h := hunks[k]
+		var start int
+		if h.start < h.end {
+			start = pos[h.start]
+			for i := h.start; i < h.end; i++ {
+				if pos[i] < 0 || pos[i] != start+i-h.start {
+					return "", ErrRevertConflict
+				}
+			}
+		} else {
+			// the lines are inserted between the unchanged ones
+			if h.start > 0 {
+				start = pos[h.start-1] + 1
+				if start == 0 || (h.start < len(pos) && pos[h.start] != start) {
+					return "", ErrRevertConflict
+				}
+			} else if len(pos) > 0 && pos[0] != 0 {
+				return "", ErrRevertConflict
+			}
+		}
+		end := start + h.end - h.start
+		res := append([]string{}, lines[:start]...)
+		res = append(res, h.lines...)
+		lines = append(res, lines[end:]...)
+	}
+	return strings.Join(lines, ""), nil
+}
+
+// revertHunk replaces the lines [start, end) of the changed content with the parent ones
+type revertHunk struct {
+	start, end int
+	lines      []string
+}
+
+func revertHunks(changed, parent string) []revertHunk {
+	var hunks []revertHunk
+	var h *revertHunk
+	i := 0
+	for _, d := range lineDiff(changed, parent) {
+		lines := splitLines(d.Text)
+		if d.Type == diffmatchpatch.DiffEqual {
+			if h != nil {
+				hunks = append(hunks, *h)
+				h = nil
+			}
+			i += len(lines)
+			continue
+		}
+		if h == nil {
+			h = &revertHunk{start: i, end: i}
+		}
+		if d.Type == diffmatchpatch.DiffDelete {
+			i += len(lines)
+			h.end = i
+		} else {
+			h.lines = append(h.lines, lines...)
+		}
+	}
+	if h != nil {
+		hunks = append(hunks, *h)
+	}
+	return hunks
+}
+
+func lineDiff(a, b string) []diffmatchpatch.Diff {
+	dmp := diffmatchpatch.New()
+	ca, cb, lines := dmp.DiffLinesToChars(a, b)
+	return dmp.DiffCharsToLines(dmp.DiffMain(ca, cb, false), lines)
+}
+
+// splitLines splits the text into the lines with the line endings
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
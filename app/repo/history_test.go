@@ -0,0 +1,162 @@
+package repo
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func recentChanges(t *testing.T, r *InMemoryRepo) []Change {
+	t.Helper()
+	require.NoError(t, r.Init())
+	defer r.Free()
+	log, err := r.Log(10)
+	require.NoError(t, err)
+	return log
+}
+
+func revertPush(t *testing.T, r *InMemoryRepo, c Change) error {
+	t.Helper()
+	require.NoError(t, r.Init())
+	defer r.Free()
+	err := r.Revert(c.Hash)
+	if err != nil {
+		return err
+	}
+	return r.CommitPush(TransactionMessage(RevertMessage(c), c.TransactionID), "john", "john@example.com")
+}
+
+func TestInMemoryRepo_Revert(t *testing.T) {
+	remote := newLocalRemote(t, map[string]string{"main.ledger": ";; main\n"})
+	// the shallow clone is deepened for the history
+	r := NewInMemoryRepo(remote, Auth{})
+
+	appendAndPush(t, r, ";; first")
+	appendAndPush(t, r, ";; second")
+
+	log := recentChanges(t, r)
+	require.Len(t, log, 3)
+	assert.Equal(t, "test commit", log[0].Subject())
+	assert.Equal(t, "teledger", log[0].Author)
+	assert.True(t, log[0].Teledger)
+	assert.True(t, log[1].Teledger)
+	// the initial commit isn't made by teledger
+	assert.Equal(t, "initial commit", log[2].Subject())
+	assert.False(t, log[2].Teledger)
+
+	t.Run("the later changes are kept", func(t *testing.T) {
+		require.NoError(t, revertPush(t, r, log[1]))
+		assert.Equal(t, ";; main\n;; second\n", readFile(t, r, "main.ledger"))
+
+		head := recentChanges(t, r)[0]
+		assert.Equal(t, "Revert \"test commit\"", head.Subject())
+		assert.Equal(t, RevertMessage(log[1]), head.Message)
+		assert.Equal(t, "john", head.Author)
+		assert.True(t, head.Teledger)
+	})
+
+	t.Run("added file is removed", func(t *testing.T) {
+		require.NoError(t, r.Init())
+		f, err := r.OpenFile("new.ledger", os.O_CREATE|os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(";; new\n"))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.NoError(t, r.CommitPush("add new file", "teledger", "teledger@example.com"))
+		r.Free()
+		// committed by teledger, but not marked as a change of the ledger
+		assert.False(t, recentChanges(t, r)[0].Teledger)
+
+		require.NoError(t, revertPush(t, r, recentChanges(t, r)[0]))
+		require.NoError(t, r.Init())
+		defer r.Free()
+		_, err = r.Open("new.ledger")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		assert.Equal(t, ";; main\n;; second\n", checkReadString(t, r, "main.ledger"))
+	})
+
+	t.Run("unknown commit", func(t *testing.T) {
+		err := revertPush(t, r, Change{Hash: "0123456789abcdef0123456789abcdef01234567"})
+		assert.ErrorContains(t, err, "not found")
+	})
+
+	t.Run("shallow clone is deepened", func(t *testing.T) {
+		remote := newLocalRemote(t, map[string]string{"main.ledger": ";; main\n"})
+		appendAndPush(t, NewInMemoryRepo(remote, Auth{}), ";; first")
+		appendAndPush(t, NewInMemoryRepo(remote, Auth{}), ";; second")
+
+		log := recentChanges(t, NewInMemoryRepo(remote, Auth{}))
+		require.Len(t, log, 3)
+		assert.Equal(t, "initial commit", log[2].Subject())
+
+		// without listing the changes first
+		r := NewInMemoryRepo(remote, Auth{})
+		require.NoError(t, revertPush(t, r, log[1]))
+		assert.Equal(t, ";; main\n;; second\n", readFile(t, r, "main.ledger"))
+	})
+}
+
+func TestRevertContent(t *testing.T) {
+	tests := []struct {
+		name                     string
+		changed, parent, current string
+		want                     string
+		wantErr                  error
+	}{
+		{
+			name:    "not changed since",
+			changed: "a\nb\n",
+			parent:  "a\n",
+			current: "a\nb\n",
+			want:    "a\n",
+		},
+		{
+			name:    "changed after",
+			changed: "a\nb\n",
+			parent:  "a\n",
+			current: "a\nb\nc\n",
+			want:    "a\nc\n",
+		},
+		{
+			name:    "changed before",
+			changed: "a\nb\nc\n",
+			parent:  "a\nc\n",
+			current: "z\na\nb\nc\n",
+			want:    "z\na\nc\n",
+		},
+		{
+			name:    "removed line is restored",
+			changed: "a\nc\n",
+			parent:  "a\nb\nc\n",
+			current: "a\nc\nd\n",
+			want:    "a\nb\nc\nd\n",
+		},
+		{
+			name:    "lines around the removed one are changed",
+			changed: "a\nc\n",
+			parent:  "a\nb\nc\n",
+			current: "a\nx\nc\n",
+			wantErr: ErrRevertConflict,
+		},
+		{
+			name:    "reverted line is changed",
+			changed: "a\nb\n",
+			parent:  "a\n",
+			current: "a\nB\n",
+			wantErr: ErrRevertConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := revertContent(tt.changed, tt.parent, tt.current)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// LockFile is created in the directory of LocalRepo to lock it
+// between the processes, e.g. add it to .stignore of Syncthing
+const LockFile = ".teledger.lock"
+
+// LocalRepo works with the files of a local directory, e.g. synced with Syncthing.
+// Init takes a file lock on the directory, so several instances may share it.
+// CommitPush syncs the changed files to the disk and commits them
+// if the directory is a git repo and committing is enabled, nothing is pushed.
+// The changes are reverted by Free if CommitPush is not called.
+type LocalRepo struct {
+	dir    string
+	commit bool
+	fs     billy.Filesystem
+	// mu locks the repo in the process, the file lock between the processes.
+	// The snapshots are copied under the shared locks.
+	mu   sync.RWMutex
+	lock *os.File
+	// backups keeps the original content of the changed files,
+	// nil if the file didn't exist
+	backups map[string][]byte
+	inited  bool
+	// signer of the local commits, they're not signed if nil
+	signer Signer
+	// snap is the last snapshot, it's used until the files are changed
+	snap   *Snapshot
+	snapMu sync.Mutex
+}
+
+// NewLocalRepo creates the repo of the directory,
+// the changes are committed to the local git repo if commit is set
+func NewLocalRepo(dir string, commit bool) *LocalRepo {
+	return &LocalRepo{
+		dir:    dir,
+		commit: commit,
+		fs:     osfs.New(dir),
+	}
+}
+
+// WithSigner makes the repo sign the local commits
+func (lr *LocalRepo) WithSigner(signer Signer) *LocalRepo {
+	lr.signer = signer
+	return lr
+}
+
+func (lr *LocalRepo) Init() error {
+	lr.mu.Lock()
+	f, err := lr.lockDir(lockFile)
+	if err != nil {
+		return fmt.Errorf("init error: %v", err)
+	}
+	lr.lock = f
+	lr.backups = make(map[string][]byte)
+	lr.inited = true
+	return nil
+}
+
+// lockDir opens the lock file of the directory and locks it
+func (lr *LocalRepo) lockDir(lock func(f *os.File) error) (*os.File, error) {
+	info, err := os.Stat(lr.dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", lr.dir)
+	}
+
+	f, err := os.OpenFile(filepath.Join(lr.dir, LockFile), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open lock file: %v", err)
+	}
+	err = lock(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to lock %s: %v", lr.dir, err)
+	}
+	return f, nil
+}
+
+func (lr *LocalRepo) Free() {
+	if lr.inited {
+		lr.restore()
+	}
+	if lr.lock != nil {
+		if err := unlockFile(lr.lock); err != nil {
+			slog.Error("unable to unlock", "dir", lr.dir, "error", err)
+		}
+		lr.lock.Close()
+		lr.lock = nil
+	}
+	lr.inited = false
+	lr.mu.Unlock()
+}
+
+// restore reverts the changes which haven't been committed
+func (lr *LocalRepo) restore() {
+	for file, content := range lr.backups {
+		var err error
+		if content == nil {
+			err = lr.fs.Remove(file)
+		} else {
+			err = writeFile(lr.fs, file, content)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("unable to revert file", "file", file, "error", err)
+			continue
+		}
+		slog.Debug("uncommitted changes reverted", "file", file)
+	}
+	lr.backups = nil
+}
+
+func writeFile(fs billy.Filesystem, file string, content []byte) error {
+	f, err := fs.OpenFile(file, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// backup remembers the content of the file before the first change
+func (lr *LocalRepo) backup(file string) error {
+	file = filepath.Clean(file)
+	if _, ok := lr.backups[file]; ok {
+		return nil
+	}
+	f, err := lr.fs.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		lr.backups[file] = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if content == nil {
+		content = []byte{}
+	}
+	lr.backups[file] = content
+	return nil
+}
+
+func (lr *LocalRepo) OpenFile(file string, flag int, perm os.FileMode) (billy.File, error) {
+	if !lr.inited {
+		return nil, fmt.Errorf("not initialized")
+	}
+	if flag&writeFlags != 0 {
+		err := lr.backup(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to backup %s: %v", file, err)
+		}
+	}
+	return lr.fs.OpenFile(file, flag, perm)
+}
+
+func (lr *LocalRepo) Open(file string) (billy.File, error) {
+	return lr.OpenFile(file, os.O_RDONLY, 0)
+}
+
+func (lr *LocalRepo) OpenForAppend(file string) (billy.File, error) {
+	return lr.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+}
+
+// Snapshot copies the files of the directory under the shared lock, so the
+// readers don't wait for each other. The copy is used until the files are changed,
+// they're compared by the size and the modification time, as they may be
+// changed by other processes.
+func (lr *LocalRepo) Snapshot() (*Snapshot, error) {
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+	lock, err := lr.lockDir(rlockFile)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot error: %v", err)
+	}
+	defer func() {
+		if err := unlockFile(lock); err != nil {
+			slog.Error("unable to unlock", "dir", lr.dir, "error", err)
+		}
+		lock.Close()
+	}()
+
+	lr.snapMu.Lock()
+	defer lr.snapMu.Unlock()
+
+	var files []string
+	h := sha256.New()
+	err = filepath.WalkDir(lr.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == git.GitDirName {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() || d.Name() == LockFile {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(lr.dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", rel, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list files of %s: %v", lr.dir, err)
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	if lr.snap != nil && lr.snap.Hash == hash {
+		return lr.snap, nil
+	}
+
+	snapfs := memfs.New()
+	for _, file := range files {
+		content, rerr := os.ReadFile(filepath.Join(lr.dir, file))
+		if rerr != nil {
+			return nil, fmt.Errorf("unable to read %s: %v", file, rerr)
+		}
+		err = util.WriteFile(snapfs, filepath.ToSlash(file), content, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("unable to copy %s: %v", file, err)
+		}
+	}
+	lr.snap = &Snapshot{Hash: hash, fs: snapfs}
+	slog.Debug("snapshot copied", "hash", hash, "dir", lr.dir, "files", len(files))
+	return lr.snap, nil
+}
+
+// changed returns the files opened for writing, sorted
+func (lr *LocalRepo) changed() []string {
+	files := make([]string, 0, len(lr.backups))
+	for file := range lr.backups {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Log returns the last commits if the directory is a git repo
+func (lr *LocalRepo) Log(limit int) ([]Change, error) {
+	if !lr.inited {
+		return nil, fmt.Errorf("not initialized")
+	}
+	r, err := git.PlainOpen(lr.dir)
+	if err != nil {
+		return nil, fmt.Errorf("no history, unable to open git repo in %s: %v", lr.dir, err)
+	}
+	return gitLog(r, limit)
+}
+
+// Revert reverts the changes of the commit if the directory is a git repo
+func (lr *LocalRepo) Revert(hash string) error {
+	if !lr.inited {
+		return fmt.Errorf("not initialized")
+	}
+	r, err := git.PlainOpen(lr.dir)
+	if err != nil {
+		return fmt.Errorf("no history, unable to open git repo in %s: %v", lr.dir, err)
+	}
+	removed, err := gitRevert(r, hash, lr)
+	if err != nil {
+		return err
+	}
+	for _, file := range removed {
+		err = lr.fs.Remove(file)
+		if err != nil {
+			return fmt.Errorf("unable to remove %s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+// CommitPush syncs the changes to the disk and commits them to the local git repo
+func (lr *LocalRepo) CommitPush(msg, name, email string) error {
+	if !lr.inited {
+		return fmt.Errorf("not initialized")
+	}
+	files := lr.changed()
+	for _, file := range files {
+		err := syncFile(filepath.Join(lr.dir, file))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("unable to sync %s: %v", file, err)
+		}
+	}
+	err := syncFile(lr.dir)
+	if err != nil {
+		return fmt.Errorf("unable to sync %s: %v", lr.dir, err)
+	}
+	// the changes are on the disk, they are kept even if the commit fails
+	lr.backups = make(map[string][]byte)
+	lr.snap = nil
+
+	if !lr.commit || len(files) == 0 {
+		return nil
+	}
+	return lr.gitCommit(files, msg, name, email)
+}
+
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// gitCommit commits the files if the directory is a git repo
+func (lr *LocalRepo) gitCommit(files []string, msg, name, email string) error {
+	r, err := git.PlainOpen(lr.dir)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		slog.Debug("not a git repo, changes are not committed", "dir", lr.dir)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to open git repo: %v", err)
+	}
+	wtr, err := r.Worktree()
+	if err != nil {
+		return fmt.Errorf("worktree receiving error: %v", err)
+	}
+	for _, file := range files {
+		file = filepath.ToSlash(file)
+		_, err = lr.fs.Stat(file)
+		if errors.Is(err, os.ErrNotExist) {
+			// the removal of a tracked file
+			_, err = wtr.Remove(file)
+			if err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+				return fmt.Errorf("error while removing file %s: %v", file, err)
+			}
+			continue
+		}
+		_, err = wtr.Add(file)
+		if err != nil {
+			return fmt.Errorf("error while adding file %s: %v", file, err)
+		}
+	}
+	now := time.Now()
+	_, err = wtr.Commit(msg, &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  name,
+			Email: email,
+			When:  now,
+		},
+		Committer: &object.Signature{
+			Name:  CommitterName,
+			Email: CommitterEmail,
+			When:  now,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error while committing: %v", err)
+	}
+	if lr.signer != nil {
+		err = signHead(r, lr.signer)
+		if err != nil {
+			return fmt.Errorf("error while signing: %v", err)
+		}
+	}
+	return nil
+}
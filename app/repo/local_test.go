@@ -0,0 +1,147 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLocalDir(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.ledger"), []byte(content), 0o600))
+	return dir
+}
+
+func TestLocalRepo(t *testing.T) {
+	dir := newLocalDir(t, ";; main\n")
+	lr := NewLocalRepo(dir, true)
+
+	t.Run("changes are written", func(t *testing.T) {
+		appendAndPush(t, lr, ";; first")
+		content, err := os.ReadFile(filepath.Join(dir, "main.ledger"))
+		require.NoError(t, err)
+		assert.Equal(t, ";; main\n;; first\n", string(content))
+		_, err = os.Stat(filepath.Join(dir, ".git"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("uncommitted changes are reverted", func(t *testing.T) {
+		require.NoError(t, lr.Init())
+		w, err := lr.OpenForAppend("main.ledger")
+		require.NoError(t, err)
+		_, err = fmt.Fprintln(w, ";; not committed")
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		w, err = lr.OpenFile("new.ledger", os.O_CREATE|os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		lr.Free()
+
+		assert.Equal(t, ";; main\n;; first\n", readFile(t, lr, "main.ledger"))
+		_, err = os.Stat(filepath.Join(dir, "new.ledger"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("init is locked between instances", func(t *testing.T) {
+		other := NewLocalRepo(dir, false)
+		require.NoError(t, lr.Init())
+		inited := make(chan struct{})
+		go func() {
+			assert.NoError(t, other.Init())
+			close(inited)
+			other.Free()
+		}()
+
+		select {
+		case <-inited:
+			t.Fatal("the directory is not locked")
+		case <-time.After(100 * time.Millisecond):
+		}
+		lr.Free()
+		select {
+		case <-inited:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the lock is not released")
+		}
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		missing := NewLocalRepo(filepath.Join(dir, "missing"), false)
+		err := missing.Init()
+		missing.Free()
+		assert.ErrorContains(t, err, "init error")
+	})
+}
+
+func TestLocalRepo_GitCommit(t *testing.T) {
+	dir := newLocalDir(t, ";; main\n")
+	r, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+
+	appendAndPush(t, NewLocalRepo(dir, true), ";; committed")
+	appendAndPush(t, NewLocalRepo(dir, false), ";; not committed")
+
+	head, err := r.Head()
+	require.NoError(t, err)
+	commit, err := r.CommitObject(head.Hash())
+	require.NoError(t, err)
+	assert.Equal(t, TransactionMessage("test commit", ""), commit.Message)
+	assert.Equal(t, "teledger", commit.Author.Name)
+	assert.Equal(t, 0, commit.NumParents())
+
+	file, err := commit.File("main.ledger")
+	require.NoError(t, err)
+	content, err := file.Contents()
+	require.NoError(t, err)
+	assert.Equal(t, ";; main\n;; committed\n", content)
+
+	// the lock file is not committed
+	_, err = commit.File(LockFile)
+	assert.ErrorIs(t, err, object.ErrFileNotFound)
+
+	content2, err := os.ReadFile(filepath.Join(dir, "main.ledger"))
+	require.NoError(t, err)
+	assert.Equal(t, ";; main\n;; committed\n;; not committed\n", string(content2))
+}
+
+func TestLocalRepo_Revert(t *testing.T) {
+	dir := newLocalDir(t, ";; main\n")
+	_, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+
+	lr := NewLocalRepo(dir, true)
+	appendAndPush(t, lr, ";; initial")
+	appendAndPush(t, lr, ";; first")
+	appendAndPush(t, lr, ";; second")
+
+	require.NoError(t, lr.Init())
+	log, err := lr.Log(10)
+	require.NoError(t, err)
+	require.Len(t, log, 3)
+	assert.True(t, log[1].Teledger)
+	require.NoError(t, lr.Revert(log[1].Hash))
+	require.NoError(t, lr.CommitPush(RevertMessage(log[1]), "john", "john@example.com"))
+	log, err = lr.Log(10)
+	require.NoError(t, err)
+	lr.Free()
+
+	assert.Equal(t, ";; main\n;; initial\n;; second\n", readFile(t, lr, "main.ledger"))
+	require.Len(t, log, 4)
+	assert.Equal(t, "Revert \"test commit\"", log[0].Subject())
+
+	t.Run("not a git repo", func(t *testing.T) {
+		lr := NewLocalRepo(newLocalDir(t, ";; main\n"), true)
+		require.NoError(t, lr.Init())
+		defer lr.Free()
+		_, err := lr.Log(10)
+		assert.ErrorContains(t, err, "no history")
+	})
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package repo
+
+import (
+	"errors"
+	"os"
+)
+
+func lockFile(_ *os.File) error {
+	return errors.New("file locks are not supported on this platform")
+}
+
+func rlockFile(_ *os.File) error {
+	return errors.New("file locks are not supported on this platform")
+}
+
+func unlockFile(_ *os.File) error {
+	return nil
+}
@@ -4,21 +4,49 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
 )
 
+// Mock is an in-memory repo.Service, Files is the content
+// of the repo after the last CommitPush.
+// As with InMemoryRepo, Init blocks until the previous user calls Free,
+// Snapshot doesn't.
 type Mock struct {
-	Files  map[string]string
-	fs     billy.Filesystem
-	inited bool
+	Files map[string]string
+	// Push is called by CommitPush with the files of the last commit,
+	// the files are not saved if it returns an error,
+	// e.g. to simulate a change of the remote and the rejected push
+	Push func(files map[string]string) error
+	// Commits are the commits made by CommitPush
+	Commits []Commit
+	// history of the commits with the content of the files, for Log and Revert
+	history []mockCommit
+	fs      billy.Filesystem
+	inited  bool
+	mu      sync.Mutex
+	// filesMu guards the replacement of the Files and the version, for Snapshot
+	filesMu sync.Mutex
+	version int
+}
+
+// Commit is a commit made with the Mock
+type Commit struct {
+	Msg, Name, Email string
+}
+
+type mockCommit struct {
+	change        Change
+	parent, files map[string]string
 }
 
 func (r *Mock) Init() error {
-	if r.inited {
-		return fmt.Errorf("already initialized")
-	}
+	r.mu.Lock()
 	r.fs = memfs.New()
 	for fname, content := range r.Files {
 		f, err := r.fs.Create(fname)
@@ -40,10 +68,8 @@ func (r *Mock) Init() error {
 }
 
 func (r *Mock) Free() {
-	if !r.inited {
-		panic("not initialized")
-	}
 	r.inited = false
+	r.mu.Unlock()
 }
 
 func (r *Mock) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
@@ -61,8 +87,26 @@ func (r *Mock) OpenForAppend(filename string) (billy.File, error) {
 	return r.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0)
 }
 
-func (r *Mock) CommitPush(_, _, _ string) error {
-	for fname := range r.Files {
+func (r *Mock) CommitPush(msg, name, email string) error {
+	r.filesMu.Lock()
+	if r.Files == nil {
+		r.Files = make(map[string]string)
+	}
+	r.filesMu.Unlock()
+	if r.Push != nil {
+		if err := r.Push(r.Files); err != nil {
+			return err
+		}
+	}
+	r.Commits = append(r.Commits, Commit{Msg: msg, Name: name, Email: email})
+	parent := r.Files
+	files := make(map[string]string)
+	// walk the whole fs, so the files created after Init are also saved
+	err := util.Walk(r.fs, "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		fname := strings.TrimPrefix(path, "/")
 		f, err := r.fs.Open(fname)
 		if err != nil {
 			return err
@@ -72,13 +116,95 @@ func (r *Mock) CommitPush(_, _, _ string) error {
 			return err
 		}
 
-		r.Files[fname] = string(fc)
+		files[fname] = string(fc)
 
-		err = f.Close()
+		return f.Close()
+	})
+	if err != nil {
+		return err
+	}
+
+	r.filesMu.Lock()
+	r.Files = make(map[string]string, len(files))
+	for k, v := range files {
+		r.Files[k] = v
+	}
+	r.version++
+	r.filesMu.Unlock()
+	message, id, ok := parseTransactionMessage(msg)
+	r.history = append(r.history, mockCommit{
+		change: Change{
+			Hash:          fmt.Sprintf("%040x", len(r.history)+1),
+			Message:       message,
+			Author:        name,
+			When:          time.Now(),
+			Teledger:      ok,
+			TransactionID: id,
+		},
+		parent: parent,
+		files:  files,
+	})
+	return nil
+}
+
+// Snapshot copies the files of the last commit
+func (r *Mock) Snapshot() (*Snapshot, error) {
+	r.filesMu.Lock()
+	defer r.filesMu.Unlock()
+	fs := memfs.New()
+	for fname, content := range r.Files {
+		err := util.WriteFile(fs, fname, []byte(content), 0o644)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
+	return &Snapshot{Hash: fmt.Sprintf("%040x", r.version), fs: fs}, nil
+}
 
-	return nil
+func (r *Mock) Log(limit int) ([]Change, error) {
+	if !r.inited {
+		return nil, fmt.Errorf("not initialized")
+	}
+	var res []Change
+	for i := len(r.history) - 1; i >= 0 && len(res) < limit; i-- {
+		res = append(res, r.history[i].change)
+	}
+	return res, nil
+}
+
+func (r *Mock) Revert(hash string) error {
+	if !r.inited {
+		return fmt.Errorf("not initialized")
+	}
+	for _, c := range r.history {
+		if c.change.Hash != hash {
+			continue
+		}
+		for name, content := range c.files {
+			parent, ok := c.parent[name]
+			if ok && parent == content {
+				continue
+			}
+			err := revertFile(r, name, content, parent, !ok)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				err = r.fs.Remove(name)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for name, parent := range c.parent {
+			if _, ok := c.files[name]; !ok {
+				err := revertFile(r, name, "", parent, false)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("commit %s not found", hash)
 }
@@ -0,0 +1,191 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// errDiverged means the local clone can't be fast-forwarded to the remote
+var errDiverged = errors.New("local clone has diverged from the remote")
+
+// PersistentRepo keeps the clone between operations, on disk or in memory.
+// Init only fetches the changes and fast-forwards the clone,
+// the repo is cloned again if the local state has diverged from the remote.
+type PersistentRepo struct {
+	InMemoryRepo
+	// directory of the clone, the clone is kept in memory if empty
+	path string
+}
+
+// NewPersistentRepo creates a repo with the clone kept in the path,
+// or in memory if the path is empty
+func NewPersistentRepo(url string, auth Auth, path string) *PersistentRepo {
+	pr := &PersistentRepo{
+		InMemoryRepo: InMemoryRepo{
+			url:   url,
+			creds: auth,
+		},
+		path: path,
+	}
+	if path != "" {
+		pr.storage = pr.diskStorage
+	}
+	return pr
+}
+
+// WithBranch makes the repo work with the branch instead of the remote's default HEAD.
+// The branch is created from the default HEAD if it doesn't exist on the remote yet.
+func (pr *PersistentRepo) WithBranch(branch string) *PersistentRepo {
+	pr.branch = branch
+	return pr
+}
+
+// WithSigner makes the repo sign the commits
+func (pr *PersistentRepo) WithSigner(signer Signer) *PersistentRepo {
+	pr.signer = signer
+	return pr
+}
+
+// diskStorage removes the previous clone and returns the storage for a new one
+func (pr *PersistentRepo) diskStorage() (storage.Storer, billy.Filesystem, error) {
+	err := os.RemoveAll(pr.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = os.MkdirAll(pr.path, 0o700)
+	if err != nil {
+		return nil, nil, err
+	}
+	wt := osfs.New(pr.path)
+	dot := osfs.New(filepath.Join(pr.path, git.GitDirName))
+	return filesystem.NewStorage(dot, cache.NewObjectLRUDefault()), wt, nil
+}
+
+// open opens the clone left on disk by the previous run
+func (pr *PersistentRepo) open() *git.Repository {
+	if pr.path == "" {
+		return nil
+	}
+	r, err := git.PlainOpen(pr.path)
+	if err != nil {
+		if !errors.Is(err, git.ErrRepositoryNotExists) {
+			slog.Warn("unable to open local clone", "path", pr.path, "error", err)
+		}
+		return nil
+	}
+	return r
+}
+
+func (pr *PersistentRepo) Init() error {
+	pr.initedMu.Lock()
+
+	if pr.repo == nil {
+		pr.repo = pr.open()
+	}
+
+	var err error
+	if pr.repo != nil {
+		err = pr.fastForward()
+		if errors.Is(err, errDiverged) {
+			slog.Warn("cloning the repo again", "url", pr.url, "error", err)
+			pr.repo = nil
+		} else if err != nil {
+			return fmt.Errorf("init error, unable to update %s: %w", pr.url, err)
+		}
+	}
+
+	if pr.repo == nil {
+		var r *git.Repository
+		if pr.branch == "" {
+			r, err = pr.clone("")
+		} else {
+			r, err = pr.cloneBranch()
+		}
+		if err != nil {
+			return fmt.Errorf("init error, unable to clone %s: %w", pr.url, err)
+		}
+		pr.repo = r
+		slog.Info("repo cloned", "url", pr.url, "path", pr.path)
+	}
+
+	pr.dirtyFiles = make(map[string]bool)
+	pr.inited = true
+	return nil
+}
+
+// fastForward fetches the remote and moves the clone to the remote branch,
+// changes left by the previous operation are discarded
+func (pr *PersistentRepo) fastForward() error {
+	auth, err := pr.auth()
+	if err != nil {
+		return err
+	}
+	err = pr.repo.Fetch(&git.FetchOptions{
+		Auth:  auth,
+		Force: true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("unable to fetch: %w", unavailable(err))
+	}
+
+	head, err := pr.repo.Head()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errDiverged, err)
+	}
+	remoteRef, err := pr.repo.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, head.Name().Short()), true)
+	if err != nil {
+		// e.g. the branch has never been pushed
+		return fmt.Errorf("%w: %v", errDiverged, err)
+	}
+
+	if remoteRef.Hash() != head.Hash() {
+		local, err := pr.repo.CommitObject(head.Hash())
+		if err != nil {
+			return fmt.Errorf("%w: %v", errDiverged, err)
+		}
+		remote, err := pr.repo.CommitObject(remoteRef.Hash())
+		if err != nil {
+			return fmt.Errorf("%w: %v", errDiverged, err)
+		}
+		ok, err := local.IsAncestor(remote)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errDiverged, err)
+		}
+		if !ok {
+			return fmt.Errorf("%w: %s is not an ancestor of %s", errDiverged, local.Hash, remote.Hash)
+		}
+	}
+
+	wtr, err := pr.repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("worktree receiving error: %v", err)
+	}
+	err = wtr.Reset(&git.ResetOptions{Commit: remoteRef.Hash(), Mode: git.HardReset})
+	if err != nil {
+		return fmt.Errorf("%w: unable to reset: %v", errDiverged, err)
+	}
+	err = wtr.Clean(&git.CleanOptions{Dir: true})
+	if err != nil {
+		return fmt.Errorf("%w: unable to clean: %v", errDiverged, err)
+	}
+
+	slog.Debug("repo updated", "head", remoteRef.Hash(), "url", pr.url)
+	return nil
+}
+
+// Free releases the lock, the clone is kept for the next operation
+func (pr *PersistentRepo) Free() {
+	pr.inited = false
+	pr.initedMu.Unlock()
+}
@@ -0,0 +1,216 @@
+package repo
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendAndPush(t *testing.T, rs Service, line string) {
+	t.Helper()
+	require.NoError(t, rs.Init())
+	defer rs.Free()
+	w, err := rs.OpenForAppend("main.ledger")
+	require.NoError(t, err)
+	_, err = fmt.Fprintln(w, line)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, rs.CommitPush(TransactionMessage("test commit", ""), "teledger", "teledger@example.com"))
+}
+
+func readFile(t *testing.T, rs Service, name string) string {
+	t.Helper()
+	require.NoError(t, rs.Init())
+	defer rs.Free()
+	return checkReadString(t, rs, name)
+}
+
+// forcePush replaces the history of the remote with a single new commit
+// on top of the initial one
+func forcePush(t *testing.T, remote, content string) {
+	t.Helper()
+	work := t.TempDir()
+	r, err := git.PlainClone(work, false, &git.CloneOptions{URL: remote})
+	require.NoError(t, err)
+
+	commits, err := r.Log(&git.LogOptions{})
+	require.NoError(t, err)
+	var initial *object.Commit
+	require.NoError(t, commits.ForEach(func(c *object.Commit) error {
+		initial = c
+		return nil
+	}))
+
+	wtr, err := r.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, wtr.Reset(&git.ResetOptions{Commit: initial.Hash, Mode: git.HardReset}))
+	require.NoError(t, os.WriteFile(filepath.Join(work, "main.ledger"), []byte(content), 0o600))
+	_, err = wtr.Add("main.ledger")
+	require.NoError(t, err)
+	_, err = wtr.Commit("rewrite", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	require.NoError(t, r.Push(&git.PushOptions{Force: true}))
+}
+
+func TestPersistentRepo(t *testing.T) {
+	for name, path := range map[string]string{"memory": "", "disk": "clone"} {
+		t.Run(name, func(t *testing.T) {
+			remote := newLocalRemote(t, map[string]string{"main.ledger": ";; main\n"})
+			if path != "" {
+				path = filepath.Join(t.TempDir(), path)
+			}
+			pr := NewPersistentRepo(remote, Auth{}, path)
+
+			appendAndPush(t, pr, ";; first")
+			cloned := pr.repo
+
+			t.Run("clone is kept between operations", func(t *testing.T) {
+				assert.Equal(t, ";; main\n;; first\n", readFile(t, pr, "main.ledger"))
+				assert.Same(t, cloned, pr.repo)
+			})
+
+			t.Run("remote changes are fetched", func(t *testing.T) {
+				appendAndPush(t, NewInMemoryRepo(remote, Auth{}), ";; from laptop")
+				assert.Equal(t, ";; main\n;; first\n;; from laptop\n", readFile(t, pr, "main.ledger"))
+				assert.Same(t, cloned, pr.repo)
+			})
+
+			t.Run("uncommitted changes are discarded", func(t *testing.T) {
+				require.NoError(t, pr.Init())
+				w, err := pr.OpenForAppend("main.ledger")
+				require.NoError(t, err)
+				_, err = fmt.Fprintln(w, ";; not committed")
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+				w, err = pr.OpenFile("new.ledger", os.O_CREATE|os.O_WRONLY, 0o644)
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+				pr.Free()
+
+				assert.Equal(t, ";; main\n;; first\n;; from laptop\n", readFile(t, pr, "main.ledger"))
+				require.NoError(t, pr.Init())
+				_, err = pr.Open("new.ledger")
+				pr.Free()
+				assert.ErrorIs(t, err, os.ErrNotExist)
+			})
+
+			t.Run("diverged clone is cloned again", func(t *testing.T) {
+				forcePush(t, remote, ";; rewritten\n")
+				assert.Equal(t, ";; rewritten\n", readFile(t, pr, "main.ledger"))
+				assert.NotSame(t, cloned, pr.repo)
+
+				appendAndPush(t, pr, ";; after rewrite")
+				assert.Equal(t, ";; rewritten\n;; after rewrite\n", readFile(t, NewInMemoryRepo(remote, Auth{}), "main.ledger"))
+			})
+
+			if path != "" {
+				t.Run("clone on disk is reused after restart", func(t *testing.T) {
+					restarted := NewPersistentRepo(remote, Auth{}, path)
+					assert.Equal(t, ";; rewritten\n;; after rewrite\n", readFile(t, restarted, "main.ledger"))
+					_, err := os.Stat(filepath.Join(path, ".git"))
+					assert.NoError(t, err)
+				})
+			}
+		})
+	}
+}
+
+func TestPersistentRepo_WithBranch(t *testing.T) {
+	remote := newLocalRemote(t, map[string]string{"main.ledger": ";; main\n"})
+	pr := NewPersistentRepo(remote, Auth{}, "").WithBranch("pending")
+
+	appendAndPush(t, pr, ";; on branch")
+	appendAndPush(t, pr, ";; on branch again")
+
+	assert.Equal(t, ";; main\n;; on branch\n;; on branch again\n", readFile(t, NewInMemoryRepo(remote, Auth{}).WithBranch("pending"), "main.ledger"))
+	assert.Equal(t, ";; main\n", readFile(t, NewInMemoryRepo(remote, Auth{}), "main.ledger"))
+}
+
+func TestPersistentRepo_PushRejected(t *testing.T) {
+	remote := newLocalRemote(t, map[string]string{"main.ledger": ";; main\n"})
+	pr := NewPersistentRepo(remote, Auth{}, "")
+	require.NoError(t, pr.Init())
+	pr.Free()
+	cloned := pr.repo
+
+	require.NoError(t, pr.Init())
+	appendAndPush(t, NewInMemoryRepo(remote, Auth{}), ";; from laptop")
+	w, err := pr.OpenForAppend("main.ledger")
+	require.NoError(t, err)
+	_, err = fmt.Fprintln(w, ";; from bot")
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	err = pr.CommitPush("test commit", "teledger", "teledger@example.com")
+	pr.Free()
+	assert.ErrorIs(t, err, ErrPushRejected)
+
+	// the rejected commit is dropped, so the clone is fast-forwarded
+	appendAndPush(t, pr, ";; from bot")
+	assert.Same(t, cloned, pr.repo)
+	assert.Equal(t, ";; main\n;; from laptop\n;; from bot\n", readFile(t, NewInMemoryRepo(remote, Auth{}), "main.ledger"))
+}
+
+func TestPersistentRepo_Unavailable(t *testing.T) {
+	remote := newLocalRemote(t, map[string]string{"main.ledger": ";; main\n"})
+	url, offline := serveHTTP(t, remote)
+	pr := NewPersistentRepo(url, Auth{}, "")
+	appendAndPush(t, pr, ";; online")
+
+	offline.Store(true)
+	err := pr.Init()
+	pr.Free()
+	assert.ErrorIs(t, err, ErrUnavailable)
+
+	offline.Store(false)
+	appendAndPush(t, pr, ";; back online")
+	assert.Equal(t, ";; main\n;; online\n;; back online\n", readFile(t, NewInMemoryRepo(remote, Auth{}), "main.ledger"))
+
+	t.Run("declined push is reported", func(t *testing.T) {
+		hook := filepath.Join(remote, "hooks", "pre-receive")
+		require.NoError(t, os.MkdirAll(filepath.Dir(hook), 0o700))
+		require.NoError(t, os.WriteFile(hook, []byte("#!/bin/sh\necho 'protected branch' >&2\nexit 1\n"), 0o700)) //nolint:gosec
+		defer os.Remove(hook)
+
+		require.NoError(t, pr.Init())
+		defer pr.Free()
+		w, err := pr.OpenForAppend("main.ledger")
+		require.NoError(t, err)
+		_, err = fmt.Fprintln(w, ";; declined")
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		err = pr.CommitPush("test commit", "teledger", "teledger@example.com")
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrUnavailable)
+		assert.NotErrorIs(t, err, ErrPushRejected)
+	})
+
+	t.Run("server errors", func(t *testing.T) {
+		status := http.StatusUnauthorized
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+		}))
+		defer srv.Close()
+
+		pr := NewPersistentRepo(srv.URL+"/remote.git", Auth{}, "")
+		err := pr.Init()
+		pr.Free()
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrUnavailable, "refused credentials are reported")
+
+		status = http.StatusServiceUnavailable
+		err = pr.Init()
+		pr.Free()
+		assert.ErrorIs(t, err, ErrUnavailable)
+	})
+}
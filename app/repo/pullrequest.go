@@ -0,0 +1,209 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// UserService is implemented by the services which keep the changes
+// of the users apart, e.g. in pull requests
+type UserService interface {
+	Service
+	// InitFor is used instead of Init to make changes on behalf of the user,
+	// the user is the telegram user id
+	InitFor(userID int64) error
+}
+
+// PullRequestMode defines the branch the changes are committed to
+type PullRequestMode string
+
+const (
+	// PullRequestPerDay commits the changes of the day to the same branch
+	PullRequestPerDay PullRequestMode = "day"
+	// PullRequestPerUser commits the changes of the user to the same branch
+	PullRequestPerUser PullRequestMode = "user"
+)
+
+// DefaultPullRequestPrefix is the prefix of the branches with the changes
+const DefaultPullRequestPrefix = "teledger/"
+
+var errReadOnly = errors.New("read-only in the pull request mode, the changes are made with InitFor")
+
+// PullRequestRepo commits the changes to a branch per day or per user
+// and opens a pull request from it to the base branch, so every change
+// is reviewed before it lands on the base.
+// Init clones the base branch for reading, InitFor clones the branch
+// of the user's changes, it's created from the base if it doesn't exist,
+// or if its pull request has been merged or closed.
+type PullRequestRepo struct {
+	InMemoryRepo
+	forge  Forge
+	mode   PullRequestMode
+	prefix string
+	now    func() time.Time
+	// branch of the changes of the current operation, empty if it's read-only
+	head string
+	// base as it's configured, the base is resolved on the first change
+	// and it's not safe to read it without the lock
+	snapshotBase string
+}
+
+// NewPullRequestRepo creates the repo opening the pull requests with the forge
+func NewPullRequestRepo(url string, auth Auth, forge Forge, mode PullRequestMode) *PullRequestRepo {
+	return &PullRequestRepo{
+		InMemoryRepo: InMemoryRepo{
+			url:   url,
+			creds: auth,
+			depth: 1,
+		},
+		forge:  forge,
+		mode:   mode,
+		prefix: DefaultPullRequestPrefix,
+		now:    time.Now,
+	}
+}
+
+// WithBase sets the branch the pull requests are merged to,
+// the remote's default HEAD is used if it isn't set
+func (pr *PullRequestRepo) WithBase(base string) *PullRequestRepo {
+	pr.base = base
+	pr.snapshotBase = base
+	return pr
+}
+
+// WithPrefix sets the prefix of the branches with the changes
+func (pr *PullRequestRepo) WithPrefix(prefix string) *PullRequestRepo {
+	pr.prefix = prefix
+	return pr
+}
+
+// WithSigner makes the repo sign the commits
+func (pr *PullRequestRepo) WithSigner(signer Signer) *PullRequestRepo {
+	pr.signer = signer
+	return pr
+}
+
+// BranchFor returns the branch the changes of the user are committed to,
+// the branch of the user is named by the telegram user id, which never changes
+func (pr *PullRequestRepo) BranchFor(userID int64) string {
+	if pr.mode == PullRequestPerUser {
+		if userID == 0 {
+			return pr.prefix + "anonymous"
+		}
+		return pr.prefix + strconv.FormatInt(userID, 10)
+	}
+	return pr.prefix + pr.now().Format("2006-01-02")
+}
+
+// title returns the title of the pull request of the branch
+func (pr *PullRequestRepo) title(user string) string {
+	if pr.mode == PullRequestPerUser {
+		return fmt.Sprintf("teledger: changes by %s", user)
+	}
+	return fmt.Sprintf("teledger: %s", pr.now().Format("2006-01-02"))
+}
+
+func (pr *PullRequestRepo) Init() error {
+	pr.initedMu.Lock()
+	pr.head = ""
+	pr.branch = pr.base
+	pr.recreate = false
+	return pr.init()
+}
+
+func (pr *PullRequestRepo) InitFor(userID int64) error {
+	pr.initedMu.Lock()
+	err := pr.resolveBase()
+	if err != nil {
+		return fmt.Errorf("init error: %w", err)
+	}
+	pr.head = pr.BranchFor(userID)
+	pr.branch = pr.head
+	pr.recreate = pr.reviewed(pr.head)
+	return pr.init()
+}
+
+// reviewed reports whether the last pull request of the branch has been
+// merged or closed, then the branch is behind the base and it's made
+// from the base again, so the changes are validated with the current ledger
+func (pr *PullRequestRepo) reviewed(head string) bool {
+	last, err := pr.forge.FindPullRequest(head)
+	if err != nil {
+		// the branch is used as it is, the pull request is opened
+		// again after the push if it's not open
+		slog.Warn("unable to find pull request", "head", head, "error", err)
+		return false
+	}
+	return last != nil && last.State != PullRequestOpen
+}
+
+// Snapshot returns the files of the base branch, like Init
+func (pr *PullRequestRepo) Snapshot() (*Snapshot, error) {
+	return pr.snapshot("", pr.snapshotBase)
+}
+
+// resolveBase finds the remote's default HEAD if the base isn't set
+func (pr *PullRequestRepo) resolveBase() error {
+	if pr.base != "" {
+		return nil
+	}
+	auth, err := pr.auth()
+	if err != nil {
+		return err
+	}
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{pr.url},
+	})
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return fmt.Errorf("unable to list remote refs: %w", unavailable(err))
+	}
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			pr.base = ref.Target().Short()
+			return nil
+		}
+	}
+	return fmt.Errorf("unable to find the default branch of %s", pr.url)
+}
+
+// CommitPush pushes the changes to the branch of the user
+// and opens the pull request if it's not open yet
+func (pr *PullRequestRepo) CommitPush(msg, name, email string) error {
+	if pr.head == "" {
+		return errReadOnly
+	}
+	err := pr.InMemoryRepo.CommitPush(msg, name, email)
+	if err != nil {
+		return err
+	}
+
+	// the changes are pushed anyway, the pull request
+	// may be opened manually or by the next change
+	opened, err := pr.forge.OpenPullRequest(pr.head, pr.base, pr.title(name), pullRequestBody(pr.base))
+	if err != nil {
+		slog.Error("unable to open pull request", "head", pr.head, "base", pr.base, "error", err)
+		return nil
+	}
+	slog.Info("pull request is open", "url", opened.URL, "head", pr.head)
+	return nil
+}
+
+func pullRequestBody(base string) string {
+	return fmt.Sprintf("Changes made with teledger, merge to apply them to `%s`.", base)
+}
+
+// PullRequest returns the last pull request with the changes of the user,
+// nil if there are none
+func (pr *PullRequestRepo) PullRequest(userID int64) (*PullRequest, error) {
+	return pr.forge.FindPullRequest(pr.BranchFor(userID))
+}
@@ -0,0 +1,135 @@
+package repo
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendAndPushFor(t *testing.T, pr *PullRequestRepo, userID int64, line string) {
+	t.Helper()
+	require.NoError(t, pr.InitFor(userID))
+	defer pr.Free()
+	w, err := pr.OpenForAppend("main.ledger")
+	require.NoError(t, err)
+	_, err = fmt.Fprintln(w, line)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, pr.CommitPush(TransactionMessage("test commit", ""), "teledger", "teledger@example.com"))
+}
+
+func TestPullRequestRepo(t *testing.T) {
+	t.Run("per day", func(t *testing.T) {
+		remote := newLocalRemote(t, map[string]string{"main.ledger": ";; main\n"})
+		forge := &FakeForge{}
+		pr := NewPullRequestRepo(remote, Auth{}, forge, PullRequestPerDay)
+		day := time.Date(2024, 2, 14, 10, 0, 0, 0, time.UTC)
+		pr.now = func() time.Time { return day }
+
+		appendAndPushFor(t, pr, 1, ";; first")
+		appendAndPushFor(t, pr, 2, ";; second")
+
+		// the base is not changed until the pull request is merged
+		assert.Equal(t, ";; main\n", readFile(t, pr, "main.ledger"))
+		assert.Equal(t, ";; main\n;; first\n;; second\n", readFile(t, NewInMemoryRepo(remote, Auth{}).WithBranch("teledger/2024-02-14"), "main.ledger"))
+
+		require.Len(t, forge.PullRequests(), 1)
+		opened, err := pr.PullRequest(1)
+		require.NoError(t, err)
+		assert.Equal(t, &PullRequest{
+			Number: 1,
+			URL:    "https://forge.example.com/pulls/1",
+			State:  PullRequestOpen,
+			Head:   "teledger/2024-02-14",
+			Base:   "master",
+		}, opened)
+
+		t.Run("new pull request after merge", func(t *testing.T) {
+			require.NoError(t, forge.SetState(1, PullRequestMerged))
+			merged, err := pr.PullRequest(1)
+			require.NoError(t, err)
+			assert.Equal(t, PullRequestMerged, merged.State)
+
+			appendAndPushFor(t, pr, 1, ";; third")
+			require.Len(t, forge.PullRequests(), 2)
+			opened, err := pr.PullRequest(1)
+			require.NoError(t, err)
+			assert.Equal(t, 2, opened.Number)
+			assert.Equal(t, PullRequestOpen, opened.State)
+
+			// the branch is made from the base again, the reviewed changes are not on it
+			assert.Equal(t, ";; main\n;; third\n", readFile(t, NewInMemoryRepo(remote, Auth{}).WithBranch("teledger/2024-02-14"), "main.ledger"))
+		})
+
+		t.Run("history of the pull request", func(t *testing.T) {
+			appendAndPushFor(t, pr, 1, ";; fourth")
+			require.NoError(t, pr.InitFor(1))
+			defer pr.Free()
+			log, err := pr.Log(10)
+			require.NoError(t, err)
+			require.Len(t, log, 3)
+			assert.Equal(t, "initial commit", log[2].Subject())
+
+			require.NoError(t, pr.Revert(log[1].Hash))
+			assert.Equal(t, ";; main\n;; fourth\n", checkReadString(t, pr, "main.ledger"))
+		})
+
+		t.Run("next day", func(t *testing.T) {
+			day = day.AddDate(0, 0, 1)
+			opened, err := pr.PullRequest(1)
+			require.NoError(t, err)
+			assert.Nil(t, opened)
+
+			appendAndPushFor(t, pr, 1, ";; next day")
+			assert.Equal(t, ";; main\n;; next day\n", readFile(t, NewInMemoryRepo(remote, Auth{}).WithBranch("teledger/2024-02-15"), "main.ledger"))
+		})
+	})
+
+	t.Run("per user", func(t *testing.T) {
+		remote := newLocalRemote(t, map[string]string{"main.ledger": ";; main\n"})
+		forge := &FakeForge{}
+		pr := NewPullRequestRepo(remote, Auth{}, forge, PullRequestPerUser).WithPrefix("review/")
+
+		appendAndPushFor(t, pr, 42, ";; john")
+		appendAndPushFor(t, pr, 43, ";; another john")
+
+		assert.Equal(t, ";; main\n;; john\n", readFile(t, NewInMemoryRepo(remote, Auth{}).WithBranch("review/42"), "main.ledger"))
+		assert.Equal(t, ";; main\n;; another john\n", readFile(t, NewInMemoryRepo(remote, Auth{}).WithBranch("review/43"), "main.ledger"))
+		assert.Len(t, forge.PullRequests(), 2)
+		assert.Equal(t, "review/anonymous", pr.BranchFor(0))
+
+		t.Run("branch of the closed pull request is made from the base", func(t *testing.T) {
+			require.NoError(t, forge.SetState(1, PullRequestClosed))
+			appendAndPushFor(t, pr, 42, ";; john again")
+			assert.Equal(t, ";; main\n;; john again\n", readFile(t, NewInMemoryRepo(remote, Auth{}).WithBranch("review/42"), "main.ledger"))
+
+			// the branch of the open pull request is kept
+			appendAndPushFor(t, pr, 42, ";; and again")
+			assert.Equal(t, ";; main\n;; john again\n;; and again\n", readFile(t, NewInMemoryRepo(remote, Auth{}).WithBranch("review/42"), "main.ledger"))
+			assert.Len(t, forge.PullRequests(), 3)
+		})
+	})
+
+	t.Run("base branch", func(t *testing.T) {
+		remote := newLocalRemote(t, map[string]string{"main.ledger": ";; main\n"})
+		appendAndPush(t, NewInMemoryRepo(remote, Auth{}).WithBranch("ledger"), ";; ledger")
+		forge := &FakeForge{}
+		pr := NewPullRequestRepo(remote, Auth{}, forge, PullRequestPerUser).WithBase("ledger")
+
+		assert.Equal(t, ";; main\n;; ledger\n", readFile(t, pr, "main.ledger"))
+		appendAndPushFor(t, pr, 42, ";; john")
+		assert.Equal(t, ";; main\n;; ledger\n;; john\n", readFile(t, NewInMemoryRepo(remote, Auth{}).WithBranch("teledger/42"), "main.ledger"))
+		assert.Equal(t, "ledger", forge.PullRequests()[0].Base)
+	})
+
+	t.Run("read-only init", func(t *testing.T) {
+		remote := newLocalRemote(t, map[string]string{"main.ledger": ";; main\n"})
+		pr := NewPullRequestRepo(remote, Auth{}, &FakeForge{}, PullRequestPerDay)
+		require.NoError(t, pr.Init())
+		defer pr.Free()
+		assert.ErrorIs(t, pr.CommitPush(TransactionMessage("test commit", ""), "teledger", "teledger@example.com"), errReadOnly)
+	})
+}
@@ -1,18 +1,27 @@
 package repo
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage"
 	"github.com/go-git/go-git/v5/storage/memory"
 )
 
@@ -27,38 +36,115 @@ type Service interface {
 	Open(file string) (billy.File, error)
 	OpenForAppend(file string) (billy.File, error)
 	CommitPush(msg, name, email string) error
+
+	// Snapshot returns an immutable copy of the files, without the lock,
+	// it's cached until the files are changed
+	Snapshot() (*Snapshot, error)
 }
 
 type InMemoryRepo struct {
-	url        string
-	token      string
+	url   string
+	creds Auth
+	// authMethod is resolved from the creds on the first use
+	authMethod transport.AuthMethod
+	branch     string
 	repo       *git.Repository
 	dirtyFiles map[string]bool
 	inited     bool
 	initedMu   sync.Mutex
+	// storage returns the storage and the worktree for a new clone,
+	// the memory ones if nil
+	storage func() (storage.Storer, billy.Filesystem, error)
+	// depth of the clone, 0 means the full history
+	depth int
+	// base is the branch a missing branch is created from, the default HEAD if empty
+	base string
+	// recreate makes the branch from the base even if it exists,
+	// the push replaces the remote branch then
+	recreate bool
+	// signer of the commits, they're not signed if nil
+	signer Signer
+	// snapshots for the readers, kept apart from the clone
+	snapshots snapshotCache
 }
 
-func NewInMemoryRepo(url, token string) *InMemoryRepo {
+func NewInMemoryRepo(url string, auth Auth) *InMemoryRepo {
 	return &InMemoryRepo{
 		url:    url,
-		token:  token,
+		creds:  auth,
 		inited: false,
+		depth:  1,
 	}
 }
 
+// WithBranch makes the repo work with the branch instead of the remote's default HEAD.
+// The branch is created from the default HEAD if it doesn't exist on the remote yet.
+func (imr *InMemoryRepo) WithBranch(branch string) *InMemoryRepo {
+	imr.branch = branch
+	return imr
+}
+
+// WithSigner makes the repo sign the commits
+func (imr *InMemoryRepo) WithSigner(signer Signer) *InMemoryRepo {
+	imr.signer = signer
+	return imr
+}
+
+// auth returns the credentials for the remote, nil if there are none
+func (imr *InMemoryRepo) auth() (transport.AuthMethod, error) {
+	if imr.authMethod != nil {
+		return imr.authMethod, nil
+	}
+	m, err := imr.creds.Method(imr.url)
+	if err != nil {
+		return nil, fmt.Errorf("auth error: %v", err)
+	}
+	imr.authMethod = m
+	return m, nil
+}
+
+func (imr *InMemoryRepo) clone(ref plumbing.ReferenceName) (*git.Repository, error) {
+	auth, err := imr.auth()
+	if err != nil {
+		return nil, err
+	}
+	var st storage.Storer = memory.NewStorage()
+	var wt billy.Filesystem = memfs.New()
+	if imr.storage != nil {
+		st, wt, err = imr.storage()
+		if err != nil {
+			return nil, fmt.Errorf("unable to create storage: %v", err)
+		}
+	}
+	r, err := git.Clone(st, wt, &git.CloneOptions{
+		URL:           imr.url,
+		Auth:          auth,
+		ReferenceName: ref,
+		SingleBranch:  ref != "",
+		Depth:         imr.depth,
+	})
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	return r, nil
+}
+
 func (imr *InMemoryRepo) Init() error {
 	imr.initedMu.Lock()
-	fs := memfs.New()
-	r, err := git.Clone(memory.NewStorage(), fs, &git.CloneOptions{
-		URL: imr.url,
-		Auth: &http.BasicAuth{
-			Username: "username",
-			Password: imr.token,
-		},
-		Depth: 1,
-	})
+	return imr.init()
+}
+
+// init clones the repo, the lock must be held
+func (imr *InMemoryRepo) init() error {
+	var r *git.Repository
+	var err error
+	if imr.branch == "" {
+		r, err = imr.clone("")
+	} else {
+		r, err = imr.cloneBranch()
+	}
 	if err != nil {
-		return fmt.Errorf("init error, unable to clone %s: %v", imr.url, err)
+		return fmt.Errorf("init error, unable to clone %s: %w", imr.url, err)
 	}
 
 	ref, err := r.Head()
@@ -73,6 +159,60 @@ func (imr *InMemoryRepo) Init() error {
 	return nil
 }
 
+// cloneBranch clones the branch, or the base (the default HEAD)
+// with the branch created from it if the branch doesn't exist yet
+func (imr *InMemoryRepo) cloneBranch() (*git.Repository, error) {
+	if imr.recreate {
+		slog.Info("creating branch from the base again", "branch", imr.branch, "base", imr.base, "url", imr.url)
+		return imr.branchFromBase()
+	}
+	r, err := imr.clone(plumbing.NewBranchReferenceName(imr.branch))
+	if err == nil {
+		return r, nil
+	}
+	if !errors.Is(err, git.NoMatchingRefSpecError{}) && !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, err
+	}
+
+	slog.Info("branch not found, creating it", "branch", imr.branch, "base", imr.base, "url", imr.url)
+	return imr.branchFromBase()
+}
+
+// branchFromBase clones the base and creates the branch from it
+func (imr *InMemoryRepo) branchFromBase() (*git.Repository, error) {
+	var base plumbing.ReferenceName
+	if imr.base != "" {
+		base = plumbing.NewBranchReferenceName(imr.base)
+	}
+	r, err := imr.clone(base)
+	if err != nil {
+		return nil, err
+	}
+	wtr, err := r.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("worktree receiving error: %v", err)
+	}
+	err = wtr.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(imr.branch), Create: true})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create branch %s: %v", imr.branch, err)
+	}
+	return r, nil
+}
+
+// pushRefSpecs limits the push to the configured branch,
+// the recreated branch replaces the remote one
+func (imr *InMemoryRepo) pushRefSpecs() []config.RefSpec {
+	if imr.branch == "" {
+		return nil
+	}
+	ref := plumbing.NewBranchReferenceName(imr.branch)
+	spec := fmt.Sprintf("%s:%s", ref, ref)
+	if imr.recreate {
+		spec = "+" + spec
+	}
+	return []config.RefSpec{config.RefSpec(spec)}
+}
+
 func (imr *InMemoryRepo) Free() {
 	imr.inited = false
 	imr.repo = nil
@@ -91,10 +231,14 @@ type Closer struct {
 	billy.File
 	r    *InMemoryRepo
 	path string
+	// the file is opened for writing, it's added to the commit on close
+	write bool
 }
 
 func (w *Closer) Close() error {
-	w.r.dirtyFiles[w.path] = true
+	if w.write {
+		w.r.dirtyFiles[w.path] = true
+	}
 	return w.File.Close()
 }
 
@@ -108,9 +252,10 @@ func (imr *InMemoryRepo) OpenFile(file string, flag int, perm os.FileMode) (bill
 	}
 	f, err := wtr.Filesystem.OpenFile(file, flag, perm)
 	wc := Closer{
-		r:    imr,
-		path: file,
-		File: f,
+		r:     imr,
+		path:  file,
+		File:  f,
+		write: flag&writeFlags != 0,
 	}
 	return &wc, err
 }
@@ -124,6 +269,11 @@ func (imr *InMemoryRepo) CommitPush(msg, name, email string) error {
 		return fmt.Errorf("worktree receiving error: %v", err)
 	}
 
+	base, err := imr.repo.Head()
+	if err != nil {
+		return fmt.Errorf("unable to get head: %v", err)
+	}
+
 	for file, dirty := range imr.dirtyFiles {
 		if dirty {
 			_, err = wtr.Add(file)
@@ -132,26 +282,160 @@ func (imr *InMemoryRepo) CommitPush(msg, name, email string) error {
 			}
 		}
 	}
+	now := time.Now()
 	_, err = wtr.Commit(msg, &git.CommitOptions{
 		Author: &object.Signature{
 			Name:  name,
 			Email: email,
-			When:  time.Now(),
+			When:  now,
+		},
+		// the commits made by teledger are found by the committer
+		Committer: &object.Signature{
+			Name:  CommitterName,
+			Email: CommitterEmail,
+			When:  now,
 		},
 	})
 	if err != nil {
 		return fmt.Errorf("error while committing: %v", err)
 	}
+	if imr.signer != nil {
+		err = signHead(imr.repo, imr.signer)
+		if err != nil {
+			return fmt.Errorf("error while signing: %v", err)
+		}
+	}
+	// the auth method is resolved by the clone in Init
 	err = imr.repo.Push(&git.PushOptions{
-		Auth: &http.BasicAuth{
-			Username: "username",
-			Password: imr.token,
-		},
+		RefSpecs: imr.pushRefSpecs(),
+		Auth:     imr.authMethod,
 	})
+	if err != nil && pushRejected(err) {
+		// drop the commit, so the clone is not diverged from the remote
+		rerr := wtr.Reset(&git.ResetOptions{Commit: base.Hash(), Mode: git.HardReset})
+		if rerr != nil {
+			slog.Error("unable to reset rejected commit", "error", rerr)
+		}
+		return fmt.Errorf("%w: %v", ErrPushRejected, err)
+	}
 	if err != nil {
-		return fmt.Errorf("error while pushing: %v", err)
+		return fmt.Errorf("error while pushing: %w", unavailable(err))
 	}
+	imr.snapshots.invalidate()
+
+	return nil
+}
 
+// ErrPushRejected means the remote branch has been changed since Init,
+// the change should be applied again on top of the new remote head
+var ErrPushRejected = errors.New("push rejected, the remote has been changed")
+
+// ErrUnavailable means the remote can't be reached,
+// the same operation may succeed later
+var ErrUnavailable = errors.New("the remote is unavailable")
+
+// unavailable marks the error of the remote operation with ErrUnavailable
+// if it's a network or transport one. The other errors, e.g. the refused
+// credentials or the push declined by a hook, are returned as is.
+func unavailable(err error) error {
+	if !transportError(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrUnavailable, err)
+}
+
+// transportError reports whether the remote couldn't be reached
+// or the connection has been broken
+func transportError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ETIMEDOUT) {
+		return true
+	}
+	// the server is down or overloaded, the status is wrapped
+	// in the error which doesn't unwrap
+	var unexpected *plumbing.UnexpectedError
+	if errors.As(err, &unexpected) {
+		err = unexpected.Err
+	}
+	var httpErr *githttp.Err
+	if errors.As(err, &httpErr) {
+		code := httpErr.StatusCode()
+		return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// pushRejected reports whether the push failed because it's not a fast-forward
+func pushRejected(err error) bool {
+	if errors.Is(err, git.ErrForceNeeded) || errors.Is(err, plumbing.ErrObjectNotFound) {
+		// the remote head is missing in a shallow clone
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "non-fast-forward") || strings.Contains(msg, "fetch first")
+}
+
+// revertDepth is how deep in the history of a shallow clone
+// the reverted commits are looked for
+const revertDepth = 100
+
+// deepen fetches the history of a shallow clone down to the depth,
+// so the changes can be listed and reverted
+func (imr *InMemoryRepo) deepen(depth int) error {
+	if imr.depth == 0 || depth <= imr.depth {
+		return nil
+	}
+	err := imr.repo.Fetch(&git.FetchOptions{
+		Auth:  imr.authMethod,
+		Depth: depth,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("unable to fetch history: %w", unavailable(err))
+	}
+	return nil
+}
+
+func (imr *InMemoryRepo) Log(limit int) ([]Change, error) {
+	if !imr.inited {
+		return nil, fmt.Errorf("not initialized")
+	}
+	// the parent of the last commit is needed to revert it
+	err := imr.deepen(limit + 1)
+	if err != nil {
+		return nil, err
+	}
+	return gitLog(imr.repo, limit)
+}
+
+func (imr *InMemoryRepo) Revert(hash string) error {
+	if !imr.inited {
+		return fmt.Errorf("not initialized")
+	}
+	err := imr.deepen(revertDepth)
+	if err != nil {
+		return err
+	}
+	removed, err := gitRevert(imr.repo, hash, imr)
+	if err != nil {
+		return err
+	}
+	wtr, err := imr.repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("worktree receiving error: %v", err)
+	}
+	for _, file := range removed {
+		_, err = wtr.Remove(file)
+		if err != nil {
+			return fmt.Errorf("unable to remove %s: %v", file, err)
+		}
+		delete(imr.dirtyFiles, file)
+	}
 	return nil
 }
 
@@ -171,10 +455,7 @@ func (imr *InMemoryRepo) resetPush(hash plumbing.Hash) error {
 
 	err = imr.repo.Push(&git.PushOptions{
 		ForceWithLease: &git.ForceWithLease{},
-		Auth: &http.BasicAuth{
-			Username: "username",
-			Password: imr.token,
-		},
+		Auth:           imr.authMethod,
 	})
 	if err != nil {
 		return fmt.Errorf("error while pushing reset: %v", err)
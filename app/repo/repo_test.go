@@ -3,11 +3,20 @@ package repo
 import (
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/require"
 )
@@ -27,17 +36,17 @@ func checkReadString(t *testing.T, repo Service, fp string) string {
 func TestNewInMemoryRepo(t *testing.T) {
 	_ = godotenv.Load("../../.env.dev")
 
-	gitURL := os.Getenv("GITHUB_URL")
+	gitURL := os.Getenv("GIT_URL")
 	if gitURL == "" {
 		t.Fatal("GIT_URL is not set")
 	}
 
-	gitToken := os.Getenv("GITHUB_TOKEN")
+	gitToken := os.Getenv("GIT_PASSWORD")
 	if gitToken == "" {
-		t.Fatal("GIT_ACCESS_TOKEN is not set")
+		t.Fatal("GIT_PASSWORD is not set")
 	}
 
-	repo := NewInMemoryRepo(gitURL, gitToken)
+	repo := NewInMemoryRepo(gitURL, TokenAuth(gitToken))
 
 	err := repo.Init()
 	if err != nil {
@@ -104,7 +113,7 @@ func TestNewInMemoryRepo(t *testing.T) {
 			}
 		})
 
-		newRepo := NewInMemoryRepo(gitURL, gitToken)
+		newRepo := NewInMemoryRepo(gitURL, TokenAuth(gitToken))
 		err = newRepo.Init()
 
 		if !strings.HasSuffix(checkReadString(t, newRepo, "main.ledger"), line) {
@@ -113,3 +122,99 @@ func TestNewInMemoryRepo(t *testing.T) {
 		newRepo.Free()
 	})
 }
+
+// newLocalRemote creates a bare repository with an initial commit
+// containing the files and returns its path
+func newLocalRemote(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote.git")
+	_, err := git.PlainInit(remote, true)
+	require.NoError(t, err)
+
+	work := filepath.Join(dir, "work")
+	r, err := git.PlainInit(work, false)
+	require.NoError(t, err)
+	wtr, err := r.Worktree()
+	require.NoError(t, err)
+
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(work, name), []byte(content), 0o600))
+		_, err = wtr.Add(name)
+		require.NoError(t, err)
+	}
+	_, err = wtr.Commit("initial commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	_, err = r.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}})
+	require.NoError(t, err)
+	require.NoError(t, r.Push(&git.PushOptions{}))
+
+	return remote
+}
+
+// serveHTTP serves the bare repository over http with git http-backend,
+// the connections are dropped while the remote is offline
+func serveHTTP(t *testing.T, remote string) (url string, offline *atomic.Bool) {
+	t.Helper()
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	backend := &cgi.Handler{
+		Path: gitBin,
+		Args: []string{"http-backend"},
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + filepath.Dir(remote),
+			"GIT_HTTP_EXPORT_ALL=1",
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.receivepack",
+			"GIT_CONFIG_VALUE_0=true",
+		},
+	}
+	offline = &atomic.Bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if offline.Load() {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/" + filepath.Base(remote), offline
+}
+
+func TestInMemoryRepo_WithBranch(t *testing.T) {
+	remote := newLocalRemote(t, map[string]string{"main.ledger": ";; main\n"})
+
+	writeAndPush := func(imr *InMemoryRepo, line string) {
+		t.Helper()
+		require.NoError(t, imr.Init())
+		defer imr.Free()
+		w, err := imr.OpenForAppend("main.ledger")
+		require.NoError(t, err)
+		_, err = fmt.Fprintln(w, line)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.NoError(t, imr.CommitPush("test commit", "teledger", "teledger@example.com"))
+	}
+
+	readMain := func(imr *InMemoryRepo) string {
+		t.Helper()
+		require.NoError(t, imr.Init())
+		defer imr.Free()
+		return checkReadString(t, imr, "main.ledger")
+	}
+
+	// the branch doesn't exist yet, it's created from the default HEAD
+	writeAndPush(NewInMemoryRepo(remote, Auth{}).WithBranch("pending"), ";; on branch")
+	writeAndPush(NewInMemoryRepo(remote, Auth{}).WithBranch("pending"), ";; on branch again")
+
+	require.Equal(t, ";; main\n;; on branch\n;; on branch again\n", readMain(NewInMemoryRepo(remote, Auth{}).WithBranch("pending")))
+	require.Equal(t, ";; main\n", readMain(NewInMemoryRepo(remote, Auth{})))
+}
@@ -0,0 +1,195 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/crypto/ssh"
+)
+
+// Signer signs the commits, e.g. for the branches requiring signed commits
+type Signer interface {
+	// Sign returns the armored signature of the encoded commit
+	Sign(message io.Reader) (string, error)
+}
+
+// PGPSigner signs the commits with an OpenPGP key
+type PGPSigner struct {
+	entity *openpgp.Entity
+}
+
+// NewPGPSigner reads the armored OpenPGP private key,
+// the passphrase is used if the key is encrypted
+func NewPGPSigner(keyFile string, passphrase []byte) (*PGPSigner, error) {
+	f, err := os.Open(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open signing key: %v", err)
+	}
+	defer f.Close()
+	ring, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read signing key %s: %v", keyFile, err)
+	}
+	if len(ring) == 0 || ring[0].PrivateKey == nil {
+		return nil, fmt.Errorf("no private key in %s", keyFile)
+	}
+	entity := ring[0]
+	if entity.PrivateKey.Encrypted {
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("signing key %s is encrypted, passphrase is required", keyFile)
+		}
+		err = entity.DecryptPrivateKeys(passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt signing key %s: %v", keyFile, err)
+		}
+	}
+	return &PGPSigner{entity: entity}, nil
+}
+
+func (s *PGPSigner) Sign(message io.Reader) (string, error) {
+	var b bytes.Buffer
+	err := openpgp.ArmoredDetachSign(&b, s.entity, message, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to sign commit: %v", err)
+	}
+	return b.String(), nil
+}
+
+// the namespace and the hash of the ssh signatures made by git
+const (
+	sshSigNamespace = "git"
+	sshSigHash      = "sha512"
+	sshSigMagic     = "SSHSIG"
+)
+
+// SSHSigner signs the commits with an SSH key, like git with `gpg.format=ssh`
+type SSHSigner struct {
+	signer ssh.Signer
+}
+
+// NewSSHSigner reads the SSH private key,
+// the passphrase is used if the key is encrypted
+func NewSSHSigner(keyFile string, passphrase []byte) (*SSHSigner, error) {
+	pem, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read signing key: %v", err)
+	}
+	var signer ssh.Signer
+	if len(passphrase) > 0 {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, passphrase)
+	} else {
+		signer, err = ssh.ParsePrivateKey(pem)
+	}
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("signing key %s is encrypted, passphrase is required", keyFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse signing key %s: %v", keyFile, err)
+	}
+	return &SSHSigner{signer: signer}, nil
+}
+
+// sshSignedData returns the blob signed by the key, see PROTOCOL.sshsig
+func sshSignedData(message io.Reader) ([]byte, error) {
+	h := sha512.New()
+	_, err := io.Copy(h, message)
+	if err != nil {
+		return nil, err
+	}
+	data := ssh.Marshal(struct {
+		Namespace string
+		Reserved  string
+		Hash      string
+		Message   string
+	}{sshSigNamespace, "", sshSigHash, string(h.Sum(nil))})
+	return append([]byte(sshSigMagic), data...), nil
+}
+
+func (s *SSHSigner) Sign(message io.Reader) (string, error) {
+	data, err := sshSignedData(message)
+	if err != nil {
+		return "", fmt.Errorf("unable to sign commit: %v", err)
+	}
+	var sig *ssh.Signature
+	if as, ok := s.signer.(ssh.AlgorithmSigner); ok && s.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		// ssh-rsa signatures with sha1 are not accepted
+		sig, err = as.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = s.signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to sign commit: %v", err)
+	}
+
+	blob := ssh.Marshal(struct {
+		Version   uint32
+		PublicKey string
+		Namespace string
+		Reserved  string
+		Hash      string
+		Signature string
+	}{1, string(s.signer.PublicKey().Marshal()), sshSigNamespace, "", sshSigHash, string(ssh.Marshal(sig))})
+	return armorSSHSignature(append([]byte(sshSigMagic), blob...)), nil
+}
+
+// armorSSHSignature wraps the signature like ssh-keygen -Y sign
+func armorSSHSignature(blob []byte) string {
+	enc := base64.StdEncoding.EncodeToString(blob)
+	var b strings.Builder
+	b.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(enc) > 70 {
+		b.WriteString(enc[:70] + "\n")
+		enc = enc[70:]
+	}
+	b.WriteString(enc + "\n")
+	b.WriteString("-----END SSH SIGNATURE-----\n")
+	return b.String()
+}
+
+// signHead replaces the HEAD commit with the signed one
+func signHead(r *git.Repository, signer Signer) error {
+	head, err := r.Head()
+	if err != nil {
+		return fmt.Errorf("unable to get head: %v", err)
+	}
+	c, err := r.CommitObject(head.Hash())
+	if err != nil {
+		return fmt.Errorf("unable to read commit: %v", err)
+	}
+
+	unsigned := &plumbing.MemoryObject{}
+	err = c.EncodeWithoutSignature(unsigned)
+	if err != nil {
+		return fmt.Errorf("unable to encode commit: %v", err)
+	}
+	msg, err := unsigned.Reader()
+	if err != nil {
+		return err
+	}
+	c.PGPSignature, err = signer.Sign(msg)
+	if err != nil {
+		return err
+	}
+
+	signed := r.Storer.NewEncodedObject()
+	err = c.Encode(signed)
+	if err != nil {
+		return fmt.Errorf("unable to encode commit: %v", err)
+	}
+	hash, err := r.Storer.SetEncodedObject(signed)
+	if err != nil {
+		return fmt.Errorf("unable to store signed commit: %v", err)
+	}
+	return r.Storer.SetReference(plumbing.NewHashReference(head.Name(), hash))
+}
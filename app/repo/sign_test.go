@@ -0,0 +1,194 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// newPGPKey writes a new private key encrypted with the passphrase
+// and returns its path and the armored public key
+func newPGPKey(t *testing.T, passphrase string) (string, string) {
+	t.Helper()
+	entity, err := openpgp.NewEntity("teledger", "", "teledger@example.com", nil)
+	require.NoError(t, err)
+
+	var pub bytes.Buffer
+	w, err := armor.Encode(&pub, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+
+	require.NoError(t, entity.EncryptPrivateKeys([]byte(passphrase), nil))
+	var priv bytes.Buffer
+	w, err = armor.Encode(&priv, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.SerializePrivateWithoutSigning(w, nil))
+	require.NoError(t, w.Close())
+
+	path := filepath.Join(t.TempDir(), "signing.asc")
+	require.NoError(t, os.WriteFile(path, priv.Bytes(), 0o600))
+	return path, pub.String()
+}
+
+// newSSHSigningKey writes a new openssh private key encrypted with the passphrase
+// and returns its path and the public key
+func newSSHSigningKey(t *testing.T, passphrase string) (string, ssh.PublicKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "teledger", []byte(passphrase))
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "signing_key")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0o600))
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return path, sshPub
+}
+
+func headCommit(t *testing.T, dir string) *object.Commit {
+	t.Helper()
+	r, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	head, err := r.Head()
+	require.NoError(t, err)
+	c, err := r.CommitObject(head.Hash())
+	require.NoError(t, err)
+	return c
+}
+
+// verifySSHSignature checks the signature of the commit like git with `gpg.format=ssh`
+func verifySSHSignature(t *testing.T, c *object.Commit, key ssh.PublicKey) {
+	t.Helper()
+	block, _ := pem.Decode([]byte(c.PGPSignature))
+	require.NotNil(t, block)
+	assert.Equal(t, "SSH SIGNATURE", block.Type)
+	require.True(t, bytes.HasPrefix(block.Bytes, []byte(sshSigMagic)))
+
+	var blob struct {
+		Version   uint32
+		PublicKey string
+		Namespace string
+		Reserved  string
+		Hash      string
+		Signature string
+	}
+	require.NoError(t, ssh.Unmarshal(block.Bytes[len(sshSigMagic):], &blob))
+	assert.Equal(t, sshSigNamespace, blob.Namespace)
+	assert.Equal(t, key.Marshal(), []byte(blob.PublicKey))
+
+	var sig ssh.Signature
+	require.NoError(t, ssh.Unmarshal([]byte(blob.Signature), &sig))
+
+	unsigned := &plumbing.MemoryObject{}
+	require.NoError(t, c.EncodeWithoutSignature(unsigned))
+	msg, err := unsigned.Reader()
+	require.NoError(t, err)
+	data, err := sshSignedData(msg)
+	require.NoError(t, err)
+	assert.NoError(t, key.Verify(data, &sig))
+}
+
+func TestNewPGPSigner(t *testing.T) {
+	key, _ := newPGPKey(t, "secret")
+
+	_, err := NewPGPSigner(key, nil)
+	assert.ErrorContains(t, err, "passphrase is required")
+	_, err = NewPGPSigner(key, []byte("wrong"))
+	assert.ErrorContains(t, err, "unable to decrypt")
+	_, err = NewPGPSigner(filepath.Join(t.TempDir(), "missing.asc"), nil)
+	assert.Error(t, err)
+	_, err = NewPGPSigner(key, []byte("secret"))
+	assert.NoError(t, err)
+}
+
+func TestNewSSHSigner(t *testing.T) {
+	key, _ := newSSHSigningKey(t, "secret")
+
+	_, err := NewSSHSigner(key, nil)
+	assert.ErrorContains(t, err, "passphrase is required")
+	_, err = NewSSHSigner(key, []byte("wrong"))
+	assert.Error(t, err)
+	_, err = NewSSHSigner(key, []byte("secret"))
+	assert.NoError(t, err)
+}
+
+func TestPersistentRepo_WithSigner(t *testing.T) {
+	remote := newLocalRemote(t, map[string]string{"main.ledger": ";; main\n"})
+	key, pub := newPGPKey(t, "secret")
+	signer, err := NewPGPSigner(key, []byte("secret"))
+	require.NoError(t, err)
+
+	pr := NewPersistentRepo(remote, Auth{}, "").WithSigner(signer)
+	appendAndPush(t, pr, ";; signed")
+	appendAndPush(t, pr, ";; signed again")
+
+	c := headCommit(t, remote)
+	assert.Equal(t, TransactionMessage("test commit", ""), c.Message)
+	assert.Contains(t, c.PGPSignature, "-----BEGIN PGP SIGNATURE-----")
+	_, err = c.Verify(pub)
+	assert.NoError(t, err)
+
+	parent, err := c.Parent(0)
+	require.NoError(t, err)
+	_, err = parent.Verify(pub)
+	assert.NoError(t, err)
+	file, err := c.File("main.ledger")
+	require.NoError(t, err)
+	content, err := file.Contents()
+	require.NoError(t, err)
+	assert.Equal(t, ";; main\n;; signed\n;; signed again\n", content)
+
+	t.Run("not signed without signer", func(t *testing.T) {
+		appendAndPush(t, NewPersistentRepo(remote, Auth{}, ""), ";; unsigned")
+		assert.Empty(t, headCommit(t, remote).PGPSignature)
+	})
+}
+
+func TestLocalRepo_WithSigner(t *testing.T) {
+	dir := newLocalDir(t, ";; main\n")
+	_, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	key, pub := newSSHSigningKey(t, "secret")
+	signer, err := NewSSHSigner(key, []byte("secret"))
+	require.NoError(t, err)
+
+	appendAndPush(t, NewLocalRepo(dir, true).WithSigner(signer), ";; signed")
+
+	c := headCommit(t, dir)
+	assert.Contains(t, c.PGPSignature, "-----BEGIN SSH SIGNATURE-----")
+	verifySSHSignature(t, c, pub)
+
+	t.Run("verified by git", func(t *testing.T) {
+		if _, err := exec.LookPath("git"); err != nil {
+			t.Skip("git is not installed")
+		}
+		if _, err := exec.LookPath("ssh-keygen"); err != nil {
+			t.Skip("ssh-keygen is not installed")
+		}
+		allowed := filepath.Join(t.TempDir(), "allowed_signers")
+		line := "teledger@example.com " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+		require.NoError(t, os.WriteFile(allowed, []byte(line+"\n"), 0o600))
+
+		cmd := exec.Command("git", "-c", "gpg.ssh.allowedSignersFile="+allowed, "verify-commit", c.Hash.String())
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(out))
+		assert.Contains(t, string(out), `Good "git" signature`)
+	})
+}
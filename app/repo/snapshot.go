@@ -0,0 +1,173 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+var errSnapshotReadOnly = errors.New("snapshot is read-only")
+
+// writeFlags are the flags of the files opened for writing
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_APPEND | os.O_CREATE | os.O_TRUNC
+
+// Snapshot is an immutable copy of the files at a commit.
+// It's a read-only Service: Init and Free do nothing and the changes
+// are rejected, so the readers don't wait for each other or for the changes.
+type Snapshot struct {
+	// Hash of the commit the files are taken from
+	Hash string
+	fs   billy.Filesystem
+}
+
+func (s *Snapshot) Init() error {
+	return nil
+}
+
+func (s *Snapshot) Free() {}
+
+func (s *Snapshot) Open(file string) (billy.File, error) {
+	return s.fs.Open(file)
+}
+
+func (s *Snapshot) OpenFile(file string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag&writeFlags != 0 {
+		return nil, errSnapshotReadOnly
+	}
+	return s.fs.OpenFile(file, flag, perm)
+}
+
+func (s *Snapshot) OpenForAppend(string) (billy.File, error) {
+	return nil, errSnapshotReadOnly
+}
+
+func (s *Snapshot) CommitPush(string, string, string) error {
+	return errSnapshotReadOnly
+}
+
+func (s *Snapshot) Snapshot() (*Snapshot, error) {
+	return s, nil
+}
+
+// snapshotCache keeps the last snapshot of the remote branch,
+// it's separate from the clone used for the changes
+type snapshotCache struct {
+	mu   sync.Mutex
+	snap *Snapshot
+	// auth is resolved on the first snapshot, the clone for the changes
+	// resolves its own
+	auth transport.AuthMethod
+}
+
+// invalidate drops the snapshot after a change has been pushed
+func (c *snapshotCache) invalidate() {
+	c.mu.Lock()
+	c.snap = nil
+	c.mu.Unlock()
+}
+
+// Snapshot returns the files at the head of the remote branch.
+// The snapshot is cloned again only when the head changes,
+// the last one is used while the remote is unavailable.
+func (imr *InMemoryRepo) Snapshot() (*Snapshot, error) {
+	return imr.snapshot(imr.branch, imr.base)
+}
+
+// snapshot returns the snapshot of the branch. The base, or the default HEAD,
+// is used if the branch is empty or doesn't exist yet.
+func (imr *InMemoryRepo) snapshot(branch, base string) (*Snapshot, error) {
+	c := &imr.snapshots
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.auth == nil {
+		m, err := imr.creds.Method(imr.url)
+		if err != nil {
+			return nil, fmt.Errorf("auth error: %v", err)
+		}
+		c.auth = m
+	}
+
+	ref, err := imr.remoteHead(c.auth, branch, base)
+	if err != nil {
+		if c.snap != nil {
+			slog.Warn("remote is unavailable, the last snapshot is used", "hash", c.snap.Hash, "url", imr.url, "error", err)
+			return c.snap, nil
+		}
+		return nil, fmt.Errorf("unable to get remote head: %w", err)
+	}
+	if c.snap != nil && c.snap.Hash == ref.Hash().String() {
+		return c.snap, nil
+	}
+
+	fs := memfs.New()
+	r, err := git.Clone(memory.NewStorage(), fs, &git.CloneOptions{
+		URL:           imr.url,
+		Auth:          c.auth,
+		ReferenceName: ref.Name(),
+		SingleBranch:  true,
+		Depth:         1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to clone %s: %w", imr.url, unavailable(err))
+	}
+	head, err := r.Head()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get head: %v", err)
+	}
+	c.snap = &Snapshot{Hash: head.Hash().String(), fs: fs}
+	slog.Debug("snapshot cloned", "hash", c.snap.Hash, "url", imr.url)
+	return c.snap, nil
+}
+
+// remoteHead returns the reference of the remote branch,
+// the default HEAD is resolved to the branch it points to
+func (imr *InMemoryRepo) remoteHead(auth transport.AuthMethod, branch, base string) (*plumbing.Reference, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{imr.url},
+	})
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	byName := make(map[plumbing.ReferenceName]*plumbing.Reference, len(refs))
+	for _, ref := range refs {
+		byName[ref.Name()] = ref
+	}
+
+	// a missing branch is created from the base on the first change
+	var names []plumbing.ReferenceName
+	if branch != "" {
+		names = append(names, plumbing.NewBranchReferenceName(branch))
+	}
+	if base != "" {
+		names = append(names, plumbing.NewBranchReferenceName(base))
+	} else {
+		names = append(names, plumbing.HEAD)
+	}
+	for _, name := range names {
+		ref, ok := byName[name]
+		if !ok {
+			continue
+		}
+		if ref.Type() == plumbing.SymbolicReference {
+			ref, ok = byName[ref.Target()]
+			if !ok {
+				continue
+			}
+		}
+		return ref, nil
+	}
+	return nil, fmt.Errorf("branch %q is not found in %s", branch, imr.url)
+}
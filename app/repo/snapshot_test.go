@@ -0,0 +1,153 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryRepo_Snapshot(t *testing.T) {
+	remote := newLocalRemote(t, map[string]string{"main.ledger": ";; main\n"})
+	pr := NewPersistentRepo(remote, Auth{}, "")
+
+	snap, err := pr.Snapshot()
+	require.NoError(t, err)
+	assert.Equal(t, ";; main\n", readFile(t, snap, "main.ledger"))
+
+	t.Run("snapshot is cached while the head is the same", func(t *testing.T) {
+		again, err := pr.Snapshot()
+		require.NoError(t, err)
+		assert.Same(t, snap, again)
+	})
+
+	t.Run("snapshot is read-only", func(t *testing.T) {
+		_, err := snap.OpenForAppend("main.ledger")
+		assert.ErrorIs(t, err, errSnapshotReadOnly)
+		_, err = snap.OpenFile("new.ledger", os.O_CREATE|os.O_WRONLY, 0o644)
+		assert.ErrorIs(t, err, errSnapshotReadOnly)
+		assert.ErrorIs(t, snap.CommitPush("msg", "name", "email"), errSnapshotReadOnly)
+	})
+
+	t.Run("snapshot doesn't wait for the changes", func(t *testing.T) {
+		require.NoError(t, pr.Init())
+		defer pr.Free()
+		again, err := pr.Snapshot()
+		require.NoError(t, err)
+		assert.Same(t, snap, again)
+	})
+
+	t.Run("changes make a new snapshot", func(t *testing.T) {
+		appendAndPush(t, pr, ";; first")
+		changed, err := pr.Snapshot()
+		require.NoError(t, err)
+		assert.NotEqual(t, snap.Hash, changed.Hash)
+		assert.Equal(t, ";; main\n;; first\n", readFile(t, changed, "main.ledger"))
+		// the previous snapshot is not changed
+		assert.Equal(t, ";; main\n", readFile(t, snap, "main.ledger"))
+		snap = changed
+	})
+
+	t.Run("remote changes make a new snapshot", func(t *testing.T) {
+		forcePush(t, remote, ";; rewritten\n")
+		changed, err := pr.Snapshot()
+		require.NoError(t, err)
+		assert.NotEqual(t, snap.Hash, changed.Hash)
+		assert.Equal(t, ";; rewritten\n", readFile(t, changed, "main.ledger"))
+		snap = changed
+	})
+
+	t.Run("last snapshot is used while the remote is unavailable", func(t *testing.T) {
+		url, offline := serveHTTP(t, remote)
+		pr := NewPersistentRepo(url, Auth{}, "")
+		snap, err := pr.Snapshot()
+		require.NoError(t, err)
+
+		offline.Store(true)
+		again, err := pr.Snapshot()
+		require.NoError(t, err)
+		assert.Same(t, snap, again)
+
+		_, err = NewPersistentRepo(url, Auth{}, "").Snapshot()
+		assert.ErrorIs(t, err, ErrUnavailable)
+	})
+}
+
+func TestInMemoryRepo_SnapshotBranch(t *testing.T) {
+	remote := newLocalRemote(t, map[string]string{"main.ledger": ";; main\n"})
+	pr := NewPersistentRepo(remote, Auth{}, "").WithBranch("pending")
+
+	// the missing branch is created from the default HEAD
+	snap, err := pr.Snapshot()
+	require.NoError(t, err)
+	assert.Equal(t, ";; main\n", readFile(t, snap, "main.ledger"))
+
+	appendAndPush(t, pr, ";; on branch")
+	snap, err = pr.Snapshot()
+	require.NoError(t, err)
+	assert.Equal(t, ";; main\n;; on branch\n", readFile(t, snap, "main.ledger"))
+
+	snap, err = NewInMemoryRepo(remote, Auth{}).Snapshot()
+	require.NoError(t, err)
+	assert.Equal(t, ";; main\n", readFile(t, snap, "main.ledger"))
+}
+
+func TestLocalRepo_Snapshot(t *testing.T) {
+	dir := newLocalDir(t, ";; main\n")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "includes"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "includes", "2024.ledger"), []byte(";; 2024\n"), 0o600))
+	lr := NewLocalRepo(dir, false)
+
+	snap, err := lr.Snapshot()
+	require.NoError(t, err)
+	assert.Equal(t, ";; main\n", readFile(t, snap, "main.ledger"))
+	assert.Equal(t, ";; 2024\n", readFile(t, snap, "includes/2024.ledger"))
+	_, err = snap.Open(LockFile)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	again, err := lr.Snapshot()
+	require.NoError(t, err)
+	assert.Same(t, snap, again)
+
+	t.Run("changes make a new snapshot", func(t *testing.T) {
+		appendAndPush(t, lr, ";; first")
+		changed, err := lr.Snapshot()
+		require.NoError(t, err)
+		assert.NotEqual(t, snap.Hash, changed.Hash)
+		assert.Equal(t, ";; main\n;; first\n", readFile(t, changed, "main.ledger"))
+	})
+
+	t.Run("snapshot doesn't wait for other readers", func(t *testing.T) {
+		// e.g. a snapshot of another process
+		f, err := os.OpenFile(filepath.Join(dir, LockFile), os.O_RDWR, 0o600)
+		require.NoError(t, err)
+		defer f.Close()
+		require.NoError(t, rlockFile(f))
+		defer unlockFile(f) //nolint:errcheck
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := lr.Snapshot()
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("snapshot is waiting for the shared lock")
+		}
+	})
+
+	t.Run("changes of other processes make a new snapshot", func(t *testing.T) {
+		before, err := lr.Snapshot()
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "main.ledger"), []byte(";; synced\n"), 0o600))
+		changed, err := lr.Snapshot()
+		require.NoError(t, err)
+		assert.NotEqual(t, before.Hash, changed.Hash)
+		assert.Equal(t, ";; synced\n", readFile(t, changed, "main.ledger"))
+	})
+}
@@ -0,0 +1,327 @@
+package teledger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mput/teledger/app/ledger"
+	"github.com/mput/teledger/app/repo"
+)
+
+// ErrQueued means the remote is unavailable, or earlier changes are waiting for it,
+// and the change is kept in the outbox, it's committed in the background later
+var ErrQueued = errors.New("the change is queued")
+
+// OutboxKind is the kind of the change kept in the outbox
+type OutboxKind string
+
+const (
+	OutboxAdd     OutboxKind = "add"
+	OutboxComment OutboxKind = "comment"
+	OutboxDelete  OutboxKind = "delete"
+	OutboxEdit    OutboxKind = "edit"
+	OutboxUndo    OutboxKind = "undo"
+)
+
+// default delays between the attempts to commit the queued changes,
+// the delay doubles after every failed attempt
+const (
+	DefaultOutboxMinBackoff = 30 * time.Second
+	DefaultOutboxMaxBackoff = 30 * time.Minute
+)
+
+// OutboxEntry is a change failed because the remote was unavailable
+type OutboxEntry struct {
+	ID   string     `json:"id"`
+	Kind OutboxKind `json:"kind"`
+	// transaction, comment, correction or the hash of the undone commit
+	Text string `json:"text,omitempty"`
+	// id of the added, deleted or edited transaction
+	TransactionID string      `json:"transactionId,omitempty"`
+	User          ledger.User `json:"user"`
+	// message about the change, the notification replies to it
+	MessageID   int64     `json:"messageId,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+// firstLine returns the first line which is not a comment
+func firstLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, ";") {
+			return line
+		}
+	}
+	return strings.TrimSpace(text)
+}
+
+// Summary describes the change in a line
+func (e *OutboxEntry) Summary() string {
+	switch e.Kind {
+	case OutboxAdd:
+		return fmt.Sprintf("Add transaction: %s", firstLine(e.Text))
+	case OutboxComment:
+		return "Add comment"
+	case OutboxDelete:
+		return fmt.Sprintf("Delete transaction %s", e.TransactionID)
+	case OutboxEdit:
+		return fmt.Sprintf("Edit transaction %s: %s", e.TransactionID, firstLine(e.Text))
+	case OutboxUndo:
+		hash := e.Text
+		if len(hash) > 7 {
+			hash = hash[:7]
+		}
+		return fmt.Sprintf("Undo %s", hash)
+	}
+	return string(e.Kind)
+}
+
+// Outbox keeps the queued changes in a JSON file, so they survive restarts.
+// The changes are committed in the order they were made.
+type Outbox struct {
+	mu         sync.Mutex
+	path       string
+	entries    []OutboxEntry
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// NewOutbox loads the changes queued before restart from the file
+func NewOutbox(path string) (*Outbox, error) {
+	o := &Outbox{
+		path:       path,
+		minBackoff: DefaultOutboxMinBackoff,
+		maxBackoff: DefaultOutboxMaxBackoff,
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read outbox file: %v", err)
+	}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &o.entries)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode outbox: %v", err)
+		}
+	}
+	return o, nil
+}
+
+// WithBackoff sets the delay after the first failed attempt and the longest one
+func (o *Outbox) WithBackoff(minBackoff, maxBackoff time.Duration) *Outbox {
+	o.minBackoff = minBackoff
+	o.maxBackoff = maxBackoff
+	return o
+}
+
+// backoff returns the delay after the failed attempts
+func (o *Outbox) backoff(attempts int) time.Duration {
+	d := o.minBackoff
+	for i := 1; i < attempts && d < o.maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, o.maxBackoff)
+}
+
+// save writes the entries, the lock must be held
+func (o *Outbox) save() error {
+	data, err := json.MarshalIndent(o.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode outbox: %v", err)
+	}
+	err = writeFileAtomic(o.path, data)
+	if err != nil {
+		return fmt.Errorf("unable to write outbox file: %v", err)
+	}
+	return nil
+}
+
+// Add queues the change, it's tried again after the backoff
+func (o *Outbox) Add(e OutboxEntry) (OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e.ID = ledger.NewTransactionID()
+	e.CreatedAt = time.Now()
+	e.NextAttempt = e.CreatedAt.Add(o.backoff(1))
+	e.Attempts = 1
+	o.entries = append(o.entries, e)
+	err := o.save()
+	if err != nil {
+		o.entries = o.entries[:len(o.entries)-1]
+		return OutboxEntry{}, err
+	}
+	return e, nil
+}
+
+// Entries returns the queued changes, the oldest first
+func (o *Outbox) Entries() []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]OutboxEntry(nil), o.entries...)
+}
+
+// Len returns the number of the queued changes
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// next returns the oldest change if it's time to try it again
+func (o *Outbox) next(now time.Time) (OutboxEntry, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.entries) == 0 || now.Before(o.entries[0].NextAttempt) {
+		return OutboxEntry{}, false
+	}
+	return o.entries[0], true
+}
+
+// failed schedules the next attempt of the change
+func (o *Outbox) failed(id string, now time.Time, err error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := range o.entries {
+		if e := &o.entries[i]; e.ID == id {
+			e.Attempts++
+			e.NextAttempt = now.Add(o.backoff(e.Attempts))
+			e.LastError = err.Error()
+			return o.save()
+		}
+	}
+	return nil
+}
+
+func (o *Outbox) remove(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, e := range o.entries {
+		if e.ID == id {
+			o.entries = append(o.entries[:i:i], o.entries[i+1:]...)
+			return o.save()
+		}
+	}
+	return nil
+}
+
+// queue keeps the change in the outbox if it has failed because the remote
+// is unavailable, the error is wrapped with ErrQueued then
+func (tel *Teledger) queue(err error, e OutboxEntry) error {
+	if tel.Outbox == nil || !errors.Is(err, repo.ErrUnavailable) {
+		return err
+	}
+	queued, qerr := tel.Outbox.Add(e)
+	if qerr != nil {
+		slog.Error("unable to queue change", "change", e.Summary(), "error", qerr)
+		return err
+	}
+	slog.Warn("remote is unavailable, change is queued", "id", queued.ID, "change", queued.Summary(), "error", err)
+	return fmt.Errorf("%w: %w", ErrQueued, err)
+}
+
+// write makes the change unless earlier changes are waiting in the outbox,
+// then it's queued after them, so the changes land in the order they were made.
+// The change failed because the remote is unavailable is queued too.
+func (tel *Teledger) write(e OutboxEntry, change func() error) error {
+	if tel.Outbox != nil && tel.Outbox.Len() > 0 {
+		queued, err := tel.Outbox.Add(e)
+		if err != nil {
+			return fmt.Errorf("unable to queue change after the waiting ones: %v", err)
+		}
+		slog.Info("earlier changes are waiting, change is queued", "id", queued.ID, "change", queued.Summary())
+		return fmt.Errorf("%w: earlier changes are waiting", ErrQueued)
+	}
+	err := change()
+	if err != nil {
+		return tel.queue(err, e)
+	}
+	return nil
+}
+
+// apply makes the queued change again
+func (tel *Teledger) apply(e OutboxEntry) error {
+	var err error
+	switch e.Kind {
+	case OutboxAdd:
+		if e.TransactionID == "" {
+			return tel.Ledger.AddTransaction(e.Text, e.User)
+		}
+		return tel.Ledger.AddTransactionWithID(e.Text, e.TransactionID, e.User)
+	case OutboxComment:
+		_, err = tel.Ledger.AddComment(e.Text, e.User)
+	case OutboxDelete:
+		err = tel.Ledger.DeleteTransactionWithID(e.TransactionID, e.User)
+	case OutboxEdit:
+		_, err = tel.Ledger.EditTransactionWithID(e.TransactionID, e.Text, e.User, 2)
+	case OutboxUndo:
+		_, err = tel.Ledger.Undo(e.Text, e.User)
+	default:
+		err = fmt.Errorf("unknown change %q", e.Kind)
+	}
+	return err
+}
+
+// retryQueued commits the queued changes in order until the remote
+// is unavailable again. onDone is called for every change
+// which has landed, or has failed for another reason and is dropped.
+func (tel *Teledger) retryQueued(now time.Time, onDone func(OutboxEntry, error)) {
+	for {
+		e, ok := tel.Outbox.next(now)
+		if !ok {
+			return
+		}
+		err := tel.apply(e)
+		if errors.Is(err, repo.ErrUnavailable) {
+			slog.Warn("remote is still unavailable", "id", e.ID, "attempts", e.Attempts, "error", err)
+			ferr := tel.Outbox.failed(e.ID, now, err)
+			if ferr != nil {
+				slog.Error("unable to update outbox", "error", ferr)
+			}
+			return
+		}
+		if err != nil {
+			slog.Error("queued change failed", "id", e.ID, "change", e.Summary(), "error", err)
+		} else {
+			slog.Info("queued change committed", "id", e.ID, "change", e.Summary())
+		}
+		rerr := tel.Outbox.remove(e.ID)
+		if rerr != nil {
+			slog.Error("unable to remove change from outbox", "id", e.ID, "error", rerr)
+			// it's tried again, rather than lost
+			return
+		}
+		onDone(e, err)
+	}
+}
+
+// StartOutbox periodically commits the queued changes, until the context
+// is canceled. Does nothing if the outbox is off.
+func (tel *Teledger) StartOutbox(ctx context.Context, interval time.Duration, onDone func(OutboxEntry, error)) {
+	if tel.Outbox == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				tel.retryQueued(now, onDone)
+			}
+		}
+	}()
+}
@@ -0,0 +1,226 @@
+package teledger
+
+import (
+	"io"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/mput/teledger/app/ledger"
+	"github.com/mput/teledger/app/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newBareRemote creates a bare repository with the main ledger file
+// and returns its path
+func newBareRemote(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote.git")
+	_, err := git.PlainInit(remote, true)
+	require.NoError(t, err)
+
+	work := filepath.Join(dir, "work")
+	r, err := git.PlainInit(work, false)
+	require.NoError(t, err)
+	wtr, err := r.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(work, "main.ledger"), []byte(content), 0o600))
+	_, err = wtr.Add("main.ledger")
+	require.NoError(t, err)
+	_, err = wtr.Commit("initial commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+	_, err = r.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{remote}})
+	require.NoError(t, err)
+	require.NoError(t, r.Push(&git.PushOptions{}))
+	return remote
+}
+
+// serveHTTP serves the bare repository over http with git http-backend,
+// the connections are dropped while the remote is offline
+func serveHTTP(t *testing.T, remote string) (url string, offline *atomic.Bool) {
+	t.Helper()
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	backend := &cgi.Handler{
+		Path: gitBin,
+		Args: []string{"http-backend"},
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + filepath.Dir(remote),
+			"GIT_HTTP_EXPORT_ALL=1",
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.receivepack",
+			"GIT_CONFIG_VALUE_0=true",
+		},
+	}
+	offline = &atomic.Bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if offline.Load() {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/" + filepath.Base(remote), offline
+}
+
+func remoteFile(t *testing.T, remote string) string {
+	t.Helper()
+	r := repo.NewInMemoryRepo(remote, repo.Auth{})
+	require.NoError(t, r.Init())
+	defer r.Free()
+	f, err := r.Open("main.ledger")
+	require.NoError(t, err)
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestOutbox_Backoff(t *testing.T) {
+	o, err := NewOutbox(filepath.Join(t.TempDir(), "outbox.json"))
+	require.NoError(t, err)
+	o.WithBackoff(time.Second, 5*time.Second)
+	assert.Equal(t, time.Second, o.backoff(1))
+	assert.Equal(t, 2*time.Second, o.backoff(2))
+	assert.Equal(t, 4*time.Second, o.backoff(3))
+	assert.Equal(t, 5*time.Second, o.backoff(4))
+	assert.Equal(t, 5*time.Second, o.backoff(100))
+}
+
+func TestOutbox_Summary(t *testing.T) {
+	for e, summary := range map[*OutboxEntry]string{
+		{Kind: OutboxAdd, Text: ";; tacos\n2014-12-01 * Tacos\n    Food  10 EUR\n    Assets:Cash"}: "Add transaction: 2014-12-01 * Tacos",
+		{Kind: OutboxComment, Text: ";; hello"}:                                                    "Add comment",
+		{Kind: OutboxDelete, TransactionID: "0123456789abcdef"}:                                    "Delete transaction 0123456789abcdef",
+		{Kind: OutboxEdit, TransactionID: "0123456789abcdef", Text: "it was 12 EUR\nby card"}:      "Edit transaction 0123456789abcdef: it was 12 EUR",
+		{Kind: OutboxUndo, Text: "0123456789abcdef0123456789abcdef01234567"}:                       "Undo 0123456",
+	} {
+		assert.Equal(t, summary, e.Summary())
+	}
+}
+
+func TestTeledger_Outbox(t *testing.T) {
+	remote := newBareRemote(t, "account Food\naccount Assets:Cash\ncommodity EUR\n")
+	url, offline := serveHTTP(t, remote)
+	outboxPath := filepath.Join(t.TempDir(), "outbox.json")
+	outbox, err := NewOutbox(outboxPath)
+	require.NoError(t, err)
+
+	tel := NewTeledger(ledger.NewLedger(repo.NewPersistentRepo(url, repo.Auth{}, ""), nil))
+	tel.Outbox = outbox.WithBackoff(time.Minute, time.Hour)
+	user := ledger.User{ID: 42, Name: "john", ChatID: 42}
+
+	// the clone is kept in memory, it's fetched on every operation
+	_, err = tel.AddComment("online", user)
+	require.NoError(t, err)
+
+	// the remote goes offline
+	offline.Store(true)
+
+	_, err = tel.AddComment("offline", user)
+	assert.ErrorIs(t, err, ErrQueued)
+	assert.ErrorIs(t, err, repo.ErrUnavailable)
+
+	tr := "2014-12-01 * Tacos\n    Assets:Cash  -10.00 EUR\n    Food"
+	pt := tel.ProposeTransaction(tr, user)
+	assert.NoError(t, pt.Error)
+	assert.True(t, pt.Queued)
+	assert.False(t, pt.Committed)
+	assert.Equal(t, tr, pt.UserProvidedTransaction)
+
+	// fails for good when it's replayed
+	err = tel.DeleteTransaction("0123456789abcdef", user)
+	assert.ErrorIs(t, err, ErrQueued)
+
+	entries := tel.Outbox.Entries()
+	require.Len(t, entries, 3)
+	assert.Equal(t, []OutboxKind{OutboxComment, OutboxAdd, OutboxDelete}, []OutboxKind{entries[0].Kind, entries[1].Kind, entries[2].Kind})
+	assert.Equal(t, user, entries[0].User)
+
+	var done []string
+	var failed []error
+	onDone := func(e OutboxEntry, err error) {
+		done = append(done, e.Summary())
+		failed = append(failed, err)
+	}
+
+	now := time.Now()
+	tel.retryQueued(now, onDone)
+	assert.Empty(t, done, "not retried before the backoff")
+
+	tel.retryQueued(now.Add(2*time.Minute), onDone)
+	assert.Empty(t, done)
+	entries = tel.Outbox.Entries()
+	require.Len(t, entries, 3)
+	assert.Equal(t, 2, entries[0].Attempts)
+	assert.Contains(t, entries[0].LastError, "remote is unavailable")
+	assert.Equal(t, now.Add(4*time.Minute), entries[0].NextAttempt)
+
+	t.Run("new changes wait for the queued ones", func(t *testing.T) {
+		offline.Store(false)
+		_, err := tel.AddComment("back online", user)
+		assert.ErrorIs(t, err, ErrQueued)
+		assert.NotErrorIs(t, err, repo.ErrUnavailable)
+
+		pt := tel.ProposeTransaction("2014-12-02 * Burrito\n    Assets:Cash  -5.00 EUR\n    Food", user)
+		assert.NoError(t, pt.Error)
+		assert.True(t, pt.Queued)
+		assert.Len(t, tel.Outbox.Entries(), 5)
+		assert.NotContains(t, remoteFile(t, remote), "back online")
+	})
+
+	t.Run("retried after restart once the remote is back", func(t *testing.T) {
+		outbox, err := NewOutbox(outboxPath)
+		require.NoError(t, err)
+		tel.Outbox = outbox.WithBackoff(time.Minute, time.Hour)
+		require.Len(t, tel.Outbox.Entries(), 5)
+
+		tel.retryQueued(now.Add(4*time.Minute), onDone)
+		assert.Equal(t, []string{
+			"Add comment", "Add transaction: 2014-12-01 * Tacos", "Delete transaction 0123456789abcdef",
+			"Add comment", "Add transaction: 2014-12-02 * Burrito",
+		}, done)
+		assert.NoError(t, failed[0])
+		assert.NoError(t, failed[1])
+		assert.Error(t, failed[2])
+		assert.NoError(t, failed[3])
+		assert.NoError(t, failed[4])
+		assert.Empty(t, tel.Outbox.Entries())
+
+		content := remoteFile(t, remote)
+		assert.Contains(t, content, ";; online")
+		assert.Contains(t, content, ";; offline")
+		assert.Contains(t, content, tr)
+		assert.Less(t, strings.Index(content, ";; online"), strings.Index(content, ";; offline"))
+		assert.Less(t, strings.Index(content, ";; offline"), strings.Index(content, ";; back online"))
+		assert.Less(t, strings.Index(content, "Tacos"), strings.Index(content, "Burrito"))
+
+		_, err = tel.AddComment("empty queue", user)
+		assert.NoError(t, err, "the changes are made right away once the queue is empty")
+
+		outbox, err = NewOutbox(outboxPath)
+		require.NoError(t, err)
+		assert.Empty(t, outbox.Entries())
+	})
+
+}
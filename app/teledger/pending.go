@@ -0,0 +1,461 @@
+package teledger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mput/teledger/app/ledger"
+	"github.com/mput/teledger/app/repo"
+)
+
+var (
+	ErrPendingNotFound = errors.New("missing pending transaction")
+	ErrPendingExpired  = errors.New("pending transaction expired")
+)
+
+// PendingStore keeps proposed transactions waiting to be confirmed
+type PendingStore interface {
+	Put(pt *PendingTransaction) error
+	// Get returns ErrPendingNotFound if there is no transaction with the key
+	// and ErrPendingExpired if the transaction has expired
+	Get(key string) (*PendingTransaction, error)
+	Delete(key string) error
+	// RemoveExpired removes and returns the transactions expired at the moment
+	RemoveExpired(now time.Time) ([]*PendingTransaction, error)
+}
+
+// pendingRecord is the serializable form of PendingTransaction
+type pendingRecord struct {
+	Key           string             `json:"key"`
+	Transaction   ledger.Transaction `json:"transaction"`
+	Comment       string             `json:"comment"`
+	RealDateTime  time.Time          `json:"realDateTime"`
+	AttemptNumber int                `json:"attemptNumber"`
+	Attempts      []attemptRecord    `json:"attempts,omitempty"`
+	CreatedAt     time.Time          `json:"createdAt"`
+	ExpiresAt     time.Time          `json:"expiresAt"`
+	ChatID        int64              `json:"chatId,omitempty"`
+	MessageID     int64              `json:"messageId,omitempty"`
+}
+
+// attemptRecord is the serializable form of ledger.Attempt
+type attemptRecord struct {
+	Number      int                 `json:"number"`
+	Transaction *ledger.Transaction `json:"transaction,omitempty"`
+	Error       string              `json:"error,omitempty"`
+}
+
+func newPendingRecord(pt *PendingTransaction) pendingRecord {
+	rec := pendingRecord{
+		Key:           pt.PendingKey,
+		AttemptNumber: pt.AttemptNumber,
+		CreatedAt:     pt.CreatedAt,
+		ExpiresAt:     pt.ExpiresAt,
+		ChatID:        pt.ChatID,
+		MessageID:     pt.MessageID,
+	}
+	if tr := pt.GeneratedTransaction; tr != nil {
+		rec.Transaction = *tr
+		rec.Comment = tr.Comment
+		rec.RealDateTime = tr.RealDateTime
+	}
+	for _, a := range pt.Attempts {
+		ar := attemptRecord{Number: a.Number, Transaction: a.Transaction}
+		if a.Error != nil {
+			ar.Error = a.Error.Error()
+		}
+		rec.Attempts = append(rec.Attempts, ar)
+	}
+	return rec
+}
+
+func (rec *pendingRecord) pendingTransaction() *PendingTransaction {
+	tr := rec.Transaction
+	tr.Comment = rec.Comment
+	tr.RealDateTime = rec.RealDateTime
+
+	pt := &PendingTransaction{
+		PendingKey: rec.Key,
+		CreatedAt:  rec.CreatedAt,
+		ExpiresAt:  rec.ExpiresAt,
+		ChatID:     rec.ChatID,
+		MessageID:  rec.MessageID,
+	}
+	pt.GeneratedTransaction = &tr
+	pt.AttemptNumber = rec.AttemptNumber
+	for _, ar := range rec.Attempts {
+		a := ledger.Attempt{Number: ar.Number, Transaction: ar.Transaction}
+		if ar.Error != "" {
+			a.Error = errors.New(ar.Error)
+		}
+		pt.Attempts = append(pt.Attempts, a)
+	}
+	return pt
+}
+
+func (pt *PendingTransaction) expired(now time.Time) bool {
+	return !pt.ExpiresAt.IsZero() && !now.Before(pt.ExpiresAt)
+}
+
+func (rec *pendingRecord) expired(now time.Time) bool {
+	return !rec.ExpiresAt.IsZero() && !now.Before(rec.ExpiresAt)
+}
+
+// MemoryPendingStore keeps pending transactions in memory,
+// they are lost on restart.
+// The store keeps its own copies, so callers never share
+// the transactions with each other.
+type MemoryPendingStore struct {
+	mu      sync.RWMutex
+	entries map[string]*PendingTransaction
+}
+
+func NewMemoryPendingStore() *MemoryPendingStore {
+	return &MemoryPendingStore{
+		entries: make(map[string]*PendingTransaction),
+	}
+}
+
+// clone copies the transaction along with the generated transaction
+// and the attempts, the only fields the handlers may change
+func (pt *PendingTransaction) clone() *PendingTransaction {
+	res := *pt
+	if tr := pt.GeneratedTransaction; tr != nil {
+		res.GeneratedTransaction = tr.Clone()
+	}
+	res.Attempts = slices.Clone(pt.Attempts)
+	return &res
+}
+
+func (s *MemoryPendingStore) Put(pt *PendingTransaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[pt.PendingKey] = pt.clone()
+	return nil
+}
+
+func (s *MemoryPendingStore) Get(key string) (*PendingTransaction, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pt, ok := s.entries[key]
+	if !ok {
+		return nil, ErrPendingNotFound
+	}
+	if pt.expired(time.Now()) {
+		return nil, ErrPendingExpired
+	}
+	return pt.clone(), nil
+}
+
+func (s *MemoryPendingStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryPendingStore) RemoveExpired(now time.Time) ([]*PendingTransaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []*PendingTransaction
+	for key, pt := range s.entries {
+		if pt.expired(now) {
+			res = append(res, pt)
+			delete(s.entries, key)
+		}
+	}
+	return res, nil
+}
+
+// recordsChange changes the loaded records and returns the commit message,
+// or an empty one if nothing is changed. It may be called again on a retry,
+// so it must not keep state between the calls.
+type recordsChange func(records map[string]pendingRecord) (msg string)
+
+// recordsStore implements PendingStore on top of functions
+// reading and updating all the records at once
+type recordsStore struct {
+	mu sync.Mutex
+	// update loads the records, applies the change and saves them
+	// as a single read-modify-write cycle
+	update func(ch recordsChange) error
+	// read loads the records for Get, they aren't changed
+	read func() (map[string]pendingRecord, error)
+}
+
+func (s *recordsStore) Put(pt *PendingTransaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(func(records map[string]pendingRecord) string {
+		records[pt.PendingKey] = newPendingRecord(pt)
+		return fmt.Sprintf("Add pending transaction %s", pt.PendingKey)
+	})
+}
+
+func (s *recordsStore) Get(key string) (*PendingTransaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	rec, ok := records[key]
+	if !ok {
+		return nil, ErrPendingNotFound
+	}
+	if rec.expired(time.Now()) {
+		return nil, ErrPendingExpired
+	}
+	return rec.pendingTransaction(), nil
+}
+
+func (s *recordsStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(func(records map[string]pendingRecord) string {
+		if _, ok := records[key]; !ok {
+			return ""
+		}
+		delete(records, key)
+		return fmt.Sprintf("Remove pending transaction %s", key)
+	})
+}
+
+func (s *recordsStore) RemoveExpired(now time.Time) ([]*PendingTransaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []*PendingTransaction
+	err := s.update(func(records map[string]pendingRecord) string {
+		res = nil
+		for key, rec := range records {
+			if rec.expired(now) {
+				res = append(res, rec.pendingTransaction())
+				delete(records, key)
+			}
+		}
+		if len(res) == 0 {
+			return ""
+		}
+		return fmt.Sprintf("Remove %d expired pending transactions", len(res))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func encodeRecords(records map[string]pendingRecord) ([]byte, error) {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode pending transactions: %v", err)
+	}
+	return data, nil
+}
+
+func decodeRecords(r io.Reader) (map[string]pendingRecord, error) {
+	records := make(map[string]pendingRecord)
+	err := json.NewDecoder(r).Decode(&records)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("unable to decode pending transactions: %v", err)
+	}
+	return records, nil
+}
+
+// FilePendingStore keeps pending transactions in a JSON file on disk
+type FilePendingStore struct {
+	recordsStore
+	path string
+}
+
+func NewFilePendingStore(path string) *FilePendingStore {
+	s := &FilePendingStore{path: path}
+	s.update = s.updateFile
+	s.read = s.loadFile
+	return s
+}
+
+func (s *FilePendingStore) updateFile(ch recordsChange) error {
+	records, err := s.loadFile()
+	if err != nil {
+		return err
+	}
+	if ch(records) == "" {
+		return nil
+	}
+	return s.saveFile(records)
+}
+
+func (s *FilePendingStore) loadFile() (map[string]pendingRecord, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return make(map[string]pendingRecord), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open pending transactions file: %v", err)
+	}
+	defer f.Close()
+	return decodeRecords(f)
+}
+
+func (s *FilePendingStore) saveFile(records map[string]pendingRecord) error {
+	data, err := encodeRecords(records)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(s.path, data)
+	if err != nil {
+		return fmt.Errorf("unable to write pending transactions file: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes the data into a temporary file and renames it,
+// so the file is never left half-written
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+const gitPendingFile = "pending.json"
+
+// GitPendingStore keeps pending transactions in a JSON file
+// in a git repository, usually on a dedicated branch of the ledger repository
+type GitPendingStore struct {
+	recordsStore
+	repo repo.Service
+	// records of the snapshot with the hash, decoded on the first Get
+	hash    string
+	records map[string]pendingRecord
+}
+
+func NewGitPendingStore(rs repo.Service) *GitPendingStore {
+	s := &GitPendingStore{repo: rs}
+	s.update = s.updateRepo
+	s.read = s.readSnapshot
+	return s
+}
+
+// readSnapshot reads the records from the snapshot of the repo, so the lookups
+// don't fetch the repo. They're decoded again only when the head changes.
+func (s *GitPendingStore) readSnapshot() (map[string]pendingRecord, error) {
+	snap, err := s.repo.Snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get repo snapshot: %v", err)
+	}
+	if s.records != nil && s.hash == snap.Hash {
+		return s.records, nil
+	}
+
+	f, err := snap.Open(gitPendingFile)
+	var records map[string]pendingRecord
+	switch {
+	case os.IsNotExist(err):
+		records = make(map[string]pendingRecord)
+	case err != nil:
+		return nil, fmt.Errorf("unable to open pending transactions file: %v", err)
+	default:
+		defer f.Close()
+		records, err = decodeRecords(f)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.hash, s.records = snap.Hash, records
+	return records, nil
+}
+
+// maxPushAttempts limits how many times an update is applied
+// on top of the remote head when the push is rejected
+const maxPushAttempts = 3
+
+// updateRepo applies the change to the records of the freshly fetched repo
+// and pushes them. If another instance pushed in the meantime, the push is
+// rejected and the change is applied again on top of its records.
+func (s *GitPendingStore) updateRepo(ch recordsChange) error {
+	var err error
+	for attempt := 1; attempt <= maxPushAttempts; attempt++ {
+		if attempt > 1 {
+			slog.Warn("push of pending transactions rejected, updating them again", "attempt", attempt, "error", err)
+		}
+		err = s.updateRepoOnce(ch)
+		if !errors.Is(err, repo.ErrPushRejected) {
+			return err
+		}
+	}
+	return fmt.Errorf("unable to push pending transactions after %d attempts: %w", maxPushAttempts, err)
+}
+
+func (s *GitPendingStore) updateRepoOnce(ch recordsChange) error {
+	err := s.repo.Init()
+	defer s.repo.Free()
+	if err != nil {
+		return fmt.Errorf("unable to init repo: %w", err)
+	}
+
+	records, err := s.loadRepo()
+	if err != nil {
+		return err
+	}
+	msg := ch(records)
+	if msg == "" {
+		return nil
+	}
+	data, err := encodeRecords(records)
+	if err != nil {
+		return err
+	}
+
+	f, err := s.repo.OpenFile(gitPendingFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("unable to open pending transactions file: %v", err)
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write pending transactions file: %v", err)
+	}
+
+	err = s.repo.CommitPush(msg, "teledger", "teledger@example.com")
+	if err != nil {
+		return fmt.Errorf("unable to commit pending transactions: %w", err)
+	}
+	return nil
+}
+
+// loadRepo reads the records from the initialized repo
+func (s *GitPendingStore) loadRepo() (map[string]pendingRecord, error) {
+	f, err := s.repo.Open(gitPendingFile)
+	if os.IsNotExist(err) {
+		return make(map[string]pendingRecord), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open pending transactions file: %v", err)
+	}
+	defer f.Close()
+	return decodeRecords(f)
+}
@@ -0,0 +1,198 @@
+package teledger
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mput/teledger/app/ledger"
+	"github.com/mput/teledger/app/repo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPending(key string, expiresAt time.Time) *PendingTransaction {
+	dt, _ := time.Parse(time.RFC3339, "2014-11-30T11:45:26.371Z")
+	pt := &PendingTransaction{
+		PendingKey: key,
+		CreatedAt:  dt,
+		ExpiresAt:  expiresAt,
+	}
+	pt.AttemptNumber = 2
+	pt.GeneratedTransaction = &ledger.Transaction{
+		RealDateTime: dt,
+		Description:  "My tr",
+		Comment:      "valid",
+		Postings: []ledger.Posting{
+			{Account: "Assets:Cash", Amount: ledger.NewDecimal(-10, 0), Currency: "EUR"},
+			{Account: "Food", Amount: ledger.NewDecimal(10, 0), Currency: "EUR"},
+		},
+	}
+	pt.Attempts = []ledger.Attempt{
+		{Number: 1, Error: errors.New("account 'Cash' is not in the list of accounts")},
+		{Number: 2, Transaction: pt.GeneratedTransaction},
+	}
+	return pt
+}
+
+func testPendingStore(t *testing.T, newStore func() PendingStore) {
+	store := newStore()
+	now := time.Now()
+
+	_, err := store.Get("unknown")
+	assert.ErrorIs(t, err, ErrPendingNotFound)
+
+	require.NoError(t, store.Put(newTestPending("active", now.Add(time.Hour))))
+	require.NoError(t, store.Put(newTestPending("expired", now.Add(-time.Minute))))
+	require.NoError(t, store.Put(newTestPending("no-ttl", time.Time{})))
+
+	pt, err := store.Get("active")
+	require.NoError(t, err)
+	assert.Equal(t, "active", pt.PendingKey)
+	assert.Equal(t, 2, pt.AttemptNumber)
+	assert.Equal(t, newTestPending("active", now).GeneratedTransaction, pt.GeneratedTransaction)
+	require.Len(t, pt.Attempts, 2)
+	assert.EqualError(t, pt.Attempts[0].Error, "account 'Cash' is not in the list of accounts")
+	assert.Equal(t, "Food", pt.Attempts[1].Transaction.Postings[1].Account)
+
+	_, err = store.Get("expired")
+	assert.ErrorIs(t, err, ErrPendingExpired)
+
+	pt.ChatID = 42
+	pt.MessageID = 24
+	require.NoError(t, store.Put(pt))
+
+	// a new store instance over the same storage sees the same entries
+	store = newStore()
+
+	pt, err = store.Get("active")
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), pt.ChatID)
+	assert.Equal(t, int64(24), pt.MessageID)
+
+	expired, err := store.RemoveExpired(now)
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	assert.Equal(t, "expired", expired[0].PendingKey)
+
+	_, err = store.Get("expired")
+	assert.ErrorIs(t, err, ErrPendingNotFound)
+
+	_, err = store.Get("no-ttl")
+	assert.NoError(t, err)
+
+	require.NoError(t, store.Delete("active"))
+	_, err = store.Get("active")
+	assert.ErrorIs(t, err, ErrPendingNotFound)
+
+	assert.NoError(t, store.Delete("active"))
+}
+
+func TestMemoryPendingStore(t *testing.T) {
+	store := NewMemoryPendingStore()
+	testPendingStore(t, func() PendingStore { return store })
+}
+
+func TestFilePendingStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pending.json")
+	testPendingStore(t, func() PendingStore { return NewFilePendingStore(path) })
+}
+
+// initCountingRepo counts the inits, i.e. the fetches of the remote
+type initCountingRepo struct {
+	*repo.Mock
+	inits int
+}
+
+func (r *initCountingRepo) Init() error {
+	r.inits++
+	return r.Mock.Init()
+}
+
+func TestGitPendingStore(t *testing.T) {
+	r := &repo.Mock{Files: map[string]string{"main.ledger": ""}}
+	testPendingStore(t, func() PendingStore { return NewGitPendingStore(r) })
+	assert.Contains(t, r.Files, "pending.json")
+
+	t.Run("lookups read the snapshot", func(t *testing.T) {
+		r := &initCountingRepo{Mock: &repo.Mock{Files: map[string]string{"main.ledger": ""}}}
+		store := NewGitPendingStore(r)
+		require.NoError(t, store.Put(newTestPending("active", time.Time{})))
+		inits := r.inits
+
+		for i := 0; i < 3; i++ {
+			_, err := store.Get("active")
+			require.NoError(t, err)
+		}
+		assert.Equal(t, inits, r.inits)
+
+		require.NoError(t, store.Delete("active"))
+		_, err := store.Get("active")
+		assert.ErrorIs(t, err, ErrPendingNotFound)
+	})
+}
+
+// racingRepo lets another bot instance push right before its first push
+type racingRepo struct {
+	repo.Service
+	other func()
+}
+
+func (r *racingRepo) CommitPush(msg, name, email string) error {
+	if other := r.other; other != nil {
+		r.other = nil
+		other()
+	}
+	return r.Service.CommitPush(msg, name, email)
+}
+
+func TestGitPendingStore_TwoInstances(t *testing.T) {
+	remote := newBareRemote(t, "")
+	second := NewGitPendingStore(repo.NewInMemoryRepo(remote, repo.Auth{}))
+	first := NewGitPendingStore(&racingRepo{
+		Service: repo.NewInMemoryRepo(remote, repo.Auth{}),
+		other: func() {
+			require.NoError(t, second.Put(newTestPending("second", time.Time{})))
+		},
+	})
+
+	// the push of the first instance is rejected, its change is applied
+	// again on top of the pending transaction of the second one
+	require.NoError(t, first.Put(newTestPending("first", time.Time{})))
+
+	store := NewGitPendingStore(repo.NewInMemoryRepo(remote, repo.Auth{}))
+	for _, key := range []string{"first", "second"} {
+		_, err := store.Get(key)
+		assert.NoError(t, err, key)
+	}
+}
+
+func TestTeledger_StartSweeper(t *testing.T) {
+	tel := &Teledger{Pending: NewMemoryPendingStore()}
+
+	require.NoError(t, tel.Pending.Put(newTestPending("active", time.Now().Add(time.Hour))))
+	require.NoError(t, tel.Pending.Put(newTestPending("expired", time.Now())))
+
+	var mu sync.Mutex
+	var expired []string
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tel.StartSweeper(ctx, 10*time.Millisecond, func(pt *PendingTransaction) {
+		mu.Lock()
+		defer mu.Unlock()
+		expired = append(expired, pt.PendingKey)
+	})
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(expired) == 1 && expired[0] == "expired"
+	}, time.Second, 10*time.Millisecond)
+
+	_, err := tel.Pending.Get("active")
+	assert.NoError(t, err)
+}
@@ -1,32 +1,72 @@
 package teledger
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
 	"github.com/mput/teledger/app/ledger"
+	"github.com/mput/teledger/app/repo"
 )
 
 // Teledger is the service that handles all the
 // operations related to the Ledger files
 type Teledger struct {
-	Ledger                        *ledger.Ledger
-	WaitingToBeConfirmedResponses *map[string]*PendingTransaction
+	Ledger *ledger.Ledger
+	// Transactions waiting to be confirmed
+	Pending PendingStore
+	// How long a transaction waits for confirmation
+	PendingTTL time.Duration
+	// Pending keys being confirmed or updated right now
+	inProgress keyLocks
+	// Changes failed because the remote is unavailable, nil if they're not queued
+	Outbox *Outbox
 }
 
+// keyLocks is a set of keys locked by the handlers working with them,
+// the zero value is ready to use
+type keyLocks struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+// tryLock locks the key and reports whether it was not locked yet
+func (kl *keyLocks) tryLock(key string) bool {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	if kl.keys[key] {
+		return false
+	}
+	if kl.keys == nil {
+		kl.keys = make(map[string]bool)
+	}
+	kl.keys[key] = true
+	return true
+}
+
+func (kl *keyLocks) unlock(key string) {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	delete(kl.keys, key)
+}
+
+const DefaultPendingTTL = 24 * time.Hour
+
 func NewTeledger(ldgr *ledger.Ledger) *Teledger {
-	m := make(map[string]*PendingTransaction)
 	return &Teledger{
-		Ledger:                        ldgr,
-		WaitingToBeConfirmedResponses: &m,
+		Ledger:     ldgr,
+		Pending:    NewMemoryPendingStore(),
+		PendingTTL: DefaultPendingTTL,
 	}
 }
 
 // Add an arbitrary text as a comment to the main ledger file
 // The comment will be added at the end of the file, with a timestamp
 // and the template of the transaction at the end
-func (tel *Teledger) AddComment(comment string) (string, error) {
+func (tel *Teledger) AddComment(comment string, user ledger.User) (string, error) {
 	// TODO: move timezone to config
 	timezoneName := "GMT"
 	loc, err := time.LoadLocation(timezoneName)
@@ -43,20 +83,31 @@ func (tel *Teledger) AddComment(comment string) (string, error) {
 		now.Format("2006-01-02"),
 	)
 
-	res, err := tel.Ledger.AddComment(commitLine)
-	if err != nil {
-		return "", err
-	}
-	return res, nil
+	var res string
+	err = tel.write(OutboxEntry{Kind: OutboxComment, Text: commitLine, User: user}, func() (err error) {
+		res, err = tel.Ledger.AddComment(commitLine, user)
+		return err
+	})
+	return res, err
+}
+
+// Balance returns the balances of the accounts matching the query
+func (tel *Teledger) Balance(query ...string) ([]ledger.AccountBalance, error) {
+	return tel.Ledger.Balance(query...)
 }
 
-func (tel *Teledger) Balance() (string, error) {
-	return tel.Ledger.Execute("bal")
+// Register returns the postings matching the query with the running total
+func (tel *Teledger) Register(query ...string) ([]ledger.RegisterRow, error) {
+	return tel.Ledger.Register(query...)
 }
 
 func (tel *Teledger) Report(reportTitle string) (string, error) {
+	cfg := tel.Ledger.CurrentConfig()
+	if cfg == nil {
+		return "", fmt.Errorf("Report not found")
+	}
 	var reportArgs []string
-	for _, report := range tel.Ledger.Config.Reports {
+	for _, report := range cfg.Reports {
 		if report.Title == reportTitle {
 			reportArgs = report.Command
 			break
@@ -76,7 +127,13 @@ func (tel *Teledger) Init() error {
 type PendingTransaction struct {
 	ledger.ProposeTransactionRespones
 	PendingKey string
-	Mu         sync.Mutex
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	// Telegram message with the proposal
+	ChatID    int64
+	MessageID int64
+	// The remote is unavailable, the transaction is committed later
+	Queued bool
 }
 
 // Receive a short free-text description of a transaction
@@ -84,40 +141,189 @@ type PendingTransaction struct {
 // ledger file.
 // Store the transaction in a state, so the user can confirm
 // or reject it.
-func (tel *Teledger) ProposeTransaction(desc string) *PendingTransaction {
-	resp := tel.Ledger.AddOrProposeTransaction(desc, 2)
-	pt := PendingTransaction{
-		ProposeTransactionRespones: resp,
+func (tel *Teledger) ProposeTransaction(desc string, user ledger.User) *PendingTransaction {
+	pt := PendingTransaction{}
+	if ledger.IsRawTransaction(desc) {
+		// the transaction in the ledger format is committed right away,
+		// it's validated when it's committed
+		err := tel.write(OutboxEntry{Kind: OutboxAdd, Text: desc, User: user}, func() error {
+			pt.ProposeTransactionRespones = tel.Ledger.AddOrProposeTransaction(desc, user, 2)
+			return pt.Error
+		})
+		if errors.Is(err, ErrQueued) {
+			pt.UserProvidedTransaction = desc
+			pt.Error = nil
+			pt.Queued = true
+		} else if err != nil {
+			pt.Error = err
+		}
+		return &pt
 	}
+	resp := tel.Ledger.AddOrProposeTransaction(desc, user, 2)
+	pt.ProposeTransactionRespones = resp
 	if resp.Error == nil && resp.GeneratedTransaction != nil {
-		key := resp.GeneratedTransaction.RealDateTime.Format("2006-01-02 15:04:05.999 Mon")
-		pt.PendingKey = key
-		(*tel.WaitingToBeConfirmedResponses)[key] = &pt
+		// the key becomes the id of the transaction in the ledger
+		// and is used in the callback data of the telegram buttons
+		pt.PendingKey = ledger.NewTransactionID()
+		pt.CreatedAt = time.Now()
+		if tel.PendingTTL > 0 {
+			pt.ExpiresAt = pt.CreatedAt.Add(tel.PendingTTL)
+		}
+		err := tel.Pending.Put(&pt)
+		if err != nil {
+			pt.PendingKey = ""
+			pt.Error = fmt.Errorf("unable to store pending transaction: %v", err)
+		}
 	}
 	return &pt
 }
 
-func (tel *Teledger) ConfirmTransaction(pendingKey string) (*PendingTransaction, error) {
-	pendTr, ok := (*tel.WaitingToBeConfirmedResponses)[pendingKey]
-	if !ok {
-		return nil, fmt.Errorf("missing pending transaction: `%s`", pendingKey)
+// AttachMessage remembers the telegram message with the proposal,
+// so it can be updated when the transaction expires
+func (tel *Teledger) AttachMessage(pendingKey string, chatID, messageID int64) error {
+	if !tel.inProgress.tryLock(pendingKey) {
+		return fmt.Errorf("transaction confirmation already in progress: `%s`", pendingKey)
+	}
+	defer tel.inProgress.unlock(pendingKey)
+
+	pendTr, err := tel.Pending.Get(pendingKey)
+	if err != nil {
+		return err
+	}
+	pendTr.ChatID = chatID
+	pendTr.MessageID = messageID
+	return tel.Pending.Put(pendTr)
+}
+
+// AmendProposal corrects the pending transaction according to the user's
+// correction in natural language. The pending entry is updated in place,
+// so the same key is used to confirm the amended transaction.
+func (tel *Teledger) AmendProposal(pendingKey, correction string, user ledger.User) (*PendingTransaction, error) {
+	if !tel.inProgress.tryLock(pendingKey) {
+		return nil, fmt.Errorf("transaction confirmation already in progress: `%s`", pendingKey)
+	}
+	defer tel.inProgress.unlock(pendingKey)
+
+	pendTr, err := tel.Pending.Get(pendingKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: `%s`", err, pendingKey)
+	}
+
+	resp := tel.Ledger.AmendTransaction(*pendTr.GeneratedTransaction, correction, user, 2)
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	// the attempts of the original proposal are kept
+	resp.Attempts = append(pendTr.Attempts, resp.Attempts...)
+	pendTr.ProposeTransactionRespones = resp
+	if tel.PendingTTL > 0 {
+		pendTr.ExpiresAt = time.Now().Add(tel.PendingTTL)
 	}
-	locked := pendTr.Mu.TryLock()
-	if !locked {
+	err = tel.Pending.Put(pendTr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to store pending transaction: %v", err)
+	}
+	return pendTr, nil
+}
+
+// ConfirmTransaction adds the pending transaction to the ledger,
+// the commit is authored by the user who confirms it.
+// It's safe to call concurrently, only one of the calls
+// for the same key succeeds.
+func (tel *Teledger) ConfirmTransaction(pendingKey string, user ledger.User) (*PendingTransaction, error) {
+	if !tel.inProgress.tryLock(pendingKey) {
 		return nil, fmt.Errorf("transaction confirmation already in progress: `%s`", pendingKey)
 	}
-	defer pendTr.Mu.Unlock()
+	defer tel.inProgress.unlock(pendingKey)
 
-	err := tel.Ledger.AddTransactionWithID(pendTr.GeneratedTransaction.Format(true), pendingKey)
+	pendTr, err := tel.Pending.Get(pendingKey)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: `%s`", err, pendingKey)
 	}
 
-	pendTr.Committed = true
-	delete(*tel.WaitingToBeConfirmedResponses, pendingKey)
+	tr := pendTr.GeneratedTransaction.Format(true)
+	err = tel.write(OutboxEntry{
+		Kind:          OutboxAdd,
+		Text:          tr,
+		TransactionID: pendingKey,
+		User:          user,
+		MessageID:     pendTr.MessageID,
+	}, func() error {
+		return tel.Ledger.AddTransactionWithID(tr, pendingKey, user)
+	})
+	if err != nil {
+		if !errors.Is(err, ErrQueued) {
+			return nil, err
+		}
+		// the transaction is in the outbox, it can't be confirmed twice
+		pendTr.Queued = true
+	} else {
+		pendTr.Committed = true
+	}
+	err = tel.Pending.Delete(pendingKey)
+	if err != nil {
+		slog.Error("unable to delete pending transaction", "key", pendingKey, "error", err)
+	}
 	return pendTr, nil
 }
 
-func (tel *Teledger) DeleteTransaction(pendingKey string) error {
-	return tel.Ledger.DeleteTransactionWithID(pendingKey)
+func (tel *Teledger) DeleteTransaction(pendingKey string, user ledger.User) error {
+	return tel.write(OutboxEntry{Kind: OutboxDelete, TransactionID: pendingKey, User: user}, func() error {
+		return tel.Ledger.DeleteTransactionWithID(pendingKey, user)
+	})
+}
+
+// EditTransaction replaces the committed transaction with the corrected one,
+// the correction is either in natural language or in the ledger format.
+// Returns the new transaction.
+func (tel *Teledger) EditTransaction(id, correction string, user ledger.User) (string, error) {
+	var newTr string
+	err := tel.write(OutboxEntry{Kind: OutboxEdit, Text: correction, TransactionID: id, User: user}, func() (err error) {
+		newTr, err = tel.Ledger.EditTransactionWithID(id, correction, user, 2)
+		return err
+	})
+	return newTr, err
+}
+
+// RecentChanges returns the last changes made with teledger which can be undone
+func (tel *Teledger) RecentChanges(n int) ([]repo.Change, error) {
+	return tel.Ledger.RecentChanges(n)
+}
+
+// Undo reverts the change with a new commit, returns the reverted change
+func (tel *Teledger) Undo(hash string, user ledger.User) (repo.Change, error) {
+	var reverted repo.Change
+	err := tel.write(OutboxEntry{Kind: OutboxUndo, Text: hash, User: user}, func() (err error) {
+		reverted, err = tel.Ledger.Undo(hash, user)
+		return err
+	})
+	return reverted, err
+}
+
+// StartSweeper periodically removes expired pending transactions
+// and calls onExpire for each of them, until the context is canceled
+func (tel *Teledger) StartSweeper(ctx context.Context, interval time.Duration, onExpire func(*PendingTransaction)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				expired, err := tel.Pending.RemoveExpired(now)
+				if err != nil {
+					slog.Error("unable to remove expired pending transactions", "error", err)
+					continue
+				}
+				for _, pt := range expired {
+					slog.Info("pending transaction expired", "key", pt.PendingKey)
+					if onExpire != nil {
+						onExpire(pt)
+					}
+				}
+			}
+		}
+	}()
 }
@@ -1,6 +1,10 @@
 package teledger
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -8,6 +12,7 @@ import (
 	"github.com/mput/teledger/app/repo"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestTeledger_AddComment(t *testing.T) {
@@ -28,7 +33,7 @@ func TestTeledger_AddComment(t *testing.T) {
 			Ledger: l,
 		}
 
-		_, err := tldgr.AddComment("This is a comment\n multiline")
+		_, err := tldgr.AddComment("This is a comment\n multiline", ledger.User{})
 		assert.NoError(t, err)
 
 		content := r.Files["main.ledger"]
@@ -78,12 +83,12 @@ strict: true
 						Postings: []ledger.Posting{
 							{
 								Account:  "Assets:Cash",
-								Amount:   -10,
+								Amount:   ledger.NewDecimal(-10, 0),
 								Currency: "EUR",
 							},
 							{
 								Account:  "Food",
-								Amount:   10,
+								Amount:   ledger.NewDecimal(10, 0),
 								Currency: "EUR",
 							},
 						},
@@ -99,25 +104,24 @@ strict: true
 		l := ledger.NewLedger(r, mockedTransactionGenerator)
 
 		tldgr := NewTeledger(l)
-		resp := tldgr.ProposeTransaction("valid")
+		resp := tldgr.ProposeTransaction("valid", ledger.User{})
 		assert.NotEmpty(t, resp.PendingKey)
 		assert.Empty(t, resp.Error)
-		assert.NotEmpty(t, resp.PendingKey)
+		assert.Regexp(t, `^[0-9a-z]{16}$`, resp.PendingKey)
 
 		t.Run("attempt to concurrently confirm the same transaction", func(t *testing.T) {
-			(*tldgr.WaitingToBeConfirmedResponses)[resp.PendingKey].Mu.Lock()
-			_, err := tldgr.ConfirmTransaction(resp.PendingKey)
+			assert.True(t, tldgr.inProgress.tryLock(resp.PendingKey))
+			_, err := tldgr.ConfirmTransaction(resp.PendingKey, ledger.User{})
 			assert.ErrorContains(t, err, "already in progress")
-			(*tldgr.WaitingToBeConfirmedResponses)[resp.PendingKey].Mu.Unlock()
+			tldgr.inProgress.unlock(resp.PendingKey)
 		})
 
 		t.Run("Success Confirmation", func(t *testing.T) {
-			_, err := tldgr.ConfirmTransaction(resp.PendingKey)
+			_, err := tldgr.ConfirmTransaction(resp.PendingKey, ledger.User{})
 			assert.Empty(t, err)
 
 			assert.Equal(
 				t,
-				r.Files["main.ledger"],
 				`
 account Food
 account Assets:Cash
@@ -128,27 +132,28 @@ commodity EUR
   Assets:Cash  100.00 EUR
   Equity
 
-;; tid:2014-11-30 11:45:26.371 Sun
 ;; valid
 2014-11-30 * My tr
+    ; tid: `+resp.PendingKey+`
     Assets:Cash  -10.00 EUR
     Food  10.00 EUR
 `,
+				r.Files["main.ledger"],
 			)
 		})
 
 		t.Run("attempt to confirm for the second time", func(t *testing.T) {
-			_, err := tldgr.ConfirmTransaction(resp.PendingKey)
+			_, err := tldgr.ConfirmTransaction(resp.PendingKey, ledger.User{})
 			assert.ErrorContains(t, err, "missing pending transaction")
 		})
 
 		t.Run("attempt to confirm with unknonw key", func(t *testing.T) {
-			_, err := tldgr.ConfirmTransaction("unk")
+			_, err := tldgr.ConfirmTransaction("unk", ledger.User{})
 			assert.ErrorContains(t, err, "missing pending transaction")
 		})
 
 		t.Run("delete previously confirmed transaction", func(t *testing.T) {
-			err := tldgr.DeleteTransaction(resp.PendingKey)
+			err := tldgr.DeleteTransaction(resp.PendingKey, ledger.User{})
 			assert.Empty(t, err)
 
 			assert.Equal(
@@ -159,7 +164,7 @@ commodity EUR
 		})
 
 		t.Run("delete unknown transaction", func(t *testing.T) {
-			err := tldgr.DeleteTransaction("unknowntrr")
+			err := tldgr.DeleteTransaction("unknowntrr", ledger.User{})
 			assert.ErrorContains(t, err, "no transaction with id")
 
 			assert.Equal(
@@ -203,7 +208,7 @@ commodity EUR
 			}
 
 			t.Run("transaction in the middle", func(t *testing.T) {
-				err := tldgr.DeleteTransaction("2014-11-30 11:45:26.111 Sun")
+				err := tldgr.DeleteTransaction("2014-11-30 11:45:26.111 Sun", ledger.User{})
 				assert.Empty(t, err)
 
 				assert.Equal(
@@ -228,7 +233,7 @@ commodity EUR
 			})
 
 			t.Run("repeating transaction", func(t *testing.T) {
-				err := tldgr.DeleteTransaction("2014-11-30 11:45:26.371 Sun")
+				err := tldgr.DeleteTransaction("2014-11-30 11:45:26.371 Sun", ledger.User{})
 				assert.Empty(t, err)
 
 				assert.Equal(
@@ -252,10 +257,199 @@ commodity EUR
 2014-11-30 * My tr
     Assets:Cash  -10.00 EUR
     Food  10.00 EUR
-`)
+`, ledger.User{})
 			assert.Empty(t, resp.PendingKey)
 			assert.Equal(t, 0, resp.AttemptNumber)
 			assert.Empty(t, resp.Error)
 		})
 	})
 }
+
+func TestTeledger_Concurrency(t *testing.T) {
+	initContent := `
+account Food
+account Assets:Cash
+account Equity
+commodity EUR
+
+2024-02-13 * Test
+  Assets:Cash  100.00 EUR
+  Equity
+`
+	const configYaml = `
+strict: true
+reports:
+  - title: Balance
+    command: [bal]
+`
+
+	r := &repo.Mock{
+		Files: map[string]string{"main.ledger": initContent, "teledger.yaml": configYaml},
+	}
+
+	base, _ := time.Parse(time.RFC3339, "2014-11-30T11:45:26Z")
+	gen := &ledger.TransactionGeneratorMock{
+		GenerateTransactionFunc: func(prmt ledger.PromptCtx) (ledger.Transaction, error) {
+			return ledger.Transaction{
+				// the same time for all proposals, keys must not collide anyway
+				RealDateTime: base,
+				Description:  "My tr",
+				Comment:      prmt.UserInput,
+				Postings: []ledger.Posting{
+					{Account: "Assets:Cash", Amount: ledger.NewDecimal(-10, 0), Currency: "EUR"},
+					{Account: "Food", Amount: ledger.NewDecimal(10, 0), Currency: "EUR"},
+				},
+			}, nil
+		},
+	}
+
+	tldgr := NewTeledger(ledger.NewLedger(r, gen))
+
+	const proposals = 10
+	responses := make([]*PendingTransaction, proposals)
+
+	var wg sync.WaitGroup
+	for i := 0; i < proposals; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = tldgr.ProposeTransaction(fmt.Sprintf("tr %d", i), ledger.User{})
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < proposals; i++ {
+			_ = tldgr.Ledger.CurrentConfig()
+			_, _ = tldgr.Report("Balance")
+		}
+	}()
+	wg.Wait()
+
+	// require can't be used in the goroutines
+	keys := make([]string, 0, proposals)
+	for _, resp := range responses {
+		require.NoError(t, resp.Error)
+		require.NotEmpty(t, resp.PendingKey)
+		keys = append(keys, resp.PendingKey)
+	}
+
+	var confirmed atomic.Int64
+	var confirmedKeys []string
+	var mu sync.Mutex
+	for _, key := range keys {
+		// confirm every transaction twice, only one confirmation succeeds
+		for j := 0; j < 2; j++ {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				_ = tldgr.AttachMessage(key, 1, 2)
+				if _, err := tldgr.ConfirmTransaction(key, ledger.User{}); err == nil {
+					confirmed.Add(1)
+					mu.Lock()
+					confirmedKeys = append(confirmedKeys, key)
+					mu.Unlock()
+				}
+			}(key)
+		}
+	}
+	wg.Wait()
+
+	require.EqualValues(t, proposals, confirmed.Load())
+	require.Len(t, confirmedKeys, proposals)
+	assert.Equal(t, proposals, strings.Count(r.Files["main.ledger"], "; tid: "))
+
+	// delete half of the transactions concurrently
+	for _, key := range confirmedKeys[:proposals/2] {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			assert.NoError(t, tldgr.DeleteTransaction(key, ledger.User{}))
+		}(key)
+	}
+	wg.Wait()
+
+	assert.Equal(t, proposals/2, strings.Count(r.Files["main.ledger"], "; tid: "))
+}
+
+func TestTeledger_EditTransaction(t *testing.T) {
+	initContent := `
+account Food
+account Assets:Cash
+account Assets:Card
+commodity EUR
+
+;; valid
+2014-11-30 * My tr
+    ; tid: 0123456789abcdef
+    Assets:Cash  -10.00 EUR
+    Food  10.00 EUR
+`
+	r := &repo.Mock{
+		Files: map[string]string{"main.ledger": initContent},
+	}
+	tldgr := NewTeledger(ledger.NewLedger(r, nil))
+
+	newTr, err := tldgr.EditTransaction("0123456789abcdef", "2014-11-30 * My tr\n    Assets:Card  -12.50 EUR\n    Food", ledger.User{})
+	assert.NoError(t, err)
+	assert.Equal(t, ";; valid\n2014-11-30 * My tr\n    Assets:Card  -12.50 EUR\n    Food", newTr)
+	assert.Contains(t, r.Files["main.ledger"], "    ; tid: 0123456789abcdef\n    Assets:Card  -12.50 EUR\n")
+
+	// the edited transaction is still deletable
+	assert.NoError(t, tldgr.DeleteTransaction("0123456789abcdef", ledger.User{}))
+	assert.NotContains(t, r.Files["main.ledger"], "My tr")
+}
+
+func TestTeledger_AmendProposal(t *testing.T) {
+	initContent := `
+account Food
+account Assets:Cash
+account Assets:Card
+commodity EUR
+`
+	r := &repo.Mock{
+		Files: map[string]string{"main.ledger": initContent},
+	}
+	gen := &ledger.TransactionGeneratorMock{
+		GenerateTransactionFunc: func(prmt ledger.PromptCtx) (ledger.Transaction, error) {
+			account, amount := "Assets:Cash", ledger.NewDecimal(10, 0)
+			if prmt.Amendment != nil {
+				account, amount = "Assets:Card", ledger.NewDecimal(125, 1)
+			}
+			return ledger.Transaction{
+				RealDateTime: prmt.Datetime,
+				Description:  "Tacos",
+				Comment:      prmt.UserInput,
+				Postings: []ledger.Posting{
+					{Account: account, Amount: amount.Neg(), Currency: "EUR"},
+					{Account: "Food", Amount: amount, Currency: "EUR"},
+				},
+			}, nil
+		},
+	}
+	tldgr := NewTeledger(ledger.NewLedger(r, gen))
+
+	resp := tldgr.ProposeTransaction("10 tacos", ledger.User{})
+	assert.NoError(t, resp.Error)
+	assert.NoError(t, tldgr.AttachMessage(resp.PendingKey, 1, 2))
+
+	amended, err := tldgr.AmendProposal(resp.PendingKey, "no, it was 12.50 and paid by card", ledger.User{})
+	assert.NoError(t, err)
+	assert.Equal(t, resp.PendingKey, amended.PendingKey)
+	assert.Equal(t, int64(2), amended.MessageID)
+	assert.Equal(t, "Assets:Card", amended.GeneratedTransaction.Postings[0].Account)
+	require.Len(t, amended.Attempts, 2, "the attempts of the proposal are kept")
+	assert.Equal(t, "Assets:Cash", amended.Attempts[0].Transaction.Postings[0].Account)
+
+	pending, err := tldgr.Pending.Get(resp.PendingKey)
+	require.NoError(t, err)
+	assert.Len(t, pending.Attempts, 2)
+
+	_, err = tldgr.AmendProposal("unknown", "it was 12.50", ledger.User{})
+	assert.ErrorIs(t, err, ErrPendingNotFound)
+
+	_, err = tldgr.ConfirmTransaction(resp.PendingKey, ledger.User{})
+	assert.NoError(t, err)
+	assert.Contains(t, r.Files["main.ledger"], ";; 10 tacos\n;; no, it was 12.50 and paid by card\n")
+	assert.Contains(t, r.Files["main.ledger"], "    Assets:Card  -12.5 EUR\n")
+}